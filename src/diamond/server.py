@@ -60,6 +60,59 @@ class Server(object):
         if setproctitle:
             setproctitle(oldproctitle)
 
+    def initialize_collector(self, collectors, process_name):
+        """
+        Create the collector process_name with its config file, returns None
+        if it can not be loaded
+        """
+        collector_classes = dict(
+            (cls.__name__.split('.')[-1], cls)
+            for cls in collectors.values()
+        )
+
+        # To handle running multiple collectors concurrently, we
+        # split on white space and use the first word as the
+        # collector name to spin
+        collector_name = process_name.split()[0]
+
+        if collector_name not in collector_classes:
+            self.log.error('Can not find collector %s', collector_name)
+            return None
+
+        # Since collector names can be defined with a space in order to instantiate multiple
+        # instances of the same collector, we want their files
+        # will not have that space and needs to have it replaced with an underscore
+        # instead
+        configfile = '/'.join([
+            self.config['collectorsConfigPath'], process_name]).replace(' ', '_') + '.conf'
+        configfile = load_config(configfile)
+        collector = initialize_collector(
+            collector_classes[collector_name],
+            name=process_name,
+            config=self.config,
+            configfile=configfile,
+            handlers=[])
+
+        if collector is None:
+            self.log.error('Failed to load collector %s', process_name)
+        return collector
+
+    def run_collector(self, process_name):
+        """
+        Run a single collector in this process until it fails. This is used
+        when fullerite supervises the collector processes itself.
+        """
+        self.config = load_config(self.configfile)
+        collectors = load_collectors(self.config['diamondCollectorsPath'])
+
+        collector = self.initialize_collector(collectors, process_name)
+        if collector is None:
+            sys.exit(1)
+
+        collector_process(collector, self.log)
+        # collector_process only returns if the collector failed
+        sys.exit(1)
+
     def run(self):
         """
         Load handler and collector classes and then start collectors
@@ -106,42 +159,13 @@ class Server(object):
                         if process.name == process_name:
                             process.terminate()
 
-                collector_classes = dict(
-                    (cls.__name__.split('.')[-1], cls)
-                    for cls in collectors.values()
-                )
-
                 for process_name in running_collectors - running_processes:
-                    # To handle running multiple collectors concurrently, we
-                    # split on white space and use the first word as the
-                    # collector name to spin
-                    collector_name = process_name.split()[0]
-
-                    if 'Collector' not in collector_name:
+                    if 'Collector' not in process_name.split()[0]:
                         continue
 
-                    if collector_name not in collector_classes:
-                        self.log.error('Can not find collector %s',
-                                       collector_name)
-                        continue
-
-                    # Since collector names can be defined with a space in order to instantiate multiple
-                    # instances of the same collector, we want their files
-                    # will not have that space and needs to have it replaced with an underscore
-                    # instead
-                    configfile = '/'.join([
-                        self.config['collectorsConfigPath'], process_name]).replace(' ', '_') + '.conf'
-                    configfile = load_config(configfile)
-                    collector = initialize_collector(
-                        collector_classes[collector_name],
-                        name=process_name,
-                        config=self.config,
-                        configfile=configfile,
-                        handlers=[])
-
+                    collector = self.initialize_collector(collectors,
+                                                          process_name)
                     if collector is None:
-                        self.log.error('Failed to load collector %s',
-                                       process_name)
                         continue
 
                     # Splay the loads
@@ -181,6 +205,8 @@ def main():
     parser.add_option("-f",
                       "--log_config",
                       help="Configure logging with the specified file")
+    parser.add_option("--collector",
+                      help="Only run this collector in the foreground")
     (options, args) = parser.parse_args()
 
     logging.basicConfig(level=logging.getLevelName(options.log_level or 'INFO'),
@@ -188,7 +214,11 @@ def main():
     if options.log_config:
         logging.config.fileConfig(options.log_config)
 
-    Server(options.config_file).run()
+    server = Server(options.config_file)
+    if options.collector:
+        server.run_collector(options.collector)
+    else:
+        server.run()
 
 if __name__ == "__main__":
     main()
@@ -0,0 +1,378 @@
+package collector
+
+import (
+	"fullerite/config"
+	"fullerite/metric"
+
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	l "github.com/Sirupsen/logrus"
+)
+
+const (
+	// DefaultCgroupRoot is where the cgroup filesystem is mounted
+	DefaultCgroupRoot = "/sys/fs/cgroup"
+
+	// cgroup v1 reports cpuacct.stat in USER_HZ ticks
+	cgroupUserHZ = 100
+
+	// cgroup v1 reports an unlimited memory limit as a huge page aligned number
+	cgroupUnlimited = uint64(1) << 62
+)
+
+// defaultCgroupPaths match docker containers and systemd services
+var defaultCgroupPaths = []string{"docker/*", "system.slice/*.service", "system.slice/docker-*.scope"}
+
+var containerIDRegex = regexp.MustCompile(`([0-9a-f]{64})`)
+
+// Cgroup collector type.
+// It reads the accounting files of the cgroups matching paths directly, which
+// is cheaper than the docker stats API and also covers systemd services.
+// paths are glob patterns relative to the hierarchy of every controller (v1)
+// or to the unified hierarchy (v2).
+type Cgroup struct {
+	baseCollector
+	root  string
+	paths []string
+}
+
+// cgroupV1Reader reads the metrics of a single controller directory
+type cgroupV1Reader func(dir string) []metric.Metric
+
+func init() {
+	RegisterCollector("Cgroup", newCgroup)
+}
+
+// newCgroup creates a new Cgroup collector.
+func newCgroup(channel chan metric.Metric, initialInterval int, log *l.Entry) Collector {
+	c := new(Cgroup)
+
+	c.log = log
+	c.channel = channel
+	c.interval = initialInterval
+
+	c.name = "Cgroup"
+	c.root = DefaultCgroupRoot
+	c.paths = defaultCgroupPaths
+	return c
+}
+
+// Configure takes a dictionary of values with which the collector can configure itself.
+func (c *Cgroup) Configure(configMap map[string]interface{}) {
+	if root, exists := configMap["root"]; exists {
+		c.root = root.(string)
+	}
+	if paths, exists := configMap["paths"]; exists {
+		c.paths = config.GetAsSlice(paths)
+	}
+	c.configureCommonParams(configMap)
+}
+
+// Collect reads all matching cgroups and sends the metrics.
+func (c *Cgroup) Collect() {
+	for _, m := range c.collectCgroups() {
+		c.Channel() <- m
+	}
+}
+
+// isUnified reports whether root is a cgroup v2 hierarchy
+func (c *Cgroup) isUnified() bool {
+	_, err := os.Stat(filepath.Join(c.root, "cgroup.controllers"))
+	return err == nil
+}
+
+func (c *Cgroup) collectCgroups() []metric.Metric {
+	metrics := []metric.Metric{}
+	if c.isUnified() {
+		for _, dir := range c.matchingCgroups(c.root) {
+			metrics = append(metrics, c.withDimensions(c.root, dir, readCgroupV2(dir))...)
+		}
+		return metrics
+	}
+
+	readers := map[string]cgroupV1Reader{
+		"cpuacct": readCgroupV1Cpuacct,
+		"cpu":     readCgroupV1Cpu,
+		"memory":  readCgroupV1Memory,
+		"blkio":   readCgroupV1Blkio,
+		"pids":    readCgroupPids,
+	}
+	for controller, reader := range readers {
+		hierarchy := filepath.Join(c.root, controller)
+		for _, dir := range c.matchingCgroups(hierarchy) {
+			metrics = append(metrics, c.withDimensions(hierarchy, dir, reader(dir))...)
+		}
+	}
+	return metrics
+}
+
+// matchingCgroups returns the cgroup directories below hierarchy matching paths.
+func (c *Cgroup) matchingCgroups(hierarchy string) []string {
+	dirs := []string{}
+	for _, pattern := range c.paths {
+		matches, err := filepath.Glob(filepath.Join(hierarchy, pattern))
+		if err != nil {
+			c.log.Warn("Invalid cgroup path pattern ", pattern, ": ", err)
+			continue
+		}
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && info.IsDir() {
+				dirs = append(dirs, match)
+			}
+		}
+	}
+	return dirs
+}
+
+func (c *Cgroup) withDimensions(hierarchy, dir string, metrics []metric.Metric) []metric.Metric {
+	rel, err := filepath.Rel(hierarchy, dir)
+	if err != nil {
+		rel = dir
+	}
+	now := time.Now()
+	for i := range metrics {
+		metrics[i].SetTime(now)
+	}
+	metric.AddToAll(&metrics, cgroupDimensions(rel))
+	return metrics
+}
+
+// cgroupDimensions maps a cgroup path to a container_id for containers, to a
+// unit for systemd units and to the path itself otherwise.
+func cgroupDimensions(rel string) map[string]string {
+	base := filepath.Base(rel)
+	if match := containerIDRegex.FindStringSubmatch(base); match != nil {
+		return map[string]string{"container_id": match[1]}
+	}
+	for _, suffix := range []string{".service", ".scope", ".slice", ".socket"} {
+		if strings.HasSuffix(base, suffix) {
+			return map[string]string{"unit": base}
+		}
+	}
+	return map[string]string{"cgroup": rel}
+}
+
+func readCgroupV1Cpuacct(dir string) []metric.Metric {
+	metrics := []metric.Metric{}
+	if usage, err := readCgroupValue(filepath.Join(dir, "cpuacct.usage")); err == nil {
+		metrics = append(metrics, cgroupMetric("CgroupCpuUsage", metric.CumulativeCounter, float64(usage)/1e9))
+	}
+	if stat, err := readCgroupStat(filepath.Join(dir, "cpuacct.stat")); err == nil {
+		metrics = append(metrics,
+			cgroupMetric("CgroupCpuUser", metric.CumulativeCounter, float64(stat["user"])/cgroupUserHZ),
+			cgroupMetric("CgroupCpuSystem", metric.CumulativeCounter, float64(stat["system"])/cgroupUserHZ),
+		)
+	}
+	return metrics
+}
+
+func readCgroupV1Cpu(dir string) []metric.Metric {
+	stat, err := readCgroupStat(filepath.Join(dir, "cpu.stat"))
+	if err != nil {
+		return nil
+	}
+	return []metric.Metric{
+		cgroupMetric("CgroupCpuPeriods", metric.CumulativeCounter, float64(stat["nr_periods"])),
+		cgroupMetric("CgroupCpuThrottledPeriods", metric.CumulativeCounter, float64(stat["nr_throttled"])),
+		cgroupMetric("CgroupCpuThrottledTime", metric.CumulativeCounter, float64(stat["throttled_time"])/1e9),
+	}
+}
+
+func readCgroupV1Memory(dir string) []metric.Metric {
+	metrics := []metric.Metric{}
+	if usage, err := readCgroupValue(filepath.Join(dir, "memory.usage_in_bytes")); err == nil {
+		metrics = append(metrics, cgroupMetric("CgroupMemoryUsage", metric.Gauge, float64(usage)))
+	}
+	if limit, err := readCgroupValue(filepath.Join(dir, "memory.limit_in_bytes")); err == nil && limit < cgroupUnlimited {
+		metrics = append(metrics, cgroupMetric("CgroupMemoryLimit", metric.Gauge, float64(limit)))
+	}
+	if stat, err := readCgroupStat(filepath.Join(dir, "memory.stat")); err == nil {
+		metrics = append(metrics,
+			cgroupMetric("CgroupMemoryCache", metric.Gauge, float64(stat["cache"])),
+			cgroupMetric("CgroupMemoryRss", metric.Gauge, float64(stat["rss"])),
+			cgroupMetric("CgroupMemorySwap", metric.Gauge, float64(stat["swap"])),
+			cgroupMetric("CgroupMemoryPgfault", metric.CumulativeCounter, float64(stat["pgfault"])),
+			cgroupMetric("CgroupMemoryPgmajfault", metric.CumulativeCounter, float64(stat["pgmajfault"])),
+		)
+	}
+	return metrics
+}
+
+// readCgroupV1Blkio reads the "8:0 Read 4096" lines of the throttle files,
+// which unlike the CFQ files are also accounted without the CFQ scheduler.
+func readCgroupV1Blkio(dir string) []metric.Metric {
+	metrics := []metric.Metric{}
+	files := map[string]string{
+		"blkio.throttle.io_service_bytes": "CgroupBlkioBytes",
+		"blkio.throttle.io_serviced":      "CgroupBlkioOps",
+	}
+	for file, name := range files {
+		lines, err := readCgroupLines(filepath.Join(dir, file))
+		if err != nil {
+			continue
+		}
+		for _, fields := range lines {
+			if len(fields) != 3 {
+				continue
+			}
+			op := strings.ToLower(fields[1])
+			if op != "read" && op != "write" {
+				continue
+			}
+			value, err := strconv.ParseUint(fields[2], 10, 64)
+			if err != nil {
+				continue
+			}
+			m := cgroupMetric(name, metric.CumulativeCounter, float64(value))
+			m.AddDimension("device", fields[0])
+			m.AddDimension("op", op)
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics
+}
+
+func readCgroupPids(dir string) []metric.Metric {
+	pids, err := readCgroupValue(filepath.Join(dir, "pids.current"))
+	if err != nil {
+		return nil
+	}
+	return []metric.Metric{cgroupMetric("CgroupPids", metric.Gauge, float64(pids))}
+}
+
+// readCgroupV2 reads all controllers of a cgroup in the unified hierarchy.
+func readCgroupV2(dir string) []metric.Metric {
+	metrics := readCgroupPids(dir)
+	if stat, err := readCgroupStat(filepath.Join(dir, "cpu.stat")); err == nil {
+		metrics = append(metrics,
+			cgroupMetric("CgroupCpuUsage", metric.CumulativeCounter, float64(stat["usage_usec"])/1e6),
+			cgroupMetric("CgroupCpuUser", metric.CumulativeCounter, float64(stat["user_usec"])/1e6),
+			cgroupMetric("CgroupCpuSystem", metric.CumulativeCounter, float64(stat["system_usec"])/1e6),
+		)
+		// only present if the cpu controller is enabled
+		if _, exists := stat["nr_periods"]; exists {
+			metrics = append(metrics,
+				cgroupMetric("CgroupCpuPeriods", metric.CumulativeCounter, float64(stat["nr_periods"])),
+				cgroupMetric("CgroupCpuThrottledPeriods", metric.CumulativeCounter, float64(stat["nr_throttled"])),
+				cgroupMetric("CgroupCpuThrottledTime", metric.CumulativeCounter, float64(stat["throttled_usec"])/1e6),
+			)
+		}
+	}
+	if usage, err := readCgroupValue(filepath.Join(dir, "memory.current")); err == nil {
+		metrics = append(metrics, cgroupMetric("CgroupMemoryUsage", metric.Gauge, float64(usage)))
+	}
+	// memory.max is "max" without a limit
+	if limit, err := readCgroupValue(filepath.Join(dir, "memory.max")); err == nil {
+		metrics = append(metrics, cgroupMetric("CgroupMemoryLimit", metric.Gauge, float64(limit)))
+	}
+	if swap, err := readCgroupValue(filepath.Join(dir, "memory.swap.current")); err == nil {
+		metrics = append(metrics, cgroupMetric("CgroupMemorySwap", metric.Gauge, float64(swap)))
+	}
+	if stat, err := readCgroupStat(filepath.Join(dir, "memory.stat")); err == nil {
+		metrics = append(metrics,
+			cgroupMetric("CgroupMemoryCache", metric.Gauge, float64(stat["file"])),
+			cgroupMetric("CgroupMemoryRss", metric.Gauge, float64(stat["anon"])),
+			cgroupMetric("CgroupMemoryPgfault", metric.CumulativeCounter, float64(stat["pgfault"])),
+			cgroupMetric("CgroupMemoryPgmajfault", metric.CumulativeCounter, float64(stat["pgmajfault"])),
+		)
+	}
+	return append(metrics, readCgroupV2IO(dir)...)
+}
+
+// readCgroupV2IO reads the "8:0 rbytes=1 wbytes=2 rios=3 wios=4 ..." lines of io.stat
+func readCgroupV2IO(dir string) []metric.Metric {
+	lines, err := readCgroupLines(filepath.Join(dir, "io.stat"))
+	if err != nil {
+		return nil
+	}
+	keys := map[string][2]string{
+		"rbytes": {"CgroupBlkioBytes", "read"},
+		"wbytes": {"CgroupBlkioBytes", "write"},
+		"rios":   {"CgroupBlkioOps", "read"},
+		"wios":   {"CgroupBlkioOps", "write"},
+	}
+	metrics := []metric.Metric{}
+	for _, fields := range lines {
+		if len(fields) < 2 {
+			continue
+		}
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			key, known := keys[kv[0]]
+			if !known {
+				continue
+			}
+			value, err := strconv.ParseUint(kv[1], 10, 64)
+			if err != nil {
+				continue
+			}
+			m := cgroupMetric(key[0], metric.CumulativeCounter, float64(value))
+			m.AddDimension("device", fields[0])
+			m.AddDimension("op", key[1])
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics
+}
+
+func cgroupMetric(name string, metricType string, value float64) metric.Metric {
+	m := metric.New(name)
+	m.MetricType = metricType
+	m.Value = value
+	return m
+}
+
+// readCgroupValue reads a file containing a single number.
+func readCgroupValue(path string) (uint64, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(raw)), 10, 64)
+}
+
+// readCgroupStat reads a flat keyed file such as cpu.stat or memory.stat.
+func readCgroupStat(path string) (map[string]uint64, error) {
+	lines, err := readCgroupLines(path)
+	if err != nil {
+		return nil, err
+	}
+	stat := make(map[string]uint64, len(lines))
+	for _, fields := range lines {
+		if len(fields) != 2 {
+			continue
+		}
+		if value, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			stat[fields[0]] = value
+		}
+	}
+	return stat, nil
+}
+
+// readCgroupLines returns the whitespace separated fields of every line.
+func readCgroupLines(path string) ([][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	lines := [][]string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) > 0 {
+			lines = append(lines, fields)
+		}
+	}
+	return lines, scanner.Err()
+}
@@ -0,0 +1,161 @@
+package collector
+
+import (
+	"fullerite/metric"
+	"test_utils"
+
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testContainerID = "4ac0b3b1e4e5c9a1b8e7a6f5d4c3b2a1908f7e6d5c4b3a2918f7e6d5c4b3a291"
+
+var testCgroupV1Fixture = map[string]string{
+	"cpuacct/docker/" + testContainerID + "/cpuacct.usage":                 "2500000000\n",
+	"cpuacct/docker/" + testContainerID + "/cpuacct.stat":                  "user 150\nsystem 50\n",
+	"cpu/docker/" + testContainerID + "/cpu.stat":                          "nr_periods 100\nnr_throttled 7\nthrottled_time 3000000000\n",
+	"memory/docker/" + testContainerID + "/memory.usage_in_bytes":          "1048576\n",
+	"memory/docker/" + testContainerID + "/memory.limit_in_bytes":          "9223372036854771712\n",
+	"memory/docker/" + testContainerID + "/memory.stat":                    "cache 4096\nrss 8192\nswap 0\npgfault 12\npgmajfault 1\n",
+	"blkio/docker/" + testContainerID + "/blkio.throttle.io_service_bytes": "8:0 Read 4096\n8:0 Write 1024\n8:0 Total 5120\nTotal 5120\n",
+	"blkio/docker/" + testContainerID + "/blkio.throttle.io_serviced":      "8:0 Read 2\n8:0 Write 1\n",
+	"pids/docker/" + testContainerID + "/pids.current":                     "5\n",
+	"memory/system.slice/nginx.service/memory.usage_in_bytes":              "2048\n",
+	"memory/system.slice/nginx.service/memory.limit_in_bytes":              "4096\n",
+	"memory/user.slice/memory.usage_in_bytes":                              "1\n",
+}
+
+var testCgroupV2Fixture = map[string]string{
+	"cgroup.controllers": "cpu io memory pids\n",
+	"system.slice/docker-" + testContainerID + ".scope/cpu.stat": "usage_usec 2500000\nuser_usec 1500000\nsystem_usec 500000\n" +
+		"nr_periods 100\nnr_throttled 7\nthrottled_usec 3000000\n",
+	"system.slice/docker-" + testContainerID + ".scope/memory.current":      "1048576\n",
+	"system.slice/docker-" + testContainerID + ".scope/memory.max":          "max\n",
+	"system.slice/docker-" + testContainerID + ".scope/memory.swap.current": "0\n",
+	"system.slice/docker-" + testContainerID + ".scope/memory.stat":         "anon 8192\nfile 4096\npgfault 12\npgmajfault 1\n",
+	"system.slice/docker-" + testContainerID + ".scope/io.stat":             "8:0 rbytes=4096 wbytes=1024 rios=2 wios=1 dbytes=0 dios=0\n",
+	"system.slice/docker-" + testContainerID + ".scope/pids.current":        "5\n",
+	"system.slice/nginx.service/memory.current":                             "2048\n",
+}
+
+// writeCgroupFixture creates a fake cgroup filesystem and returns its root.
+func writeCgroupFixture(t *testing.T, files map[string]string) string {
+	root, err := ioutil.TempDir("", "fullerite_cgroup")
+	require.Nil(t, err)
+	for path, content := range files {
+		full := filepath.Join(root, path)
+		require.Nil(t, os.MkdirAll(filepath.Dir(full), 0755))
+		require.Nil(t, ioutil.WriteFile(full, []byte(content), 0644))
+	}
+	return root
+}
+
+func getTestCgroup(root string) *Cgroup {
+	c := newCgroup(nil, 10, test_utils.BuildLogger()).(*Cgroup)
+	c.Configure(map[string]interface{}{"root": root})
+	return c
+}
+
+func TestCgroupConfigure(t *testing.T) {
+	c := newCgroup(nil, 12, nil).(*Cgroup)
+	c.Configure(map[string]interface{}{})
+	assert.Equal(t, DefaultCgroupRoot, c.root)
+	assert.Equal(t, defaultCgroupPaths, c.paths)
+
+	c.Configure(map[string]interface{}{
+		"root":  "/tmp/cgroup",
+		"paths": []interface{}{"kubepods/*/*"},
+	})
+	assert.Equal(t, "/tmp/cgroup", c.root)
+	assert.Equal(t, []string{"kubepods/*/*"}, c.paths)
+}
+
+func TestCgroupDimensions(t *testing.T) {
+	assert.Equal(t, map[string]string{"container_id": testContainerID}, cgroupDimensions("docker/"+testContainerID))
+	assert.Equal(t, map[string]string{"container_id": testContainerID},
+		cgroupDimensions("system.slice/docker-"+testContainerID+".scope"))
+	assert.Equal(t, map[string]string{"unit": "nginx.service"}, cgroupDimensions("system.slice/nginx.service"))
+	assert.Equal(t, map[string]string{"cgroup": "lxc/web"}, cgroupDimensions("lxc/web"))
+}
+
+func assertCgroupMetric(t *testing.T, metrics []metric.Metric, name string, dims map[string]string, metricType string, value float64) {
+	m, found := findMetric(metrics, name, dims)
+	if assert.True(t, found, name) {
+		assert.Equal(t, metricType, m.MetricType, name)
+		assert.Equal(t, value, m.Value, name)
+	}
+}
+
+func testCgroupMetrics(t *testing.T, metrics []metric.Metric) {
+	container := map[string]string{"container_id": testContainerID}
+	assertCgroupMetric(t, metrics, "CgroupCpuUsage", container, metric.CumulativeCounter, 2.5)
+	assertCgroupMetric(t, metrics, "CgroupCpuUser", container, metric.CumulativeCounter, 1.5)
+	assertCgroupMetric(t, metrics, "CgroupCpuSystem", container, metric.CumulativeCounter, 0.5)
+	assertCgroupMetric(t, metrics, "CgroupCpuThrottledPeriods", container, metric.CumulativeCounter, 7)
+	assertCgroupMetric(t, metrics, "CgroupCpuThrottledTime", container, metric.CumulativeCounter, 3)
+	assertCgroupMetric(t, metrics, "CgroupMemoryUsage", container, metric.Gauge, 1048576)
+	assertCgroupMetric(t, metrics, "CgroupMemoryCache", container, metric.Gauge, 4096)
+	assertCgroupMetric(t, metrics, "CgroupMemoryRss", container, metric.Gauge, 8192)
+	assertCgroupMetric(t, metrics, "CgroupMemoryPgfault", container, metric.CumulativeCounter, 12)
+	assertCgroupMetric(t, metrics, "CgroupPids", container, metric.Gauge, 5)
+
+	read := map[string]string{"container_id": testContainerID, "device": "8-0", "op": "read"}
+	write := map[string]string{"container_id": testContainerID, "device": "8-0", "op": "write"}
+	assertCgroupMetric(t, metrics, "CgroupBlkioBytes", read, metric.CumulativeCounter, 4096)
+	assertCgroupMetric(t, metrics, "CgroupBlkioBytes", write, metric.CumulativeCounter, 1024)
+	assertCgroupMetric(t, metrics, "CgroupBlkioOps", read, metric.CumulativeCounter, 2)
+
+	_, found := findMetric(metrics, "CgroupMemoryLimit", container)
+	assert.False(t, found, "unlimited memory should not be reported")
+	assertCgroupMetric(t, metrics, "CgroupMemoryUsage", map[string]string{"unit": "nginx.service"}, metric.Gauge, 2048)
+}
+
+func TestCgroupCollectV1(t *testing.T) {
+	root := writeCgroupFixture(t, testCgroupV1Fixture)
+	defer os.RemoveAll(root)
+
+	c := getTestCgroup(root)
+	require.False(t, c.isUnified())
+	metrics := c.collectCgroups()
+
+	testCgroupMetrics(t, metrics)
+	assertCgroupMetric(t, metrics, "CgroupMemoryLimit", map[string]string{"unit": "nginx.service"}, metric.Gauge, 4096)
+	for _, m := range metrics {
+		assert.NotEqual(t, "user.slice", m.Dimensions["cgroup"], "cgroups outside of paths should be ignored")
+	}
+	assert.Equal(t, 19, len(metrics))
+}
+
+func TestCgroupCollectV2(t *testing.T) {
+	root := writeCgroupFixture(t, testCgroupV2Fixture)
+	defer os.RemoveAll(root)
+
+	c := getTestCgroup(root)
+	require.True(t, c.isUnified())
+
+	testCgroupMetrics(t, c.collectCgroups())
+}
+
+func TestCgroupCollect(t *testing.T) {
+	root := writeCgroupFixture(t, testCgroupV1Fixture)
+	defer os.RemoveAll(root)
+
+	c := getTestCgroup(root)
+	c.channel = make(chan metric.Metric)
+	go func() {
+		c.Collect()
+		close(c.channel)
+	}()
+
+	count := 0
+	for m := range c.Channel() {
+		assert.False(t, m.GetTime().IsZero())
+		count++
+	}
+	assert.Equal(t, 19, count)
+}
@@ -0,0 +1,438 @@
+package collector
+
+import (
+	"fullerite/config"
+	"fullerite/metric"
+
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	l "github.com/Sirupsen/logrus"
+)
+
+const (
+	// DefaultCollectdPort is the port of the collectd network plugin
+	DefaultCollectdPort = "25826"
+	// DefaultCollectdTypesDB is where collectd installs its types.db
+	DefaultCollectdTypesDB = "/usr/share/collectd/types.db"
+
+	collectdMaxPacketSize = 65536
+)
+
+// collectd network protocol part types
+const (
+	collectdPartHost           = 0x0000
+	collectdPartTime           = 0x0001
+	collectdPartPlugin         = 0x0002
+	collectdPartPluginInstance = 0x0003
+	collectdPartType           = 0x0004
+	collectdPartTypeInstance   = 0x0005
+	collectdPartValues         = 0x0006
+	collectdPartInterval       = 0x0007
+	collectdPartTimeHR         = 0x0008
+	collectdPartIntervalHR     = 0x0009
+	collectdPartSignature      = 0x0200
+	collectdPartEncryption     = 0x0210
+)
+
+// collectd data source types
+const (
+	collectdCounter  = 0
+	collectdGauge    = 1
+	collectdDerive   = 2
+	collectdAbsolute = 3
+)
+
+// Collectd collector type.
+// It listens for packets of the collectd network plugin and converts every
+// value into a metric named plugin.type, with the data source name appended
+// for types with more than one data source. Host, plugin instance and type
+// instance become dimensions. Data source names are read from typesDB.
+//
+// With securityLevel "sign" only packets signed by a user of authFile are
+// accepted. Signed packets are always verified if authFile is set.
+type Collectd struct {
+	baseCollector
+	port          string
+	types         map[string][]string
+	securityLevel string
+	users         map[string]string
+	serverStarted bool
+	incoming      chan metric.Metric
+
+	// portMu guards port, the listener replaces it with the bound port
+	portMu sync.Mutex
+}
+
+// collectdValueList is the state built up while reading the parts of a packet
+type collectdValueList struct {
+	host           string
+	plugin         string
+	pluginInstance string
+	typeName       string
+	typeInstance   string
+	time           time.Time
+}
+
+func init() {
+	RegisterCollector("Collectd", newCollectd)
+}
+
+// newCollectd creates a new Collectd collector.
+func newCollectd(channel chan metric.Metric, initialInterval int, log *l.Entry) Collector {
+	c := new(Collectd)
+
+	c.log = log
+	c.channel = channel
+	c.interval = initialInterval
+
+	c.name = "Collectd"
+	c.incoming = make(chan metric.Metric)
+	c.port = DefaultCollectdPort
+	c.types = make(map[string][]string)
+	c.securityLevel = "none"
+	c.users = make(map[string]string)
+	c.serverStarted = false
+	c.SetCollectorType("listener")
+	return c
+}
+
+// Configure the collector
+func (c *Collectd) Configure(configMap map[string]interface{}) {
+	if port, exists := configMap["port"]; exists {
+		c.port = fmt.Sprint(port)
+	}
+
+	typesDB := []string{DefaultCollectdTypesDB}
+	if paths, exists := configMap["typesDB"]; exists {
+		typesDB = config.GetAsSlice(paths)
+	}
+	for _, path := range typesDB {
+		if err := loadCollectdTypesDB(path, c.types); err != nil {
+			defaultLog.Warn("Failed to load collectd types from ", path, ": ", err)
+		}
+	}
+
+	if level, exists := configMap["securityLevel"]; exists {
+		c.securityLevel = strings.ToLower(level.(string))
+		if c.securityLevel != "none" && c.securityLevel != "sign" {
+			defaultLog.Warn("Unknown securityLevel ", level, ", only signed packets are accepted")
+			c.securityLevel = "sign"
+		}
+	}
+	if authFile, exists := configMap["authFile"]; exists {
+		users, err := loadCollectdAuthFile(authFile.(string))
+		if err != nil {
+			defaultLog.Error("Failed to load collectd users from ", authFile, ": ", err)
+		}
+		c.users = users
+	}
+	c.configureCommonParams(configMap)
+}
+
+// Port returns the UDP listen port
+func (c *Collectd) Port() string {
+	c.portMu.Lock()
+	defer c.portMu.Unlock()
+	return c.port
+}
+
+// Collect starts the listener on first invocation and publishes
+// the decoded metrics to the handlers.
+func (c *Collectd) Collect() {
+	if !c.serverStarted {
+		c.serverStarted = true
+		go c.collectUDP()
+	}
+
+	for m := range c.incoming {
+		c.Channel() <- m
+	}
+}
+
+// collectUDP reads collectd packets from the UDP socket.
+func (c *Collectd) collectUDP() {
+	addr, err := net.ResolveUDPAddr("udp", ":"+c.port)
+	if err != nil {
+		panic(err)
+	}
+
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		c.log.Fatal("Cannot listen on Collectd UDP socket", err)
+	}
+	defer conn.Close()
+
+	// figure out the port bind for Port()
+	_, port, _ := net.SplitHostPort(conn.LocalAddr().String())
+	c.portMu.Lock()
+	c.port = port
+	c.portMu.Unlock()
+
+	buf := make([]byte, collectdMaxPacketSize)
+	for {
+		n, remote, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			c.log.Warn("Error while reading Collectd UDP packet", err)
+			continue
+		}
+		metrics, err := c.parsePacket(buf[:n])
+		if err != nil {
+			c.log.Warn("Dropping collectd packet from ", remote, ": ", err)
+		}
+		for _, m := range metrics {
+			c.incoming <- m
+		}
+	}
+}
+
+// parsePacket decodes a packet. Metrics decoded before an error are returned
+// along with it, except for signature errors which drop the whole packet.
+func (c *Collectd) parsePacket(buf []byte) ([]metric.Metric, error) {
+	var (
+		metrics []metric.Metric
+		vl      = collectdValueList{time: time.Now()}
+		signed  = false
+		packet  = buf
+	)
+	for len(buf) > 0 {
+		if len(buf) < 4 {
+			return metrics, errors.New("truncated part header")
+		}
+		partType := binary.BigEndian.Uint16(buf[0:2])
+		partLen := int(binary.BigEndian.Uint16(buf[2:4]))
+		if partLen < 4 || partLen > len(buf) {
+			return metrics, fmt.Errorf("invalid length %d for part 0x%04x", partLen, partType)
+		}
+		body := buf[4:partLen]
+		rest := buf[partLen:]
+
+		var err error
+		switch partType {
+		case collectdPartSignature:
+			if len(buf) != len(packet) {
+				return nil, errors.New("signature part is not first")
+			}
+			if err = c.verifySignature(body, rest); err != nil {
+				return nil, err
+			}
+			signed = true
+		case collectdPartEncryption:
+			return metrics, errors.New("encrypted packets are not supported")
+		case collectdPartHost:
+			vl.host, err = collectdString(body)
+		case collectdPartPlugin:
+			vl.plugin, err = collectdString(body)
+		case collectdPartPluginInstance:
+			vl.pluginInstance, err = collectdString(body)
+		case collectdPartType:
+			vl.typeName, err = collectdString(body)
+		case collectdPartTypeInstance:
+			vl.typeInstance, err = collectdString(body)
+		case collectdPartTime:
+			var seconds uint64
+			if seconds, err = collectdNumber(body); err == nil {
+				vl.time = time.Unix(int64(seconds), 0)
+			}
+		case collectdPartTimeHR:
+			var hr uint64
+			if hr, err = collectdNumber(body); err == nil {
+				vl.time = collectdHRTime(hr)
+			}
+		case collectdPartValues:
+			if !signed && c.securityLevel == "sign" {
+				return nil, errors.New("unsigned packet")
+			}
+			var values []metric.Metric
+			if values, err = c.parseValues(vl, body); err == nil {
+				metrics = append(metrics, values...)
+			}
+		}
+		// intervals, notifications and unknown parts are skipped
+		if err != nil {
+			return metrics, fmt.Errorf("part 0x%04x: %s", partType, err)
+		}
+		buf = rest
+	}
+	return metrics, nil
+}
+
+// verifySignature checks the HMAC-SHA256 of the user name and the rest of
+// the packet. Signatures of unknown users fail unless packets need not be signed.
+func (c *Collectd) verifySignature(body []byte, signedData []byte) error {
+	if len(body) < sha256.Size {
+		return errors.New("signature part too short")
+	}
+	hash, user := body[:sha256.Size], string(body[sha256.Size:])
+	password, exists := c.users[user]
+	if !exists {
+		if c.securityLevel == "sign" {
+			return fmt.Errorf("unknown user %q", user)
+		}
+		return nil
+	}
+	mac := hmac.New(sha256.New, []byte(password))
+	mac.Write([]byte(user))
+	mac.Write(signedData)
+	if !hmac.Equal(mac.Sum(nil), hash) {
+		return fmt.Errorf("invalid signature for user %q", user)
+	}
+	return nil
+}
+
+// parseValues converts a values part into one metric per data source.
+func (c *Collectd) parseValues(vl collectdValueList, body []byte) ([]metric.Metric, error) {
+	if len(body) < 2 {
+		return nil, errors.New("values part too short")
+	}
+	count := int(binary.BigEndian.Uint16(body[0:2]))
+	if len(body) != 2+count*9 {
+		return nil, fmt.Errorf("expected %d values in %d bytes", count, len(body))
+	}
+	if vl.plugin == "" || vl.typeName == "" {
+		return nil, errors.New("values without plugin or type")
+	}
+	sources, known := c.types[vl.typeName]
+	if known && len(sources) != count {
+		return nil, fmt.Errorf("type %s has %d data sources, got %d values", vl.typeName, len(sources), count)
+	}
+
+	dims := map[string]string{}
+	if vl.host != "" {
+		dims["host"] = vl.host
+	}
+	if vl.pluginInstance != "" {
+		dims["plugin_instance"] = vl.pluginInstance
+	}
+	if vl.typeInstance != "" {
+		dims["type_instance"] = vl.typeInstance
+	}
+
+	kinds, raw := body[2:2+count], body[2+count:]
+	metrics := make([]metric.Metric, 0, count)
+	for i := 0; i < count; i++ {
+		source := fmt.Sprint(i)
+		if known {
+			source = sources[i]
+		} else if count == 1 {
+			source = "value"
+		}
+		name := vl.plugin + "." + vl.typeName
+		if count > 1 {
+			name += "." + source
+		}
+
+		m := metric.New(name)
+		value := raw[i*8 : i*8+8]
+		switch kinds[i] {
+		case collectdCounter:
+			m.MetricType = metric.CumulativeCounter
+			m.Value = float64(binary.BigEndian.Uint64(value))
+		case collectdGauge:
+			// gauges are the only values in little endian
+			m.Value = math.Float64frombits(binary.LittleEndian.Uint64(value))
+		case collectdDerive:
+			m.MetricType = metric.CumulativeCounter
+			m.Value = float64(int64(binary.BigEndian.Uint64(value)))
+		case collectdAbsolute:
+			m.MetricType = metric.Counter
+			m.Value = float64(binary.BigEndian.Uint64(value))
+		default:
+			return nil, fmt.Errorf("unknown data source type %d", kinds[i])
+		}
+		if math.IsNaN(m.Value) {
+			continue
+		}
+		m.AddDimensions(dims)
+		m.SetTime(vl.time)
+		metrics = append(metrics, m)
+	}
+	return metrics, nil
+}
+
+func collectdString(body []byte) (string, error) {
+	if len(body) == 0 || body[len(body)-1] != 0 {
+		return "", errors.New("string is not null terminated")
+	}
+	return string(body[:len(body)-1]), nil
+}
+
+func collectdNumber(body []byte) (uint64, error) {
+	if len(body) != 8 {
+		return 0, fmt.Errorf("expected 8 bytes, got %d", len(body))
+	}
+	return binary.BigEndian.Uint64(body), nil
+}
+
+// collectdHRTime converts high resolution time, in units of 2^-30 seconds.
+func collectdHRTime(hr uint64) time.Time {
+	seconds := int64(hr >> 30)
+	nanos := int64((hr & (1<<30 - 1)) * uint64(time.Second) >> 30)
+	return time.Unix(seconds, nanos)
+}
+
+// loadCollectdTypesDB adds the data source names of every type in a
+// types.db file, lines look like "if_octets rx:DERIVE:0:U, tx:DERIVE:0:U".
+func loadCollectdTypesDB(path string, types map[string][]string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		sources := []string{}
+		for _, spec := range strings.Split(strings.Join(fields[1:], ""), ",") {
+			if parts := strings.Split(spec, ":"); len(parts) == 4 && parts[0] != "" {
+				sources = append(sources, parts[0])
+			}
+		}
+		if len(sources) > 0 {
+			types[fields[0]] = sources
+		}
+	}
+	return scanner.Err()
+}
+
+// loadCollectdAuthFile reads "user: password" lines like collectd's AuthFile.
+func loadCollectdAuthFile(path string) (map[string]string, error) {
+	users := make(map[string]string)
+	file, err := os.Open(path)
+	if err != nil {
+		return users, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		users[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return users, scanner.Err()
+}
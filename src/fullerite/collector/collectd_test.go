@@ -0,0 +1,229 @@
+package collector
+
+import (
+	"fullerite/metric"
+	"test_utils"
+
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func collectdTestString(buf *bytes.Buffer, partType uint16, s string) {
+	binary.Write(buf, binary.BigEndian, partType)
+	binary.Write(buf, binary.BigEndian, uint16(4+len(s)+1))
+	buf.WriteString(s)
+	buf.WriteByte(0)
+}
+
+func collectdTestNumber(buf *bytes.Buffer, partType uint16, n uint64) {
+	binary.Write(buf, binary.BigEndian, partType)
+	binary.Write(buf, binary.BigEndian, uint16(12))
+	binary.Write(buf, binary.BigEndian, n)
+}
+
+func collectdTestValues(buf *bytes.Buffer, kinds []byte, values []uint64) {
+	binary.Write(buf, binary.BigEndian, uint16(collectdPartValues))
+	binary.Write(buf, binary.BigEndian, uint16(6+9*len(kinds)))
+	binary.Write(buf, binary.BigEndian, uint16(len(kinds)))
+	buf.Write(kinds)
+	for i, v := range values {
+		if kinds[i] == collectdGauge {
+			binary.Write(buf, binary.LittleEndian, v)
+		} else {
+			binary.Write(buf, binary.BigEndian, v)
+		}
+	}
+}
+
+func collectdTestPacket() []byte {
+	var buf bytes.Buffer
+	collectdTestString(&buf, collectdPartHost, "web01")
+	collectdTestNumber(&buf, collectdPartTimeHR, 1465839830<<30|1<<29)
+	collectdTestString(&buf, collectdPartPlugin, "interface")
+	collectdTestString(&buf, collectdPartPluginInstance, "eth0")
+	collectdTestString(&buf, collectdPartType, "if_octets")
+	collectdTestValues(&buf, []byte{collectdDerive, collectdDerive}, []uint64{100, 200})
+	collectdTestString(&buf, collectdPartPlugin, "load")
+	collectdTestString(&buf, collectdPartPluginInstance, "")
+	collectdTestString(&buf, collectdPartType, "load")
+	collectdTestValues(&buf, []byte{collectdGauge, collectdGauge, collectdGauge},
+		[]uint64{math.Float64bits(0.5), math.Float64bits(0.25), math.Float64bits(0.125)})
+	collectdTestString(&buf, collectdPartPlugin, "cpu")
+	collectdTestString(&buf, collectdPartType, "cpu")
+	collectdTestString(&buf, collectdPartTypeInstance, "idle")
+	collectdTestValues(&buf, []byte{collectdCounter}, []uint64{42})
+	return buf.Bytes()
+}
+
+func collectdTestSign(packet []byte, user, password string) []byte {
+	mac := hmac.New(sha256.New, []byte(password))
+	mac.Write([]byte(user))
+	mac.Write(packet)
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint16(collectdPartSignature))
+	binary.Write(&buf, binary.BigEndian, uint16(4+sha256.Size+len(user)))
+	buf.Write(mac.Sum(nil))
+	buf.WriteString(user)
+	buf.Write(packet)
+	return buf.Bytes()
+}
+
+func getTestCollectd(t *testing.T, extra map[string]interface{}) (*Collectd, string) {
+	dir, err := ioutil.TempDir("", "collectd")
+	require.Nil(t, err)
+	typesDB := filepath.Join(dir, "types.db")
+	ioutil.WriteFile(typesDB, []byte("# comment\n"+
+		"if_octets\t\trx:DERIVE:0:U, tx:DERIVE:0:U\n"+
+		"load\t\t\tshortterm:GAUGE:0:5000, midterm:GAUGE:0:5000, longterm:GAUGE:0:5000\n"), 0644)
+	authFile := filepath.Join(dir, "auth")
+	ioutil.WriteFile(authFile, []byte("alice: secret\n"), 0644)
+
+	config := map[string]interface{}{"typesDB": []interface{}{typesDB}, "authFile": authFile}
+	for k, v := range extra {
+		config[k] = v
+	}
+	c := newCollectd(make(chan metric.Metric), 10, test_utils.BuildLogger()).(*Collectd)
+	c.Configure(config)
+	return c, dir
+}
+
+func TestCollectdConfigureEmptyConfig(t *testing.T) {
+	c := newCollectd(nil, 12, nil).(*Collectd)
+	c.Configure(map[string]interface{}{"typesDB": []interface{}{}})
+
+	assert := assert.New(t)
+	assert.Equal(12, c.Interval())
+	assert.Equal(DefaultCollectdPort, c.Port())
+	assert.Equal("none", c.securityLevel)
+	assert.Equal("listener", c.CollectorType())
+}
+
+func TestCollectdConfigure(t *testing.T) {
+	c, dir := getTestCollectd(t, map[string]interface{}{"port": 1234, "securityLevel": "Sign"})
+	defer os.RemoveAll(dir)
+
+	assert := assert.New(t)
+	assert.Equal("1234", c.Port())
+	assert.Equal("sign", c.securityLevel)
+	assert.Equal(map[string]string{"alice": "secret"}, c.users)
+	assert.Equal([]string{"rx", "tx"}, c.types["if_octets"])
+	assert.Equal([]string{"shortterm", "midterm", "longterm"}, c.types["load"])
+}
+
+func TestCollectdParsePacket(t *testing.T) {
+	c, dir := getTestCollectd(t, nil)
+	defer os.RemoveAll(dir)
+
+	metrics, err := c.parsePacket(collectdTestPacket())
+	require.Nil(t, err)
+	require.Equal(t, 6, len(metrics))
+
+	assert := assert.New(t)
+	rx, found := findMetric(metrics, "interface.if_octets.rx", map[string]string{"host": "web01", "plugin_instance": "eth0"})
+	require.True(t, found)
+	assert.Equal(100.0, rx.Value)
+	assert.Equal(metric.CumulativeCounter, rx.MetricType)
+	assert.Equal(time.Unix(1465839830, 500000000), rx.GetTime())
+
+	load, found := findMetric(metrics, "load.load.midterm", map[string]string{"host": "web01"})
+	require.True(t, found)
+	assert.Equal(0.25, load.Value)
+	assert.Equal(metric.Gauge, load.MetricType)
+	assert.Equal(map[string]string{"host": "web01"}, load.Dimensions)
+
+	cpu, found := findMetric(metrics, "cpu.cpu", map[string]string{"type_instance": "idle"})
+	require.True(t, found, "single unknown data sources are named after the type")
+	assert.Equal(42.0, cpu.Value)
+}
+
+func TestCollectdParsePacketErrors(t *testing.T) {
+	c, dir := getTestCollectd(t, nil)
+	defer os.RemoveAll(dir)
+
+	packet := collectdTestPacket()
+	metrics, err := c.parsePacket(packet[:len(packet)-3])
+	assert.NotNil(t, err)
+	assert.Equal(t, 5, len(metrics), "values before the broken part are kept")
+
+	var buf bytes.Buffer
+	collectdTestString(&buf, collectdPartPlugin, "load")
+	collectdTestString(&buf, collectdPartType, "load")
+	collectdTestValues(&buf, []byte{collectdGauge}, []uint64{0})
+	_, err = c.parsePacket(buf.Bytes())
+	assert.NotNil(t, err, "the value count should match types.db")
+}
+
+func TestCollectdSignedPacket(t *testing.T) {
+	c, dir := getTestCollectd(t, map[string]interface{}{"securityLevel": "sign"})
+	defer os.RemoveAll(dir)
+
+	metrics, err := c.parsePacket(collectdTestSign(collectdTestPacket(), "alice", "secret"))
+	assert.Nil(t, err)
+	assert.Equal(t, 6, len(metrics))
+
+	_, err = c.parsePacket(collectdTestPacket())
+	assert.NotNil(t, err, "unsigned packets should be rejected")
+
+	metrics, err = c.parsePacket(collectdTestSign(collectdTestPacket(), "alice", "wrong"))
+	assert.NotNil(t, err)
+	assert.Empty(t, metrics)
+
+	_, err = c.parsePacket(collectdTestSign(collectdTestPacket(), "bob", "secret"))
+	assert.NotNil(t, err, "unknown users should be rejected")
+}
+
+func TestCollectdSignedPacketSecurityLevelNone(t *testing.T) {
+	c, dir := getTestCollectd(t, nil)
+	defer os.RemoveAll(dir)
+
+	_, err := c.parsePacket(collectdTestSign(collectdTestPacket(), "bob", "secret"))
+	assert.Nil(t, err, "signatures of unknown users cannot be verified")
+
+	_, err = c.parsePacket(collectdTestSign(collectdTestPacket(), "alice", "wrong"))
+	assert.NotNil(t, err, "known users are always verified")
+}
+
+func TestCollectdCollectUDP(t *testing.T) {
+	c, dir := getTestCollectd(t, map[string]interface{}{"port": "0"})
+	defer os.RemoveAll(dir)
+
+	go c.Collect()
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	for retry := 0; retry < 3; retry++ {
+		if c.Port() != "0" {
+			if conn, err = net.Dial("udp", "localhost:"+c.Port()); err == nil {
+				break
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.Nil(t, err, "should connect")
+	require.NotNil(t, conn, "should connect")
+	defer conn.Close()
+
+	conn.Write(collectdTestPacket())
+
+	select {
+	case m := <-c.Channel():
+		assert.Equal(t, "interface.if_octets.rx", m.Name)
+	case <-time.After(1 * time.Second):
+		t.Fail()
+	}
+}
@@ -1,6 +1,7 @@
 package collector
 
 import (
+	"fullerite/config"
 	"fullerite/metric"
 
 	"bufio"
@@ -18,12 +19,18 @@ const (
 	DefaultDiamondCollectorPort = "19191"
 )
 
-// Diamond collector type
+// Diamond collector type.
+// With supervise set it also runs every diamond collector of fulleriteConfig
+// in its own python process, see diamondProcess, and reports their restarts
+// and liveness every interval. Otherwise fullerite_diamond_server has to run
+// the collectors.
 type Diamond struct {
 	baseCollector
 	port          string
 	serverStarted bool
 	incoming      chan []byte
+	supervise     bool
+	processes     []*diamondProcess
 }
 
 func init() {
@@ -51,7 +58,65 @@ func (d *Diamond) Configure(configMap map[string]interface{}) {
 	if port, exists := configMap["port"]; exists {
 		d.port = port.(string)
 	}
+	if supervise, exists := configMap["supervise"]; exists {
+		d.supervise, _ = supervise.(bool)
+	}
 	d.configureCommonParams(configMap)
+	if d.supervise {
+		d.processes = d.configureProcesses(configMap)
+	}
+}
+
+// configureProcesses creates a process for every collector, the collectors
+// default to the diamondCollectors of fulleriteConfig.
+func (d *Diamond) configureProcesses(configMap map[string]interface{}) []*diamondProcess {
+	python := "python"
+	if value, exists := configMap["python"]; exists {
+		python = value.(string)
+	}
+	server := DefaultDiamondServer
+	if value, exists := configMap["diamondServer"]; exists {
+		server = value.(string)
+	}
+	fulleriteConfig := DefaultFulleriteConfig
+	if value, exists := configMap["fulleriteConfig"]; exists {
+		fulleriteConfig = value.(string)
+	}
+
+	var collectors []string
+	if value, exists := configMap["collectors"]; exists {
+		collectors = config.GetAsSlice(value)
+	} else {
+		c, err := config.ReadConfig(fulleriteConfig)
+		if err != nil {
+			defaultLog.Error("Cannot supervise diamond collectors without ", fulleriteConfig)
+			return nil
+		}
+		collectors = c.DiamondCollectors
+	}
+
+	minBackoff := time.Second
+	if value, exists := configMap["minBackoff"]; exists {
+		minBackoff = time.Duration(config.GetAsFloat(value, 1) * float64(time.Second))
+	}
+	maxBackoff := time.Minute
+	if value, exists := configMap["maxBackoff"]; exists {
+		maxBackoff = time.Duration(config.GetAsFloat(value, 60) * float64(time.Second))
+	}
+
+	log := d.log
+	if log == nil {
+		log = defaultLog
+	}
+	processes := []*diamondProcess{}
+	for _, name := range collectors {
+		p := newDiamondProcess(name, diamondCollectorCommand(python, server, fulleriteConfig, name), log)
+		p.env = diamondPythonPath(server)
+		p.minBackoff = minBackoff
+		p.maxBackoff = maxBackoff
+		processes = append(processes, p)
+	}
+	return processes
 }
 
 // Port returns Diamond collectors listen port
@@ -114,6 +179,12 @@ func (d *Diamond) Collect() {
 	if !d.serverStarted {
 		d.serverStarted = true
 		go d.collectDiamond()
+		for _, p := range d.processes {
+			go p.supervise()
+		}
+		if len(d.processes) > 0 {
+			go d.reportProcesses()
+		}
 	}
 
 	for line := range d.incoming {
@@ -125,6 +196,22 @@ func (d *Diamond) Collect() {
 	}
 }
 
+// reportProcesses publishes the state of the supervised processes every interval
+func (d *Diamond) reportProcesses() {
+	if d.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(d.interval) * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		for _, p := range d.processes {
+			for _, m := range p.metrics() {
+				d.Channel() <- m
+			}
+		}
+	}
+}
+
 func (d *Diamond) parseMetrics(line []byte) ([]metric.Metric, bool) {
 	var metrics []metric.Metric
 	if err := json.Unmarshal(line, &metrics); err != nil {
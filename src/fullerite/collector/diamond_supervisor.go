@@ -0,0 +1,192 @@
+package collector
+
+import (
+	"fullerite/metric"
+
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	l "github.com/Sirupsen/logrus"
+)
+
+const (
+	// DefaultDiamondServer is where packages install the diamond server
+	DefaultDiamondServer = "/usr/share/fullerite/diamond/server.py"
+	// DefaultFulleriteConfig is the config the diamond server reads its collectors from
+	DefaultFulleriteConfig = "/etc/fullerite.conf"
+)
+
+// diamondProcess keeps one diamond collector running in its own python
+// process. A process which exits is restarted after a backoff which doubles
+// with every crash up to maxBackoff, and is reset once a process stayed up
+// for maxBackoff.
+type diamondProcess struct {
+	name       string
+	command    []string
+	env        []string
+	log        *l.Entry
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	mu       sync.Mutex
+	restarts uint64
+	alive    bool
+	done     chan struct{}
+}
+
+func newDiamondProcess(name string, command []string, log *l.Entry) *diamondProcess {
+	return &diamondProcess{
+		name:       name,
+		command:    command,
+		log:        log.WithField("diamond_collector", name),
+		minBackoff: time.Second,
+		maxBackoff: time.Minute,
+		done:       make(chan struct{}),
+	}
+}
+
+// diamondCollectorCommand runs a single collector with the diamond server
+func diamondCollectorCommand(python, server, fulleriteConfig, name string) []string {
+	return []string{python, server, "-c", fulleriteConfig, "--collector", name}
+}
+
+// diamondPythonPath lets the server import the diamond package it belongs to
+func diamondPythonPath(server string) []string {
+	return append(os.Environ(), "PYTHONPATH="+filepath.Dir(filepath.Dir(server)))
+}
+
+// supervise runs the process until stop is called.
+func (p *diamondProcess) supervise() {
+	backoff := p.minBackoff
+	for {
+		started := time.Now()
+		err := p.run()
+		if time.Since(started) >= p.maxBackoff {
+			backoff = p.minBackoff
+		}
+
+		select {
+		case <-p.done:
+			return
+		default:
+		}
+		p.log.Error("Diamond collector exited, restarting in ", backoff, ": ", err)
+
+		select {
+		case <-p.done:
+			return
+		case <-time.After(backoff):
+		}
+		p.mu.Lock()
+		p.restarts++
+		p.mu.Unlock()
+
+		backoff *= 2
+		if backoff > p.maxBackoff {
+			backoff = p.maxBackoff
+		}
+	}
+}
+
+// run starts the process and waits for it to exit. It is killed along with
+// its children when stop is called.
+func (p *diamondProcess) run() error {
+	cmd := exec.Command(p.command[0], p.command[1:]...)
+	cmd.Env = p.env
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	p.setAlive(true)
+	defer p.setAlive(false)
+	p.log.Info("Started diamond collector with pid ", cmd.Process.Pid)
+
+	logged := make(chan struct{})
+	go func() {
+		p.logOutput(stderr)
+		close(logged)
+	}()
+
+	exited := make(chan error, 1)
+	go func() {
+		<-logged
+		exited <- cmd.Wait()
+	}()
+
+	select {
+	case err = <-exited:
+	case <-p.done:
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		err = <-exited
+	}
+	return err
+}
+
+// diamondMaxLogLine is the longest stderr line which is logged
+const diamondMaxLogLine = 1024 * 1024
+
+// logOutput logs every line written to stderr at the level python's logging
+// module wrote it with. After a line which is too long the rest of stderr is
+// discarded, the process would block on a full pipe otherwise.
+func (p *diamondProcess) logOutput(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), diamondMaxLogLine)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.Contains(line, " - ERROR - "), strings.Contains(line, " - CRITICAL - "):
+			p.log.Error(line)
+		case strings.Contains(line, " - WARNING - "):
+			p.log.Warn(line)
+		case strings.Contains(line, " - DEBUG - "):
+			p.log.Debug(line)
+		default:
+			p.log.Info(line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		p.log.Warn("Discarding the rest of stderr: ", err)
+		io.Copy(ioutil.Discard, r)
+	}
+}
+
+func (p *diamondProcess) setAlive(alive bool) {
+	p.mu.Lock()
+	p.alive = alive
+	p.mu.Unlock()
+}
+
+func (p *diamondProcess) stop() {
+	close(p.done)
+}
+
+// metrics reports the number of restarts and whether the process is running
+func (p *diamondProcess) metrics() []metric.Metric {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	restarts := metric.New("fullerite.diamond_collector_restarts")
+	restarts.MetricType = metric.CumulativeCounter
+	restarts.Value = float64(p.restarts)
+
+	alive := metric.New("fullerite.diamond_collector_alive")
+	if p.alive {
+		alive.Value = 1
+	}
+
+	metrics := []metric.Metric{restarts, alive}
+	metric.AddToAll(&metrics, map[string]string{"diamond_collector": p.name})
+	return metrics
+}
@@ -0,0 +1,207 @@
+package collector
+
+import (
+	"fullerite/metric"
+	"test_utils"
+
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	l "github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// diamondLogHook records the log entries of a diamond process
+type diamondLogHook struct {
+	entries chan *l.Entry
+}
+
+func (h *diamondLogHook) Levels() []l.Level {
+	return l.AllLevels
+}
+
+func (h *diamondLogHook) Fire(entry *l.Entry) error {
+	h.entries <- entry
+	return nil
+}
+
+func getTestDiamondProcess(script string) *diamondProcess {
+	p := newDiamondProcess("CPUCollector", []string{"/bin/sh", "-c", script}, test_utils.BuildLogger())
+	p.minBackoff = 10 * time.Millisecond
+	p.maxBackoff = 40 * time.Millisecond
+	return p
+}
+
+func diamondProcessState(p *diamondProcess) (uint64, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.restarts, p.alive
+}
+
+func TestDiamondConfigureSupervise(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diamond")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	fulleriteConfig := filepath.Join(dir, "fullerite.conf")
+	ioutil.WriteFile(fulleriteConfig, []byte(`{"diamondCollectors": ["CPUCollector", "PingCollector google"]}`), 0644)
+
+	d := newDiamond(nil, 12, nil).(*Diamond)
+	d.Configure(map[string]interface{}{
+		"supervise":       true,
+		"fulleriteConfig": fulleriteConfig,
+		"diamondServer":   "/opt/fullerite/diamond/server.py",
+		"minBackoff":      0.5,
+		"maxBackoff":      "30",
+	})
+
+	assert := assert.New(t)
+	require.Equal(t, 2, len(d.processes))
+	p := d.processes[1]
+	assert.Equal("PingCollector google", p.name)
+	assert.Equal([]string{"python", "/opt/fullerite/diamond/server.py", "-c", fulleriteConfig,
+		"--collector", "PingCollector google"}, p.command)
+	assert.Contains(p.env, "PYTHONPATH=/opt/fullerite")
+	assert.Equal(500*time.Millisecond, p.minBackoff)
+	assert.Equal(30*time.Second, p.maxBackoff)
+}
+
+func TestDiamondConfigureSuperviseCollectors(t *testing.T) {
+	d := newDiamond(nil, 12, nil).(*Diamond)
+	d.Configure(map[string]interface{}{
+		"supervise":  true,
+		"python":     "/usr/bin/python2.7",
+		"collectors": []interface{}{"MemoryCollector"},
+	})
+
+	require.Equal(t, 1, len(d.processes))
+	assert.Equal(t, []string{"/usr/bin/python2.7", DefaultDiamondServer, "-c", DefaultFulleriteConfig,
+		"--collector", "MemoryCollector"}, d.processes[0].command)
+}
+
+// waitForDiamondProcess polls the process state until done returns true or
+// the deadline passes
+func waitForDiamondProcess(p *diamondProcess, done func(restarts uint64, alive bool) bool) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if done(diamondProcessState(p)) {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
+
+// waitForDiamondLog collects the logged entries until done returns true or
+// the deadline passes
+func waitForDiamondLog(hook *diamondLogHook, done func(entry *l.Entry) bool) bool {
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case entry := <-hook.entries:
+			if done(entry) {
+				return true
+			}
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+func getTestDiamondLogHook(p *diamondProcess) *diamondLogHook {
+	hook := &diamondLogHook{entries: make(chan *l.Entry, 100)}
+	logger := l.New()
+	logger.Out = ioutil.Discard
+	logger.Hooks.Add(hook)
+	p.log = l.NewEntry(logger).WithField("diamond_collector", p.name)
+	return hook
+}
+
+func TestDiamondProcessRestartsWithBackoff(t *testing.T) {
+	p := getTestDiamondProcess("exit 1")
+	start := time.Now()
+	go p.supervise()
+	defer p.stop()
+
+	restarted := waitForDiamondProcess(p, func(restarts uint64, _ bool) bool { return restarts >= 3 })
+	require.True(t, restarted, "should have restarted a few times")
+	// 10 + 20 + 40 ms before the third restart
+	assert.True(t, time.Since(start) >= 70*time.Millisecond, "should back off, restarted after %s", time.Since(start))
+}
+
+func TestDiamondProcessAliveAndStop(t *testing.T) {
+	p := getTestDiamondProcess("sleep 10 & sleep 10")
+	go p.supervise()
+
+	require.True(t, waitForDiamondProcess(p, func(_ uint64, alive bool) bool { return alive }))
+	restarts, _ := diamondProcessState(p)
+	assert.Equal(t, uint64(0), restarts)
+
+	metrics := p.metrics()
+	require.Equal(t, 2, len(metrics))
+	m, found := findMetric(metrics, "fullerite.diamond_collector_alive", map[string]string{"diamond_collector": "CPUCollector"})
+	require.True(t, found)
+	assert.Equal(t, 1.0, m.Value)
+	m, found = findMetric(metrics, "fullerite.diamond_collector_restarts", nil)
+	require.True(t, found)
+	assert.Equal(t, metric.CumulativeCounter, m.MetricType)
+
+	p.stop()
+	stopped := waitForDiamondProcess(p, func(_ uint64, alive bool) bool { return !alive })
+	assert.True(t, stopped, "the process group should have been killed")
+}
+
+func TestDiamondProcessLogsStderr(t *testing.T) {
+	p := getTestDiamondProcess("echo '2016-01-01 - diamond - ERROR - it broke' >&2; echo 'starting' >&2; sleep 10")
+	hook := getTestDiamondLogHook(p)
+	go p.supervise()
+	defer p.stop()
+
+	var errorLine, infoLine bool
+	waitForDiamondLog(hook, func(entry *l.Entry) bool {
+		if strings.Contains(entry.Message, "it broke") {
+			errorLine = entry.Level == l.ErrorLevel && entry.Data["diamond_collector"] == "CPUCollector"
+		}
+		if entry.Message == "starting" {
+			infoLine = entry.Level == l.InfoLevel
+		}
+		return errorLine && infoLine
+	})
+	assert.True(t, errorLine, "python errors should be logged as errors")
+	assert.True(t, infoLine)
+}
+
+func TestDiamondProcessLogsLongStderrLines(t *testing.T) {
+	p := getTestDiamondProcess("{ head -c 100000 /dev/zero | tr '\\0' a; echo; echo after; } >&2; sleep 10")
+	hook := getTestDiamondLogHook(p)
+	go p.supervise()
+	defer p.stop()
+
+	logged := waitForDiamondLog(hook, func(entry *l.Entry) bool { return entry.Message == "after" })
+	assert.True(t, logged, "lines after a long line should be logged")
+}
+
+func TestDiamondProcessDrainsStderr(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diamond")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	finished := filepath.Join(dir, "finished")
+
+	// a line longer than diamondMaxLogLine, more than a pipe holds
+	p := getTestDiamondProcess("head -c 2000000 /dev/zero >&2; touch " + finished + "; sleep 10")
+	go p.supervise()
+	defer p.stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(finished); err == nil {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("the process blocked writing to stderr")
+}
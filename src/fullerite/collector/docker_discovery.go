@@ -0,0 +1,140 @@
+package collector
+
+import (
+	"fullerite/metric"
+
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// Container labels which turn a container into a scrape target when
+// scrapeDiscovery is enabled. Only the port label is required.
+const (
+	scrapePortLabel   = "fullerite.scrape.port"
+	scrapePathLabel   = "fullerite.scrape.path"
+	scrapeFormatLabel = "fullerite.scrape.format"
+
+	defaultScrapePath   = "/metrics"
+	defaultScrapeFormat = "json"
+)
+
+// scrapeTarget returns the URL and format to scrape for a container, ok is
+// false if the container is not labeled or its address is unknown.
+func scrapeTarget(container *docker.Container) (url string, format string, ok bool) {
+	if container.Config == nil {
+		return "", "", false
+	}
+	labels := container.Config.Labels
+	port, err := strconv.Atoi(labels[scrapePortLabel])
+	if err != nil || port <= 0 {
+		return "", "", false
+	}
+	ip := containerIP(container)
+	if ip == "" {
+		return "", "", false
+	}
+
+	path := labels[scrapePathLabel]
+	if path == "" {
+		path = defaultScrapePath
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	format = strings.ToLower(labels[scrapeFormatLabel])
+	if format == "" {
+		format = defaultScrapeFormat
+	}
+	return fmt.Sprintf("http://%s:%d%s", ip, port, path), format, true
+}
+
+// containerIP returns the IP of the container on the default bridge or, with
+// user defined networks, the first network which has one.
+func containerIP(container *docker.Container) string {
+	settings := container.NetworkSettings
+	if settings == nil {
+		return ""
+	}
+	if settings.IPAddress != "" {
+		return settings.IPAddress
+	}
+	for _, network := range sortedNetworkNames(settings.Networks) {
+		if ip := settings.Networks[network].IPAddress; ip != "" {
+			return ip
+		}
+	}
+	return ""
+}
+
+func sortedNetworkNames(networks map[string]docker.ContainerNetwork) []string {
+	names := make([]string, 0, len(networks))
+	for name := range networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// updateScrapeTargets remembers the current targets so that new and removed
+// targets are logged once instead of every interval.
+func (d *DockerStats) updateScrapeTargets(current map[string]string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for id, url := range current {
+		if _, exists := d.scrapeTargets[id]; !exists {
+			d.log.Info("Discovered scrape target ", url, " for container ", id)
+		}
+	}
+	for id, url := range d.scrapeTargets {
+		if _, exists := current[id]; !exists {
+			d.log.Info("Removed scrape target ", url, " for container ", id)
+		}
+	}
+	d.scrapeTargets = current
+}
+
+// scrapeContainer fetches the application metrics of a labeled container and
+// attaches the same container dimensions as the resource metrics.
+func (d *DockerStats) scrapeContainer(container *docker.Container, url string, format string) {
+	metrics, err := d.fetchContainerMetrics(url, format)
+	if err != nil {
+		d.log.Error("Failed to scrape ", url, " for container ", container.ID, ": ", err)
+		return
+	}
+	metric.AddToAll(&metrics, d.containerDimensions(container))
+	metric.AddToAll(&metrics, d.extractDimensions(container))
+	d.sendMetrics(metrics)
+}
+
+func (d *DockerStats) fetchContainerMetrics(url string, format string) ([]metric.Metric, error) {
+	client := http.Client{Timeout: time.Duration(d.statsTimeout) * time.Second}
+	rsp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	body, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", rsp.StatusCode)
+	}
+
+	switch format {
+	case "json":
+		return parseJSONDocument(body, nil)
+	case "prometheus":
+		metrics, _, err := parsePrometheusText(body, time.Now(), d.log.WithField("url", url))
+		return metrics, err
+	}
+	return nil, fmt.Errorf("unknown scrape format %q", format)
+}
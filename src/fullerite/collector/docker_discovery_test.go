@@ -0,0 +1,127 @@
+package collector
+
+import (
+	"fullerite/metric"
+
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getTestScrapeContainer(ip string, labels map[string]string) *docker.Container {
+	return &docker.Container{
+		ID:              "test-id",
+		Name:            "/test-container",
+		Config:          &docker.Config{Labels: labels},
+		NetworkSettings: &docker.NetworkSettings{IPAddress: ip},
+	}
+}
+
+func TestDockerStatsConfigureScrapeDiscovery(t *testing.T) {
+	d := getSUT()
+	d.Configure(map[string]interface{}{})
+	assert.False(t, d.scrapeDiscovery)
+
+	d.Configure(map[string]interface{}{"scrapeDiscovery": true})
+	assert.True(t, d.scrapeDiscovery)
+}
+
+func TestScrapeTarget(t *testing.T) {
+	tests := []struct {
+		container *docker.Container
+		url       string
+		format    string
+		ok        bool
+	}{
+		{getTestScrapeContainer("172.17.0.2", map[string]string{}), "", "", false},
+		{getTestScrapeContainer("172.17.0.2", map[string]string{scrapePortLabel: "http"}), "", "", false},
+		{getTestScrapeContainer("", map[string]string{scrapePortLabel: "8080"}), "", "", false},
+		{
+			getTestScrapeContainer("172.17.0.2", map[string]string{scrapePortLabel: "8080"}),
+			"http://172.17.0.2:8080/metrics", "json", true,
+		},
+		{
+			getTestScrapeContainer("172.17.0.2", map[string]string{
+				scrapePortLabel:   "9100",
+				scrapePathLabel:   "prom",
+				scrapeFormatLabel: "Prometheus",
+			}),
+			"http://172.17.0.2:9100/prom", "prometheus", true,
+		},
+	}
+
+	for _, test := range tests {
+		url, format, ok := scrapeTarget(test.container)
+		assert.Equal(t, test.ok, ok, test.url)
+		assert.Equal(t, test.url, url)
+		assert.Equal(t, test.format, format)
+	}
+}
+
+func TestContainerIPUserDefinedNetwork(t *testing.T) {
+	container := getTestScrapeContainer("", nil)
+	container.NetworkSettings.Networks = map[string]docker.ContainerNetwork{
+		"backend":  {IPAddress: "10.0.1.5"},
+		"frontend": {IPAddress: "10.0.2.5"},
+	}
+	assert.Equal(t, "10.0.1.5", containerIP(container))
+}
+
+func TestDockerStatsScrapeContainer(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/status":
+			fmt.Fprint(w, `{"requests": {"count": 12}}`)
+		case "/metrics":
+			fmt.Fprint(w, "# TYPE jobs_total counter\njobs_total{queue=\"mail\"} 3\n")
+		}
+	}))
+	defer ts.Close()
+	port := strconv.Itoa(serverPort(t, ts))
+
+	tests := []struct {
+		labels     map[string]string
+		name       string
+		metricType string
+		value      float64
+	}{
+		{map[string]string{scrapePortLabel: port, scrapePathLabel: "/status"}, "requests.count", metric.Gauge, 12},
+		{map[string]string{scrapePortLabel: port, scrapeFormatLabel: "prometheus"}, "jobs_total", metric.CumulativeCounter, 3},
+	}
+
+	for _, test := range tests {
+		d := getSUT()
+		d.Configure(map[string]interface{}{"scrapeDiscovery": true})
+		container := getTestScrapeContainer("127.0.0.1", test.labels)
+		url, format, ok := scrapeTarget(container)
+		require.True(t, ok)
+
+		go d.scrapeContainer(container, url, format)
+
+		select {
+		case m := <-d.Channel():
+			assert.Equal(t, test.name, m.Name)
+			assert.Equal(t, test.metricType, m.MetricType)
+			assert.Equal(t, test.value, m.Value)
+			assert.Equal(t, "test-id", m.Dimensions["container_id"])
+			assert.Equal(t, "test-container", m.Dimensions["container_name"])
+		case <-time.After(1 * time.Second):
+			t.Fatal("no metric received for ", url)
+		}
+	}
+}
+
+func TestDockerStatsUpdateScrapeTargets(t *testing.T) {
+	d := getSUT()
+	d.updateScrapeTargets(map[string]string{"a": "http://172.17.0.2:8080/metrics"})
+	d.updateScrapeTargets(map[string]string{"b": "http://172.17.0.3:8080/metrics"})
+
+	assert.Equal(t, map[string]string{"b": "http://172.17.0.3:8080/metrics"}, d.scrapeTargets)
+}
@@ -0,0 +1,159 @@
+package collector
+
+import (
+	"fullerite/config"
+	"fullerite/metric"
+
+	"strings"
+	"time"
+
+	l "github.com/Sirupsen/logrus"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// defaultDockerEvents are the container lifecycle events reported by default
+var defaultDockerEvents = []string{"start", "stop", "die", "oom", "restart", "health_status"}
+
+// DockerEvents collector type.
+// It subscribes to the docker events stream and emits a DockerContainerEvent
+// counter for every reported container event. labels lists the container
+// labels which are added as dimensions.
+type DockerEvents struct {
+	baseCollector
+	dockerClient  *docker.Client
+	endpoint      string
+	events        map[string]bool
+	labels        []string
+	serverStarted bool
+	incoming      chan *docker.APIEvents
+}
+
+func init() {
+	RegisterCollector("DockerEvents", newDockerEvents)
+}
+
+// newDockerEvents creates a new DockerEvents collector.
+func newDockerEvents(channel chan metric.Metric, initialInterval int, log *l.Entry) Collector {
+	d := new(DockerEvents)
+
+	d.log = log
+	d.channel = channel
+	d.interval = initialInterval
+
+	d.name = "DockerEvents"
+	d.endpoint = endpoint
+	d.events = make(map[string]bool)
+	for _, event := range defaultDockerEvents {
+		d.events[event] = true
+	}
+	d.labels = []string{}
+	d.incoming = make(chan *docker.APIEvents)
+	d.serverStarted = false
+	d.SetCollectorType("listener")
+	return d
+}
+
+// Configure takes a dictionary of values with which the collector can configure itself.
+func (d *DockerEvents) Configure(configMap map[string]interface{}) {
+	if dockerEndpoint, exists := configMap["dockerEndPoint"]; exists {
+		d.endpoint = dockerEndpoint.(string)
+	}
+	if events, exists := configMap["events"]; exists {
+		d.events = make(map[string]bool)
+		for _, event := range config.GetAsSlice(events) {
+			d.events[event] = true
+		}
+	}
+	if labels, exists := configMap["labels"]; exists {
+		d.labels = config.GetAsSlice(labels)
+	}
+	d.dockerClient, _ = docker.NewClient(d.endpoint)
+	d.configureCommonParams(configMap)
+}
+
+// Collect registers the event listener on the first call and then converts
+// the received events to metrics. It returns if the listener cannot be
+// registered, so the next call retries.
+func (d *DockerEvents) Collect() {
+	if !d.serverStarted {
+		if d.dockerClient == nil {
+			d.log.Error("Invalid endpoint: ", docker.ErrInvalidEndpoint)
+			return
+		}
+		if err := d.dockerClient.AddEventListener(d.incoming); err != nil {
+			d.log.Error("Failed to listen for docker events: ", err)
+			return
+		}
+		d.serverStarted = true
+	}
+
+	for event := range d.incoming {
+		if m, ok := d.buildEventMetric(event); ok {
+			d.Channel() <- m
+		}
+	}
+	d.log.Warn("Docker event stream closed")
+	d.serverStarted = false
+	d.incoming = make(chan *docker.APIEvents)
+}
+
+// buildEventMetric converts a container event into a counter. Events which
+// are not configured are ignored. The exit code is added to die events and
+// the status to health_status events.
+func (d *DockerEvents) buildEventMetric(event *docker.APIEvents) (metric.Metric, bool) {
+	if event.Type != "" && event.Type != "container" {
+		return metric.Metric{}, false
+	}
+	action := event.Action
+	if action == "" {
+		// events from docker < 1.10 only set Status
+		action = event.Status
+	}
+	status := ""
+	if parts := strings.SplitN(action, ":", 2); len(parts) == 2 {
+		action, status = parts[0], strings.TrimSpace(parts[1])
+	}
+	if !d.events[action] {
+		return metric.Metric{}, false
+	}
+
+	attributes := event.Actor.Attributes
+	image := attributes["image"]
+	if image == "" {
+		image = event.From
+	}
+
+	m := metric.New("DockerContainerEvent")
+	m.MetricType = metric.Counter
+	m.Value = 1
+	m.AddDimension("event", action)
+	if image != "" {
+		m.AddDimension("image", image)
+	}
+	if name, exists := attributes["name"]; exists {
+		m.AddDimension("container_name", name)
+	}
+	if action == "die" {
+		if exitCode, exists := attributes["exitCode"]; exists {
+			m.AddDimension("exit_code", exitCode)
+		}
+	}
+	if status != "" {
+		m.AddDimension("status", status)
+	}
+	for _, label := range d.labels {
+		if value, exists := attributes[label]; exists {
+			m.AddDimension(label, value)
+		}
+	}
+	switch {
+	case event.TimeNano > 0:
+		m.SetTime(time.Unix(0, event.TimeNano))
+	case event.Time > 0:
+		m.SetTime(time.Unix(event.Time, 0))
+	default:
+		m.SetTime(time.Now())
+	}
+	return m, true
+}
@@ -0,0 +1,130 @@
+package collector
+
+import (
+	"fullerite/metric"
+	"test_utils"
+
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getTestDockerEvents(config map[string]interface{}) *DockerEvents {
+	d := newDockerEvents(make(chan metric.Metric), 10, test_utils.BuildLogger()).(*DockerEvents)
+	d.Configure(config)
+	return d
+}
+
+func testContainerEvent(action string, attributes map[string]string) *docker.APIEvents {
+	return &docker.APIEvents{
+		Action:   action,
+		Type:     "container",
+		Actor:    docker.APIActor{ID: "abc123", Attributes: attributes},
+		TimeNano: 1465839830000000000,
+	}
+}
+
+func TestDockerEventsConfigureEmptyConfig(t *testing.T) {
+	d := newDockerEvents(nil, 12, nil).(*DockerEvents)
+	d.Configure(make(map[string]interface{}))
+
+	assert := assert.New(t)
+	assert.Equal(12, d.Interval())
+	assert.Equal("listener", d.CollectorType())
+	assert.Equal(endpoint, d.endpoint)
+	assert.Equal(len(defaultDockerEvents), len(d.events))
+	assert.Empty(d.labels)
+}
+
+func TestDockerEventsConfigure(t *testing.T) {
+	config := map[string]interface{}{
+		"dockerEndPoint": "tcp://localhost:2375",
+		"events":         []interface{}{"die", "oom"},
+		"labels":         []interface{}{"service"},
+	}
+	d := newDockerEvents(nil, 12, nil).(*DockerEvents)
+	d.Configure(config)
+
+	assert := assert.New(t)
+	assert.Equal("tcp://localhost:2375", d.endpoint)
+	assert.Equal(map[string]bool{"die": true, "oom": true}, d.events)
+	assert.Equal([]string{"service"}, d.labels)
+}
+
+func TestDockerEventsBuildEventMetricDie(t *testing.T) {
+	d := getTestDockerEvents(map[string]interface{}{"labels": []interface{}{"service"}})
+
+	m, ok := d.buildEventMetric(testContainerEvent("die", map[string]string{
+		"image":    "redis:3",
+		"name":     "cache",
+		"exitCode": "137",
+		"service":  "session_store",
+		"other":    "ignored",
+	}))
+	require.True(t, ok)
+
+	assert := assert.New(t)
+	assert.Equal("DockerContainerEvent", m.Name)
+	assert.Equal(metric.Counter, m.MetricType)
+	assert.Equal(1.0, m.Value)
+	assert.Equal(map[string]string{
+		"event":          "die",
+		"image":          "redis-3",
+		"container_name": "cache",
+		"exit_code":      "137",
+		"service":        "session_store",
+	}, m.Dimensions)
+	assert.Equal(time.Unix(1465839830, 0), m.GetTime())
+}
+
+func TestDockerEventsBuildEventMetricHealthStatus(t *testing.T) {
+	d := getTestDockerEvents(map[string]interface{}{})
+
+	m, ok := d.buildEventMetric(testContainerEvent("health_status: unhealthy", map[string]string{"name": "web"}))
+	require.True(t, ok)
+	assert.Equal(t, "health_status", m.Dimensions["event"])
+	assert.Equal(t, "unhealthy", m.Dimensions["status"])
+}
+
+func TestDockerEventsBuildEventMetricLegacyFormat(t *testing.T) {
+	d := getTestDockerEvents(map[string]interface{}{})
+
+	m, ok := d.buildEventMetric(&docker.APIEvents{Status: "oom", ID: "abc123", From: "java:8", Time: 1465839830})
+	require.True(t, ok)
+	assert.Equal(t, "oom", m.Dimensions["event"])
+	assert.Equal(t, "java-8", m.Dimensions["image"])
+	assert.Equal(t, time.Unix(1465839830, 0), m.GetTime())
+}
+
+func TestDockerEventsBuildEventMetricIgnored(t *testing.T) {
+	d := getTestDockerEvents(map[string]interface{}{"events": []interface{}{"die"}})
+
+	_, ok := d.buildEventMetric(testContainerEvent("start", nil))
+	assert.False(t, ok, "events which are not configured should be ignored")
+
+	event := testContainerEvent("die", nil)
+	event.Type = "network"
+	_, ok = d.buildEventMetric(event)
+	assert.False(t, ok, "only container events should be reported")
+}
+
+func TestDockerEventsCollect(t *testing.T) {
+	d := getTestDockerEvents(map[string]interface{}{})
+	// pretend the listener is registered so no docker daemon is needed
+	d.serverStarted = true
+	go d.Collect()
+
+	d.incoming <- testContainerEvent("exec_create", nil)
+	d.incoming <- testContainerEvent("restart", map[string]string{"name": "flaky"})
+
+	select {
+	case m := <-d.Channel():
+		assert.Equal(t, "restart", m.Dimensions["event"])
+		assert.Equal(t, "flaky", m.Dimensions["container_name"])
+	case <-time.After(1 * time.Second):
+		t.Fatal("no metric received")
+	}
+}
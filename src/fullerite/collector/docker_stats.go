@@ -1,10 +1,12 @@
 package collector
 
 import (
+	"fmt"
 	"fullerite/config"
 	"fullerite/metric"
 	"reflect"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -18,29 +20,63 @@ const (
 	endpoint = "unix:///var/run/docker.sock"
 )
 
+// Metric groups which can be enabled through extendedMetrics on top of the
+// default memory, cpu and network metrics.
+const (
+	blkioMetrics         = "blkio"
+	cpuThrottlingMetrics = "cpu_throttling"
+	cpuUsageMetrics      = "cpu_usage"
+	memoryDetailMetrics  = "memory_details"
+	memoryPercentMetrics = "memory_percent"
+	networkErrorMetrics  = "network_errors"
+)
+
+var extendedDockerMetrics = []string{
+	blkioMetrics,
+	cpuThrottlingMetrics,
+	cpuUsageMetrics,
+	memoryDetailMetrics,
+	memoryPercentMetrics,
+	networkErrorMetrics,
+}
+
 // DockerStats collector type.
 // previousCPUValues contains the last cpu-usage values per container.
 // dockerClient is the client for the Docker remote API.
+// extendedMetrics contains the enabled optional metric groups.
+// compiledRegex and labelRegex generate dimensions from env variables and labels.
+// labelInclude, labelExclude, labelRename and maxLabelDims control which
+// container labels become dimensions and how they are named.
+// scrapeTargets maps the ids of the containers discovered through their labels
+// to the URL scraped for application metrics (see docker_discovery.go).
 type DockerStats struct {
 	baseCollector
 	previousCPUValues map[string]*CPUValues
 	dockerClient      *docker.Client
 	statsTimeout      int
 	compiledRegex     map[string]*Regex
+	labelRegex        map[string]*Regex
+	labelInclude      []*regexp.Regexp
+	labelExclude      []*regexp.Regexp
+	labelRename       map[string]string
+	maxLabelDims      int
 	skipRegex         *regexp.Regexp
 	bufferRegex       *regexp.Regexp
 	endpoint          string
+	extendedMetrics   map[string]bool
+	scrapeDiscovery   bool
+	scrapeTargets     map[string]string
 	mu                *sync.Mutex
 }
 
 // CPUValues struct contains the last cpu-usage values in order to compute properly the current values.
 // (see calculateCPUPercent() for more details)
 type CPUValues struct {
-	totCPU, systemCPU uint64
+	totCPU, systemCPU, userCPU, kernelCPU uint64
 }
 
-// Regex struct contains the info used to get the user specific dimensions from the docker env variables
-// tag: is the environmental variable you want to get the value from
+// Regex struct contains the info used to get the user specific dimensions from the docker env variables or labels
+// tag: is the environmental variable or label you want to get the value from
 // regex: is the reg exp used to extract the value from the env var
 type Regex struct {
 	tag   string
@@ -63,6 +99,10 @@ func newDockerStats(channel chan metric.Metric, initialInterval int, log *l.Entr
 	d.name = "DockerStats"
 	d.previousCPUValues = make(map[string]*CPUValues)
 	d.compiledRegex = make(map[string]*Regex)
+	d.labelRegex = make(map[string]*Regex)
+	d.labelRename = make(map[string]string)
+	d.extendedMetrics = make(map[string]bool)
+	d.scrapeTargets = make(map[string]string)
 	return d
 }
 
@@ -89,16 +129,22 @@ func (d *DockerStats) Configure(configMap map[string]interface{}) {
 	}
 	d.dockerClient, _ = docker.NewClient(d.endpoint)
 	if generatedDimensions, exists := configMap["generatedDimensions"]; exists {
-		for dimension, generator := range generatedDimensions.(map[string]interface{}) {
-			for key, regx := range config.GetAsMap(generator) {
-				re, err := regexp.Compile(regx)
-				if err != nil {
-					d.log.Warn("Failed to compile regex: ", regx, err)
-				} else {
-					d.compiledRegex[dimension] = &Regex{regex: re, tag: key}
-				}
-			}
-		}
+		d.compileGenerators(generatedDimensions, d.compiledRegex)
+	}
+	if generatedDimensions, exists := configMap["generatedLabelDimensions"]; exists {
+		d.compileGenerators(generatedDimensions, d.labelRegex)
+	}
+	if include, exists := configMap["labelInclude"]; exists {
+		d.labelInclude = d.compilePatterns(include)
+	}
+	if exclude, exists := configMap["labelExclude"]; exists {
+		d.labelExclude = d.compilePatterns(exclude)
+	}
+	if rename, exists := configMap["labelRename"]; exists {
+		d.labelRename = config.GetAsMap(rename)
+	}
+	if maxDims, exists := configMap["maxLabelDimensions"]; exists {
+		d.maxLabelDims = config.GetAsInt(maxDims, 0)
 	}
 	if skipRegex, skipExists := configMap["skipContainerRegex"]; skipExists {
 		d.skipRegex = regexp.MustCompile(skipRegex.(string))
@@ -106,12 +152,62 @@ func (d *DockerStats) Configure(configMap map[string]interface{}) {
 	if bufferRegex, exists := configMap["bufferRegex"]; exists {
 		d.bufferRegex = regexp.MustCompile(bufferRegex.(string))
 	}
+	if groups, exists := configMap["extendedMetrics"]; exists {
+		d.extendedMetrics = make(map[string]bool)
+		for _, group := range config.GetAsSlice(groups) {
+			if !isExtendedDockerMetric(group) {
+				d.log.Warn("Unknown extended metric group: ", group)
+				continue
+			}
+			d.extendedMetrics[group] = true
+		}
+	}
+	if scrapeDiscovery, exists := configMap["scrapeDiscovery"]; exists {
+		if enabled, ok := scrapeDiscovery.(bool); ok {
+			d.scrapeDiscovery = enabled
+		} else {
+			d.log.Warn("Failed to cast scrapeDiscovery: ", reflect.TypeOf(scrapeDiscovery))
+		}
+	}
 	d.configureCommonParams(configMap)
 }
 
+// compileGenerators compiles a {"dimension": {"tag": "regex"}} map into generators.
+func (d *DockerStats) compileGenerators(value interface{}, generators map[string]*Regex) {
+	generatedDimensions, ok := value.(map[string]interface{})
+	if !ok {
+		d.log.Warn("Failed to cast generated dimensions: ", reflect.TypeOf(value))
+		return
+	}
+	for dimension, generator := range generatedDimensions {
+		for key, regx := range config.GetAsMap(generator) {
+			re, err := regexp.Compile(regx)
+			if err != nil {
+				d.log.Warn("Failed to compile regex: ", regx, err)
+			} else {
+				generators[dimension] = &Regex{regex: re, tag: key}
+			}
+		}
+	}
+}
+
+func (d *DockerStats) compilePatterns(value interface{}) []*regexp.Regexp {
+	patterns := []*regexp.Regexp{}
+	for _, pattern := range config.GetAsSlice(value) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			d.log.Warn("Failed to compile regex: ", pattern, err)
+			continue
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns
+}
+
 // Collect iterates on all the docker containers alive and, if possible, collects the correspondent
 // memory and cpu statistics.
 // For each container a gorutine is started to spin up the collection process.
+// With scrapeDiscovery enabled labeled containers are also scraped for application metrics.
 func (d *DockerStats) Collect() {
 	if d.dockerClient == nil {
 		d.log.Error("Invalid endpoint: ", docker.ErrInvalidEndpoint)
@@ -122,6 +218,7 @@ func (d *DockerStats) Collect() {
 		d.log.Error("ListContainers() failed: ", err)
 		return
 	}
+	scrapeTargets := make(map[string]string)
 	for _, apiContainer := range containers {
 		container, err := d.dockerClient.InspectContainer(apiContainer.ID)
 		contName := strings.TrimPrefix(container.Name, "/")
@@ -138,6 +235,16 @@ func (d *DockerStats) Collect() {
 			d.previousCPUValues[container.ID] = new(CPUValues)
 		}
 		go d.getDockerContainerInfo(container)
+
+		if d.scrapeDiscovery {
+			if url, format, ok := scrapeTarget(container); ok {
+				scrapeTargets[container.ID] = url
+				go d.scrapeContainer(container, url, format)
+			}
+		}
+	}
+	if d.scrapeDiscovery {
+		d.updateScrapeTargets(scrapeTargets)
 	}
 }
 
@@ -178,6 +285,8 @@ func (d *DockerStats) extractMetrics(container *docker.Container, stats *docker.
 
 	d.previousCPUValues[container.ID].totCPU = stats.CPUStats.CPUUsage.TotalUsage
 	d.previousCPUValues[container.ID].systemCPU = stats.CPUStats.SystemCPUUsage
+	d.previousCPUValues[container.ID].userCPU = stats.CPUStats.CPUUsage.UsageInUsermode
+	d.previousCPUValues[container.ID].kernelCPU = stats.CPUStats.CPUUsage.UsageInKernelmode
 	return metrics
 }
 
@@ -197,18 +306,129 @@ func (d DockerStats) buildMetrics(container *docker.Container, containerStats *d
 		rxb.AddDimension("iface", netiface)
 		ret = append(ret, rxb)
 	}
-	additionalDimensions := map[string]string{
+	ret = append(ret, d.buildExtendedMetrics(container, containerStats)...)
+	metric.AddToAll(&ret, d.containerDimensions(container))
+	ret = append(ret, d.buildDockerMetric("DockerContainerCount", metric.Counter, 1))
+	metric.AddToAll(&ret, d.extractDimensions(container))
+
+	return ret
+}
+
+// buildExtendedMetrics creates the metrics of the enabled extendedMetrics groups.
+func (d DockerStats) buildExtendedMetrics(container *docker.Container, containerStats *docker.Stats) []metric.Metric {
+	ret := []metric.Metric{}
+	if d.extendedMetrics[blkioMetrics] {
+		ret = append(ret, d.buildBlkioMetrics("DockerBlkioServiceBytes", containerStats.BlkioStats.IOServiceBytesRecursive)...)
+		ret = append(ret, d.buildBlkioMetrics("DockerBlkioServiced", containerStats.BlkioStats.IOServicedRecursive)...)
+	}
+	if d.extendedMetrics[cpuThrottlingMetrics] {
+		throttling := containerStats.CPUStats.ThrottlingData
+		ret = append(ret,
+			d.buildDockerMetric("DockerCpuPeriods", metric.CumulativeCounter, float64(throttling.Periods)),
+			d.buildDockerMetric("DockerCpuThrottledPeriods", metric.CumulativeCounter, float64(throttling.ThrottledPeriods)),
+			d.buildDockerMetric("DockerCpuThrottledTime", metric.CumulativeCounter, float64(throttling.ThrottledTime)),
+		)
+	}
+	if d.extendedMetrics[cpuUsageMetrics] {
+		previous := d.previousCPUValues[container.ID]
+		if previous == nil {
+			previous = new(CPUValues)
+		}
+		usage := containerStats.CPUStats.CPUUsage
+		ret = append(ret,
+			d.buildDockerMetric("DockerCpuUserPercentage", metric.Gauge,
+				calculateCPUModePercent(previous.userCPU, usage.UsageInUsermode, previous.systemCPU, containerStats)),
+			d.buildDockerMetric("DockerCpuSystemPercentage", metric.Gauge,
+				calculateCPUModePercent(previous.kernelCPU, usage.UsageInKernelmode, previous.systemCPU, containerStats)),
+		)
+	}
+	if d.extendedMetrics[memoryDetailMetrics] {
+		memory := containerStats.MemoryStats.Stats
+		ret = append(ret,
+			d.buildDockerMetric("DockerMemoryCache", metric.Gauge, float64(memory.Cache)),
+			d.buildDockerMetric("DockerMemoryRss", metric.Gauge, float64(memory.Rss)),
+			d.buildDockerMetric("DockerMemorySwap", metric.Gauge, float64(memory.Swap)),
+			d.buildDockerMetric("DockerMemoryPgfault", metric.CumulativeCounter, float64(memory.Pgfault)),
+			d.buildDockerMetric("DockerMemoryPgmajfault", metric.CumulativeCounter, float64(memory.Pgmajfault)),
+		)
+	}
+	if d.extendedMetrics[memoryPercentMetrics] && containerStats.MemoryStats.Limit > 0 {
+		percent := float64(containerStats.MemoryStats.Usage) / float64(containerStats.MemoryStats.Limit) * 100.0
+		ret = append(ret, d.buildDockerMetric("DockerMemoryPercentage", metric.Gauge, percent))
+	}
+	if d.extendedMetrics[networkErrorMetrics] {
+		for netiface, stats := range containerStats.Networks {
+			ifaceMetrics := []metric.Metric{
+				d.buildDockerMetric("DockerRxErrors", metric.CumulativeCounter, float64(stats.RxErrors)),
+				d.buildDockerMetric("DockerTxErrors", metric.CumulativeCounter, float64(stats.TxErrors)),
+				d.buildDockerMetric("DockerRxDropped", metric.CumulativeCounter, float64(stats.RxDropped)),
+				d.buildDockerMetric("DockerTxDropped", metric.CumulativeCounter, float64(stats.TxDropped)),
+			}
+			metric.AddToAll(&ifaceMetrics, map[string]string{"iface": netiface})
+			ret = append(ret, ifaceMetrics...)
+		}
+	}
+	return ret
+}
+
+// buildBlkioMetrics creates one metric per device and operation.
+func (d DockerStats) buildBlkioMetrics(name string, entries []docker.BlkioStatsEntry) []metric.Metric {
+	ret := make([]metric.Metric, 0, len(entries))
+	for _, entry := range entries {
+		m := d.buildDockerMetric(name, metric.CumulativeCounter, float64(entry.Value))
+		m.AddDimension("device", fmt.Sprintf("%d:%d", entry.Major, entry.Minor))
+		m.AddDimension("op", strings.ToLower(entry.Op))
+		ret = append(ret, m)
+	}
+	return ret
+}
+
+// containerDimensions returns the id, name and labels of the container.
+// Labels are filtered by labelInclude and labelExclude, renamed according to
+// labelRename and capped at maxLabelDims, keeping the first labels by name.
+func (d DockerStats) containerDimensions(container *docker.Container) map[string]string {
+	dimensions := map[string]string{
 		"container_id":   container.ID,
 		"container_name": strings.TrimPrefix(container.Name, "/"),
 	}
-	for k, v := range container.Config.Labels {
-		additionalDimensions[k] = v
+	labels := make([]string, 0, len(container.Config.Labels))
+	for label := range container.Config.Labels {
+		if d.includeLabel(label) {
+			labels = append(labels, label)
+		}
 	}
-	metric.AddToAll(&ret, additionalDimensions)
-	ret = append(ret, d.buildDockerMetric("DockerContainerCount", metric.Counter, 1))
-	metric.AddToAll(&ret, d.extractDimensions(container))
+	sort.Strings(labels)
+	if d.maxLabelDims > 0 && len(labels) > d.maxLabelDims {
+		d.log.Debug("Dropping ", len(labels)-d.maxLabelDims, " label dimensions of container ", container.ID)
+		labels = labels[:d.maxLabelDims]
+	}
+	for _, label := range labels {
+		dimension := label
+		if renamed, exists := d.labelRename[label]; exists {
+			dimension = renamed
+		}
+		dimensions[dimension] = container.Config.Labels[label]
+	}
+	return dimensions
+}
 
-	return ret
+// includeLabel reports whether a label passes labelInclude and labelExclude.
+// Without labelInclude every label is included.
+func (d DockerStats) includeLabel(label string) bool {
+	for _, re := range d.labelExclude {
+		if re.MatchString(label) {
+			return false
+		}
+	}
+	if len(d.labelInclude) == 0 {
+		return true
+	}
+	for _, re := range d.labelInclude {
+		if re.MatchString(label) {
+			return true
+		}
+	}
+	return false
 }
 
 // sendMetrics writes all the metrics received to the collector channel.
@@ -218,7 +438,7 @@ func (d DockerStats) sendMetrics(metrics []metric.Metric) {
 	}
 }
 
-// Function that extracts additional dimensions from the docker environmental variables set up by the user
+// Function that extracts additional dimensions from the docker environmental variables and labels set up by the user
 // in the configuration file.
 func (d DockerStats) extractDimensions(container *docker.Container) map[string]string {
 	envVars := container.Config.Env
@@ -235,6 +455,18 @@ func (d DockerStats) extractDimensions(container *docker.Container) map[string]s
 			}
 		}
 	}
+	if container.Config.Labels != nil {
+		for dimension, r := range d.labelRegex {
+			value, exists := container.Config.Labels[r.tag]
+			if !exists {
+				continue
+			}
+			subMatch := r.regex.FindStringSubmatch(value)
+			if len(subMatch) > 0 {
+				ret[dimension] = strings.Replace(subMatch[len(subMatch)-1], "--", "_", -1)
+			}
+		}
+	}
 	d.log.Debug(ret)
 	return ret
 }
@@ -266,6 +498,26 @@ func calculateCPUPercent(previousCPU, previousSystem uint64, stats *docker.Stats
 	return cpuPercent
 }
 
+// calculateCPUModePercent computes the cpu percentage spent in a single mode
+// (user or kernel) the same way calculateCPUPercent does for the total.
+func calculateCPUModePercent(previousUsage, usage, previousSystem uint64, stats *docker.Stats) float64 {
+	if usage < previousUsage || stats.CPUStats.SystemCPUUsage <= previousSystem {
+		return 0.0
+	}
+	cpuDelta := float64(usage - previousUsage)
+	systemDelta := float64(stats.CPUStats.SystemCPUUsage - previousSystem)
+	return (cpuDelta / systemDelta) * float64(len(stats.CPUStats.CPUUsage.PercpuUsage)) * 100.0
+}
+
+func isExtendedDockerMetric(group string) bool {
+	for _, known := range extendedDockerMetrics {
+		if group == known {
+			return true
+		}
+	}
+	return false
+}
+
 func min(x, y int) int {
 	if x < y {
 		return x
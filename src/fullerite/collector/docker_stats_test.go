@@ -285,3 +285,162 @@ func TestDockerStatsCalculateCPUPercent(t *testing.T) {
 
 	assert.Equal(t, 0.060815135225936505, calculateCPUPercent(previousTotalUsage, previousSystem, stats))
 }
+
+func TestDockerStatsConfigureExtendedMetrics(t *testing.T) {
+	config := make(map[string]interface{})
+	config["extendedMetrics"] = []interface{}{"blkio", "memory_percent", "unknown"}
+
+	d := getSUT()
+	d.Configure(config)
+
+	assert.Equal(t, map[string]bool{"blkio": true, "memory_percent": true}, d.extendedMetrics)
+}
+
+func TestDockerStatsBuildExtendedMetrics(t *testing.T) {
+	stats := new(docker.Stats)
+	stats.Networks = map[string]docker.NetworkStats{
+		"eth0": {RxErrors: 1, TxErrors: 2, RxDropped: 3, TxDropped: 4},
+	}
+	stats.MemoryStats.Usage = 25
+	stats.MemoryStats.Limit = 100
+	stats.MemoryStats.Stats.Cache = 10
+	stats.MemoryStats.Stats.Rss = 15
+	stats.MemoryStats.Stats.Swap = 5
+	stats.MemoryStats.Stats.Pgfault = 1000
+	stats.MemoryStats.Stats.Pgmajfault = 7
+	stats.BlkioStats.IOServiceBytesRecursive = []docker.BlkioStatsEntry{
+		{Major: 8, Minor: 0, Op: "Read", Value: 4096},
+		{Major: 8, Minor: 0, Op: "Write", Value: 8192},
+	}
+	stats.BlkioStats.IOServicedRecursive = []docker.BlkioStatsEntry{
+		{Major: 8, Minor: 0, Op: "Read", Value: 3},
+	}
+	stats.CPUStats.ThrottlingData.Periods = 100
+	stats.CPUStats.ThrottlingData.ThrottledPeriods = 10
+	stats.CPUStats.ThrottlingData.ThrottledTime = 5000
+	stats.CPUStats.CPUUsage.PercpuUsage = make([]uint64, 2)
+	stats.CPUStats.CPUUsage.UsageInUsermode = 300
+	stats.CPUStats.CPUUsage.UsageInKernelmode = 150
+	stats.CPUStats.SystemCPUUsage = 2000
+
+	container := &docker.Container{ID: "test-id", Name: "/test-container", Config: &docker.Config{}}
+
+	config := make(map[string]interface{})
+	config["extendedMetrics"] = []interface{}{
+		"blkio", "cpu_throttling", "cpu_usage", "memory_details", "memory_percent", "network_errors",
+	}
+	d := getSUT()
+	d.Configure(config)
+	d.previousCPUValues["test-id"] = &CPUValues{userCPU: 100, kernelCPU: 50, systemCPU: 1000}
+
+	ret := d.buildMetrics(container, stats, 0.5)
+
+	tests := []struct {
+		name       string
+		dims       map[string]string
+		metricType string
+		value      float64
+	}{
+		{"DockerBlkioServiceBytes", map[string]string{"device": "8-0", "op": "write"}, metric.CumulativeCounter, 8192},
+		{"DockerBlkioServiced", map[string]string{"device": "8-0", "op": "read"}, metric.CumulativeCounter, 3},
+		{"DockerCpuPeriods", nil, metric.CumulativeCounter, 100},
+		{"DockerCpuThrottledPeriods", nil, metric.CumulativeCounter, 10},
+		{"DockerCpuThrottledTime", nil, metric.CumulativeCounter, 5000},
+		{"DockerCpuUserPercentage", nil, metric.Gauge, 40},
+		{"DockerCpuSystemPercentage", nil, metric.Gauge, 20},
+		{"DockerMemoryCache", nil, metric.Gauge, 10},
+		{"DockerMemoryRss", nil, metric.Gauge, 15},
+		{"DockerMemorySwap", nil, metric.Gauge, 5},
+		{"DockerMemoryPgfault", nil, metric.CumulativeCounter, 1000},
+		{"DockerMemoryPgmajfault", nil, metric.CumulativeCounter, 7},
+		{"DockerMemoryPercentage", nil, metric.Gauge, 25},
+		{"DockerRxErrors", map[string]string{"iface": "eth0"}, metric.CumulativeCounter, 1},
+		{"DockerTxErrors", map[string]string{"iface": "eth0"}, metric.CumulativeCounter, 2},
+		{"DockerRxDropped", map[string]string{"iface": "eth0"}, metric.CumulativeCounter, 3},
+		{"DockerTxDropped", map[string]string{"iface": "eth0"}, metric.CumulativeCounter, 4},
+	}
+	for _, test := range tests {
+		found := false
+		for _, m := range ret {
+			if m.Name == test.name && m.IsSubDim(test.dims) {
+				found = true
+				assert.Equal(t, test.metricType, m.MetricType, test.name)
+				assert.Equal(t, test.value, m.Value, test.name)
+				assert.Equal(t, "test-container", m.Dimensions["container_name"], test.name)
+			}
+		}
+		assert.True(t, found, test.name+" not found in metrics")
+	}
+}
+
+func TestDockerStatsBuildMetricsWithoutExtendedMetrics(t *testing.T) {
+	stats := new(docker.Stats)
+	stats.MemoryStats.Limit = 100
+	container := &docker.Container{ID: "test-id", Name: "/test-container", Config: &docker.Config{}}
+
+	d := getSUT()
+	d.Configure(make(map[string]interface{}))
+
+	// memory used, limit, cpu percentage and container count
+	assert.Equal(t, 4, len(d.buildMetrics(container, stats, 0.5)))
+}
+
+func TestDockerStatsContainerDimensionsLabelFilters(t *testing.T) {
+	config := map[string]interface{}{
+		"labelInclude": []interface{}{"^com\\.example\\.", "^team$"},
+		"labelExclude": []interface{}{"\\.build_id$"},
+		"labelRename":  map[string]interface{}{"com.example.service": "service_name"},
+	}
+	container := &docker.Container{
+		ID:   "test-id",
+		Name: "/test-container",
+		Config: &docker.Config{Labels: map[string]string{
+			"com.example.service":  "api",
+			"com.example.build_id": "1234",
+			"team":                 "infra",
+			"io.kubernetes.pod":    "api-1",
+		}},
+	}
+
+	d := getSUT()
+	d.Configure(config)
+
+	assert.Equal(t, map[string]string{
+		"container_id":   "test-id",
+		"container_name": "test-container",
+		"service_name":   "api",
+		"team":           "infra",
+	}, d.containerDimensions(container))
+}
+
+func TestDockerStatsContainerDimensionsMaxLabels(t *testing.T) {
+	container := &docker.Container{
+		ID:     "test-id",
+		Name:   "/test-container",
+		Config: &docker.Config{Labels: map[string]string{"c": "3", "a": "1", "b": "2"}},
+	}
+
+	d := getSUT()
+	d.Configure(map[string]interface{}{"maxLabelDimensions": 2})
+
+	assert.Equal(t, map[string]string{
+		"container_id":   "test-id",
+		"container_name": "test-container",
+		"a":              "1",
+		"b":              "2",
+	}, d.containerDimensions(container))
+}
+
+func TestDockerStatsExtractDimensionsFromLabels(t *testing.T) {
+	var generated map[string]interface{}
+	json.Unmarshal([]byte(`{"service_name": {"com.example.task": "^([^\\.]*)\\."}}`), &generated)
+	container := &docker.Container{
+		ID:     "test-id",
+		Config: &docker.Config{Labels: map[string]string{"com.example.task": "my--service.main.1234"}},
+	}
+
+	d := getSUT()
+	d.Configure(map[string]interface{}{"generatedLabelDimensions": generated})
+
+	assert.Equal(t, map[string]string{"service_name": "my_service"}, d.extractDimensions(container))
+}
@@ -0,0 +1,323 @@
+package collector
+
+import (
+	"fullerite/config"
+	"fullerite/metric"
+
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	l "github.com/Sirupsen/logrus"
+)
+
+// Nagios plugin states, also the plugin exit codes
+const (
+	nagiosOK       = 0
+	nagiosWarning  = 1
+	nagiosCritical = 2
+	nagiosUnknown  = 3
+)
+
+// Exec collector type.
+// It runs every configured command each interval and parses its output
+// according to the command's format, either Nagios plugin output or Graphite
+// plaintext lines. A command running longer than its timeout is killed along
+// with every process it started.
+type Exec struct {
+	baseCollector
+	commands []execCommand
+	timeout  int
+}
+
+type execCommand struct {
+	name    string
+	command string
+	format  string
+	timeout int
+}
+
+func init() {
+	RegisterCollector("Exec", newExec)
+}
+
+// newExec creates a new Exec collector.
+func newExec(channel chan metric.Metric, initialInterval int, log *l.Entry) Collector {
+	e := new(Exec)
+
+	e.log = log
+	e.channel = channel
+	e.interval = initialInterval
+
+	e.name = "Exec"
+	e.commands = []execCommand{}
+	return e
+}
+
+// Configure takes a dictionary of values with which the collector can configure itself.
+// commands is a list of {"name", "command", "format", "timeout"} maps, format
+// is "nagios" or "graphite" and timeout defaults to the collector timeout.
+func (e *Exec) Configure(configMap map[string]interface{}) {
+	e.configureCommonParams(configMap)
+
+	e.timeout = e.interval
+	if timeout, exists := configMap["timeout"]; exists {
+		e.timeout = min(config.GetAsInt(timeout, e.interval), e.interval)
+	}
+	if commands, exists := configMap["commands"]; exists {
+		e.commands = e.parseCommands(commands)
+	}
+}
+
+func (e *Exec) parseCommands(value interface{}) []execCommand {
+	commands := []execCommand{}
+	list, ok := value.([]interface{})
+	if !ok {
+		defaultLog.Warn("Expected a list of commands but got ", value)
+		return commands
+	}
+	for _, item := range list {
+		spec, ok := item.(map[string]interface{})
+		if !ok {
+			defaultLog.Warn("Expected a command but got ", item)
+			continue
+		}
+		cmd := execCommand{timeout: e.timeout, format: "nagios"}
+		cmd.name, _ = spec["name"].(string)
+		cmd.command, _ = spec["command"].(string)
+		if format, exists := spec["format"]; exists {
+			cmd.format, _ = format.(string)
+		}
+		if timeout, exists := spec["timeout"]; exists {
+			cmd.timeout = min(config.GetAsInt(timeout, e.timeout), e.interval)
+		}
+		if cmd.name == "" || cmd.command == "" {
+			defaultLog.Warn("Skipping command without name or command: ", spec)
+			continue
+		}
+		if cmd.format != "nagios" && cmd.format != "graphite" {
+			defaultLog.Warn("Skipping command ", cmd.name, " with unknown format ", cmd.format)
+			continue
+		}
+		commands = append(commands, cmd)
+	}
+	return commands
+}
+
+// Collect runs all commands in parallel.
+func (e *Exec) Collect() {
+	var wg sync.WaitGroup
+	for _, cmd := range e.commands {
+		wg.Add(1)
+		go func(cmd execCommand) {
+			defer wg.Done()
+			for _, m := range e.runCommand(cmd) {
+				e.Channel() <- m
+			}
+		}(cmd)
+	}
+	wg.Wait()
+}
+
+func (e *Exec) runCommand(cmd execCommand) []metric.Metric {
+	output, exitCode, err := runWithTimeout(cmd.command, time.Duration(cmd.timeout)*time.Second)
+	if err != nil {
+		e.log.Error("Command ", cmd.name, " failed: ", err)
+	}
+
+	var metrics []metric.Metric
+	switch cmd.format {
+	case "nagios":
+		if err != nil {
+			exitCode = nagiosUnknown
+		}
+		metrics = parseNagiosOutput(cmd.name, output, exitCode)
+	case "graphite":
+		if exitCode != 0 {
+			e.log.Warn("Command ", cmd.name, " exited with ", exitCode)
+		}
+		metrics = parseGraphiteOutput(output, e.log)
+	}
+	metric.AddToAll(&metrics, map[string]string{"command": cmd.name})
+	return metrics
+}
+
+// execKillGrace is how long runWithTimeout waits for the output to be
+// closed after killing the process group
+const execKillGrace = time.Second
+
+// runWithTimeout runs command through the shell in its own process group
+// and kills the whole group once timeout expires. A non-zero exit code is
+// not an error.
+func runWithTimeout(command string, timeout time.Duration) ([]byte, int, error) {
+	var stdout bytes.Buffer
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Stdout = &stdout
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return nil, 0, err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(timeout):
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		select {
+		case <-done:
+			return stdout.Bytes(), 0, fmt.Errorf("timed out after %s", timeout)
+		case <-time.After(execKillGrace):
+			// a process outside the group still holds stdout open, the
+			// output is still being copied so it is dropped
+			return nil, 0, fmt.Errorf("timed out after %s, output still open", timeout)
+		}
+	}
+
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			return stdout.Bytes(), status.ExitStatus(), nil
+		}
+	}
+	return stdout.Bytes(), 0, err
+}
+
+// parseNagiosOutput reports the plugin state and its performance data.
+// The state is the exit code, perfdata follows a "|" on the first line or
+// on any line of the long output.
+func parseNagiosOutput(name string, output []byte, exitCode int) []metric.Metric {
+	if exitCode < nagiosOK || exitCode > nagiosUnknown {
+		exitCode = nagiosUnknown
+	}
+	state := metric.WithValue(name+".state", float64(exitCode))
+	metrics := []metric.Metric{state}
+
+	perfdata := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	inPerfdata := false
+	for lineNo := 0; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		if inPerfdata {
+			perfdata = append(perfdata, line)
+			continue
+		}
+		if idx := strings.Index(line, "|"); idx >= 0 {
+			perfdata = append(perfdata, line[idx+1:])
+			// after a "|" in the long output every following line is perfdata
+			inPerfdata = lineNo > 0
+		}
+	}
+
+	for _, chunk := range perfdata {
+		for _, item := range splitNagiosPerfdata(chunk) {
+			if m, ok := parseNagiosPerfdataItem(name, item); ok {
+				metrics = append(metrics, m)
+			}
+		}
+	}
+	return metrics
+}
+
+// splitNagiosPerfdata splits perfdata on spaces outside of quoted labels.
+func splitNagiosPerfdata(s string) []string {
+	items := []string{}
+	var current bytes.Buffer
+	quoted := false
+	for _, r := range s {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+			current.WriteRune(r)
+		case (r == ' ' || r == '\t') && !quoted:
+			if current.Len() > 0 {
+				items = append(items, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		items = append(items, current.String())
+	}
+	return items
+}
+
+// parseNagiosPerfdataItem parses 'label'=value[UOM];[warn];[crit];[min];[max].
+// The unit and thresholds become dimensions, the "c" unit marks a counter.
+func parseNagiosPerfdataItem(name, item string) (metric.Metric, bool) {
+	eq := strings.LastIndex(item, "=")
+	if eq <= 0 {
+		return metric.Metric{}, false
+	}
+	label := strings.Replace(strings.Trim(item[:eq], "'"), " ", "_", -1)
+	fields := strings.Split(item[eq+1:], ";")
+
+	raw := fields[0]
+	unitStart := strings.IndexFunc(raw, func(r rune) bool {
+		return !(r >= '0' && r <= '9' || r == '.' || r == '-' || r == '+' || r == 'e' || r == 'E')
+	})
+	unit := ""
+	if unitStart >= 0 {
+		raw, unit = raw[:unitStart], raw[unitStart:]
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return metric.Metric{}, false
+	}
+
+	m := metric.WithValue(name+"."+label, value)
+	if unit == "c" {
+		m.MetricType = metric.CumulativeCounter
+	} else if unit != "" {
+		m.AddDimension("unit", unit)
+	}
+	for i, dim := range []string{"warn", "crit", "min", "max"} {
+		if i+1 < len(fields) && fields[i+1] != "" {
+			m.AddDimension(dim, fields[i+1])
+		}
+	}
+	return m, true
+}
+
+// parseGraphiteOutput parses "path value [timestamp]" lines.
+func parseGraphiteOutput(output []byte, log *l.Entry) []metric.Metric {
+	metrics := []metric.Metric{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			log.Warn("Invalid graphite line: ", scanner.Text())
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			log.Warn("Invalid value in graphite line: ", scanner.Text())
+			continue
+		}
+		m := metric.WithValue(fields[0], value)
+		if len(fields) == 3 {
+			ts, err := strconv.ParseFloat(fields[2], 64)
+			if err != nil {
+				log.Warn("Invalid timestamp in graphite line: ", scanner.Text())
+				continue
+			}
+			m.SetTime(time.Unix(int64(ts), 0))
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
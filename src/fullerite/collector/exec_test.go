@@ -0,0 +1,133 @@
+package collector
+
+import (
+	"fullerite/metric"
+	"test_utils"
+
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getTestExec(commands []interface{}) *Exec {
+	e := newExec(make(chan metric.Metric), 10, test_utils.BuildLogger()).(*Exec)
+	e.Configure(map[string]interface{}{"commands": commands})
+	return e
+}
+
+func TestExecConfigure(t *testing.T) {
+	e := newExec(nil, 12, nil).(*Exec)
+	e.Configure(map[string]interface{}{
+		"timeout": 30,
+		"commands": []interface{}{
+			map[string]interface{}{"name": "disk", "command": "check_disk -w 10%"},
+			map[string]interface{}{"name": "app", "command": "app_stats", "format": "graphite", "timeout": "3"},
+			map[string]interface{}{"name": "bad", "command": "x", "format": "xml"},
+			map[string]interface{}{"command": "no name"},
+		},
+	})
+
+	assert := assert.New(t)
+	assert.Equal(12, e.timeout, "the timeout should not exceed the interval")
+	require.Equal(t, 2, len(e.commands))
+	assert.Equal(execCommand{name: "disk", command: "check_disk -w 10%", format: "nagios", timeout: 12}, e.commands[0])
+	assert.Equal(execCommand{name: "app", command: "app_stats", format: "graphite", timeout: 3}, e.commands[1])
+}
+
+func TestParseNagiosOutput(t *testing.T) {
+	output := "DISK WARNING - free space: / 3326 MB (56%); | /=2643MB;5948;5958;0;5968\n" +
+		"/ 15272 MB (77%);\n" +
+		"/boot 68 MB (69%); | /boot=68MB;88;93;0;98\n" +
+		"'/home dir'=69%;;;0;100 requests=1200c\n"
+
+	metrics := parseNagiosOutput("disk", []byte(output), 1)
+	assert := assert.New(t)
+	require.Equal(t, 5, len(metrics))
+
+	state, found := findMetric(metrics, "disk.state", nil)
+	require.True(t, found)
+	assert.Equal(1.0, state.Value)
+	assert.Equal(metric.Gauge, state.MetricType)
+
+	root, found := findMetric(metrics, "disk./", nil)
+	require.True(t, found)
+	assert.Equal(2643.0, root.Value)
+	assert.Equal(map[string]string{"unit": "MB", "warn": "5948", "crit": "5958", "min": "0", "max": "5968"}, root.Dimensions)
+
+	home, found := findMetric(metrics, "disk./home_dir", nil)
+	require.True(t, found)
+	assert.Equal(69.0, home.Value)
+	assert.Equal(map[string]string{"unit": "%", "min": "0", "max": "100"}, home.Dimensions)
+
+	requests, found := findMetric(metrics, "disk.requests", nil)
+	require.True(t, found)
+	assert.Equal(metric.CumulativeCounter, requests.MetricType)
+	assert.Empty(requests.Dimensions)
+}
+
+func TestParseNagiosOutputUnknownState(t *testing.T) {
+	metrics := parseNagiosOutput("check", []byte("segfault"), 139)
+	require.Equal(t, 1, len(metrics))
+	assert.Equal(t, float64(nagiosUnknown), metrics[0].Value)
+}
+
+func TestParseGraphiteOutput(t *testing.T) {
+	output := "app.requests 42 1465839830\n\napp.latency -0.5\ninvalid\napp.bad abc 1\n"
+	metrics := parseGraphiteOutput([]byte(output), test_utils.BuildLogger())
+
+	require.Equal(t, 2, len(metrics))
+	assert.Equal(t, "app.requests", metrics[0].Name)
+	assert.Equal(t, 42.0, metrics[0].Value)
+	assert.Equal(t, time.Unix(1465839830, 0), metrics[0].GetTime())
+	assert.Equal(t, -0.5, metrics[1].Value)
+}
+
+func TestRunWithTimeout(t *testing.T) {
+	output, exitCode, err := runWithTimeout("echo hello; exit 2", time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, exitCode)
+	assert.Equal(t, "hello\n", string(output))
+}
+
+func TestRunWithTimeoutKillsProcessGroup(t *testing.T) {
+	start := time.Now()
+	// the background sleep keeps stdout open unless the whole group is killed
+	_, _, err := runWithTimeout("sleep 10 & sleep 10", 200*time.Millisecond)
+
+	assert.NotNil(t, err)
+	assert.True(t, time.Since(start) < 5*time.Second, "the command should have been killed")
+}
+
+func TestRunWithTimeoutEscapedProcess(t *testing.T) {
+	start := time.Now()
+	// setsid leaves the process group, it keeps stdout open after the kill
+	_, _, err := runWithTimeout("setsid sleep 3 & sleep 10", 200*time.Millisecond)
+
+	assert.NotNil(t, err)
+	assert.True(t, time.Since(start) < 2*time.Second, "should stop waiting for the output")
+}
+
+func TestExecCollect(t *testing.T) {
+	e := getTestExec([]interface{}{
+		map[string]interface{}{"name": "ping", "command": "echo 'PING OK | rta=0.5ms;100;500'"},
+		map[string]interface{}{"name": "app", "command": "echo 'app.up 1'", "format": "graphite"},
+	})
+
+	go func() {
+		e.Collect()
+		close(e.channel)
+	}()
+	metrics := []metric.Metric{}
+	for m := range e.Channel() {
+		metrics = append(metrics, m)
+	}
+
+	assert.Equal(t, 3, len(metrics))
+	m, found := findMetric(metrics, "ping.rta", map[string]string{"command": "ping", "unit": "ms"})
+	require.True(t, found)
+	assert.Equal(t, 0.5, m.Value)
+	_, found = findMetric(metrics, "app.up", map[string]string{"command": "app"})
+	assert.True(t, found)
+}
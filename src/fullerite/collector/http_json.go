@@ -0,0 +1,270 @@
+package collector
+
+import (
+	"fullerite/config"
+	"fullerite/metric"
+	"fullerite/util"
+
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	l "github.com/Sirupsen/logrus"
+)
+
+const (
+	// DefaultHTTPJSONTimeout is the request timeout in seconds
+	DefaultHTTPJSONTimeout = 5
+)
+
+// HTTPJSON collector type.
+// It GETs every configured URL and turns the numeric leaves of the returned
+// JSON document into metrics. selectors restrict which parts of the document
+// are reported; without selectors every numeric leaf is.
+type HTTPJSON struct {
+	baseCollector
+	urls      []string
+	headers   map[string]string
+	timeout   int
+	selectors []jsonSelector
+	clients   map[string]*util.HTTPAlive
+}
+
+// jsonSelector picks a part of a JSON document.
+// path is a dot separated list of keys. A "*" segment matches every key and keeps
+// it in the metric name, a "{dim}" segment matches every key and moves it into
+// the dimension dim instead.
+type jsonSelector struct {
+	path       []string
+	name       string
+	metricType string
+}
+
+func init() {
+	RegisterCollector("HTTPJSON", newHTTPJSON)
+}
+
+// newHTTPJSON creates a new HTTPJSON collector.
+func newHTTPJSON(channel chan metric.Metric, initialInterval int, log *l.Entry) Collector {
+	h := new(HTTPJSON)
+
+	h.log = log
+	h.channel = channel
+	h.interval = initialInterval
+
+	h.name = "HTTPJSON"
+	h.urls = []string{}
+	h.headers = map[string]string{}
+	h.timeout = DefaultHTTPJSONTimeout
+	return h
+}
+
+// Configure takes a dictionary of values with which the collector can configure itself.
+func (h *HTTPJSON) Configure(configMap map[string]interface{}) {
+	if urls, exists := configMap["urls"]; exists {
+		h.urls = config.GetAsSlice(urls)
+	}
+	if headers, exists := configMap["headers"]; exists {
+		h.headers = config.GetAsMap(headers)
+	}
+	if timeout, exists := configMap["timeout"]; exists {
+		h.timeout = config.GetAsInt(timeout, DefaultHTTPJSONTimeout)
+	}
+	if selectors, exists := configMap["metrics"]; exists {
+		h.selectors = parseJSONSelectors(selectors)
+	}
+	h.configureCommonParams(configMap)
+
+	// one keep-alive connection per URL, HTTPAlive is not safe for concurrent use
+	h.clients = make(map[string]*util.HTTPAlive, len(h.urls))
+	for _, url := range h.urls {
+		client := new(util.HTTPAlive)
+		client.Configure(time.Duration(h.timeout)*time.Second, time.Duration(h.interval)*time.Second, 1)
+		h.clients[url] = client
+	}
+}
+
+// parseJSONSelectors reads a list of {"path": ..., "name": ..., "type": ...} maps.
+func parseJSONSelectors(value interface{}) []jsonSelector {
+	selectors := []jsonSelector{}
+	list, ok := value.([]interface{})
+	if !ok {
+		defaultLog.Warn("Expected a list of metric selectors but got ", value)
+		return selectors
+	}
+	for _, item := range list {
+		spec := config.GetAsMap(item)
+		selector := jsonSelector{
+			name:       spec["name"],
+			metricType: metric.Gauge,
+		}
+		if spec["path"] != "" {
+			selector.path = strings.Split(spec["path"], ".")
+		}
+		if spec["type"] != "" {
+			selector.metricType = spec["type"]
+		}
+		selectors = append(selectors, selector)
+	}
+	return selectors
+}
+
+// Collect fetches every URL in parallel.
+func (h *HTTPJSON) Collect() {
+	var wg sync.WaitGroup
+	for _, url := range h.urls {
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			for _, m := range h.collectURL(url) {
+				h.Channel() <- m
+			}
+		}(url)
+	}
+	wg.Wait()
+}
+
+// collectURL fetches a single URL. Latency and status code are always reported,
+// a status code of 0 means the request failed.
+func (h *HTTPJSON) collectURL(url string) []metric.Metric {
+	client := h.clients[url]
+	client.SetHeader(h.headers)
+
+	start := time.Now()
+	rsp, err := client.MakeRequest("GET", url, nil)
+	latency := time.Since(start)
+
+	metrics := []metric.Metric{}
+	status := 0
+	if err != nil {
+		h.log.Error("Failed to GET ", url, ": ", err)
+	} else {
+		status = rsp.StatusCode
+		if metrics, err = h.parseDocument(rsp.Body); err != nil {
+			h.log.Error("Failed to parse JSON from ", url, ": ", err)
+		}
+	}
+
+	metrics = append(metrics,
+		metric.WithValue("HTTPJSONResponseTime", latency.Seconds()*1000),
+		metric.WithValue("HTTPJSONStatusCode", float64(status)),
+	)
+	metric.AddToAll(&metrics, map[string]string{"url": url})
+	return metrics
+}
+
+// parseDocument applies the configured selectors to a JSON document.
+func (h *HTTPJSON) parseDocument(body []byte) ([]metric.Metric, error) {
+	return parseJSONDocument(body, h.selectors)
+}
+
+// parseJSONDocument applies selectors to a JSON document, without selectors
+// every numeric leaf becomes a gauge.
+func parseJSONDocument(body []byte, selectors []jsonSelector) ([]metric.Metric, error) {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+
+	if len(selectors) == 0 {
+		selectors = []jsonSelector{{metricType: metric.Gauge}}
+	}
+
+	metrics := []metric.Metric{}
+	now := time.Now()
+	for _, selector := range selectors {
+		selector := selector
+		prefix := []string{}
+		if selector.name != "" {
+			prefix = []string{selector.name}
+		}
+		walkJSON(doc, selector.path, prefix, selector.name != "", map[string]string{},
+			func(nameParts []string, dims map[string]string, value float64) {
+				m := metric.New(strings.Join(nameParts, "."))
+				m.MetricType = selector.metricType
+				m.Value = value
+				m.AddDimensions(dims)
+				m.SetTime(now)
+				metrics = append(metrics, m)
+			})
+	}
+	return metrics, nil
+}
+
+// walkJSON follows path through node and calls emit for every numeric leaf below it.
+// Literal segments are only added to the name if the selector has no name of its own.
+func walkJSON(node interface{}, path []string, nameParts []string, named bool,
+	dims map[string]string, emit func([]string, map[string]string, float64)) {
+	if len(path) == 0 {
+		if value, ok := node.(float64); ok {
+			if len(nameParts) > 0 {
+				emit(nameParts, dims, value)
+			}
+			return
+		}
+		children := jsonChildren(node)
+		for _, key := range sortedJSONKeys(children) {
+			walkJSON(children[key], nil, appendName(nameParts, key), named, dims, emit)
+		}
+		return
+	}
+
+	segment := path[0]
+	children := jsonChildren(node)
+	switch {
+	case segment == "*":
+		for _, key := range sortedJSONKeys(children) {
+			walkJSON(children[key], path[1:], appendName(nameParts, key), named, dims, emit)
+		}
+	case strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}"):
+		dim := segment[1 : len(segment)-1]
+		for _, key := range sortedJSONKeys(children) {
+			childDims := make(map[string]string, len(dims)+1)
+			for k, v := range dims {
+				childDims[k] = v
+			}
+			childDims[dim] = key
+			walkJSON(children[key], path[1:], nameParts, named, childDims, emit)
+		}
+	default:
+		if child, ok := children[segment]; ok {
+			if !named {
+				nameParts = appendName(nameParts, segment)
+			}
+			walkJSON(child, path[1:], nameParts, named, dims, emit)
+		}
+	}
+}
+
+func appendName(nameParts []string, key string) []string {
+	name := make([]string, len(nameParts), len(nameParts)+1)
+	copy(name, nameParts)
+	return append(name, key)
+}
+
+// jsonChildren returns the members of an object or the elements of an array keyed by index.
+func jsonChildren(node interface{}) map[string]interface{} {
+	switch value := node.(type) {
+	case map[string]interface{}:
+		return value
+	case []interface{}:
+		children := make(map[string]interface{}, len(value))
+		for i, child := range value {
+			children[strconv.Itoa(i)] = child
+		}
+		return children
+	}
+	return map[string]interface{}{}
+}
+
+func sortedJSONKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,150 @@
+package collector
+
+import (
+	"fullerite/metric"
+	"test_utils"
+
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testHTTPJSONDocument = `{
+	"uptime": 1234,
+	"version": "1.2.3",
+	"healthy": true,
+	"requests": {
+		"/users": {"count": 10, "errors": 1},
+		"/orders": {"count": 20, "errors": 0}
+	},
+	"pools": {
+		"db": {"active": 3, "idle": 7}
+	},
+	"workers": [{"busy": 1}, {"busy": 0}]
+}`
+
+func getTestHTTPJSON(config map[string]interface{}) *HTTPJSON {
+	h := newHTTPJSON(make(chan metric.Metric), 10, test_utils.BuildLogger()).(*HTTPJSON)
+	h.Configure(config)
+	return h
+}
+
+func selectorConfig(raw string) map[string]interface{} {
+	var config map[string]interface{}
+	json.Unmarshal([]byte(raw), &config)
+	return config
+}
+
+func TestHTTPJSONConfigure(t *testing.T) {
+	config := selectorConfig(`{
+		"interval": 30,
+		"timeout": "2",
+		"urls": ["http://localhost:8080/status"],
+		"headers": {"Accept": "application/json"},
+		"metrics": [{"path": "requests.{endpoint}.count", "type": "cumcounter"}]
+	}`)
+	h := getTestHTTPJSON(config)
+
+	assert := assert.New(t)
+	assert.Equal(30, h.Interval())
+	assert.Equal(2, h.timeout)
+	assert.Equal([]string{"http://localhost:8080/status"}, h.urls)
+	assert.Equal("application/json", h.headers["Accept"])
+	require.Equal(t, 1, len(h.selectors))
+	assert.Equal([]string{"requests", "{endpoint}", "count"}, h.selectors[0].path)
+	assert.Equal(metric.CumulativeCounter, h.selectors[0].metricType)
+	assert.NotNil(h.clients["http://localhost:8080/status"])
+}
+
+func TestHTTPJSONParseDocumentAllLeaves(t *testing.T) {
+	h := getTestHTTPJSON(map[string]interface{}{})
+	metrics, err := h.parseDocument([]byte(testHTTPJSONDocument))
+	require.Nil(t, err)
+
+	assert.Equal(t, 9, len(metrics), "strings and booleans should be skipped")
+	m, found := findMetric(metrics, "requests./users.errors", nil)
+	require.True(t, found)
+	assert.Equal(t, 1.0, m.Value)
+	assert.Equal(t, metric.Gauge, m.MetricType)
+	_, found = findMetric(metrics, "workers.1.busy", nil)
+	assert.True(t, found, "arrays should be indexed")
+}
+
+func TestHTTPJSONParseDocumentSelectors(t *testing.T) {
+	h := getTestHTTPJSON(selectorConfig(`{
+		"metrics": [
+			{"path": "requests.{endpoint}.count", "type": "cumcounter"},
+			{"path": "pools.*", "name": "pool"},
+			{"path": "uptime"},
+			{"path": "does.not.exist"}
+		]
+	}`))
+	metrics, err := h.parseDocument([]byte(testHTTPJSONDocument))
+	require.Nil(t, err)
+	assert := assert.New(t)
+
+	assert.Equal(5, len(metrics))
+
+	m, found := findMetric(metrics, "requests.count", map[string]string{"endpoint": "/orders"})
+	require.True(t, found)
+	assert.Equal(20.0, m.Value)
+	assert.Equal(metric.CumulativeCounter, m.MetricType)
+
+	m, found = findMetric(metrics, "pool.db.idle", nil)
+	require.True(t, found)
+	assert.Equal(7.0, m.Value)
+
+	m, found = findMetric(metrics, "uptime", nil)
+	require.True(t, found)
+	assert.Equal(1234.0, m.Value)
+}
+
+func TestHTTPJSONParseDocumentInvalid(t *testing.T) {
+	h := getTestHTTPJSON(map[string]interface{}{})
+	_, err := h.parseDocument([]byte("not json"))
+	assert.NotNil(t, err)
+}
+
+func TestHTTPJSONCollect(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Accept"))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, `{"uptime": 1}`)
+	}))
+	defer ts.Close()
+
+	config := selectorConfig(`{"headers": {"Accept": "application/json"}}`)
+	config["urls"] = []string{ts.URL, "http://127.0.0.1:0/unreachable"}
+	h := getTestHTTPJSON(config)
+
+	go func() {
+		h.Collect()
+		close(h.channel)
+	}()
+	metrics := []metric.Metric{}
+	for m := range h.Channel() {
+		metrics = append(metrics, m)
+	}
+
+	assert := assert.New(t)
+	assert.Equal(5, len(metrics))
+
+	url := map[string]string{"url": metric.New(ts.URL).Name}
+	m, found := findMetric(metrics, "HTTPJSONStatusCode", url)
+	require.True(t, found)
+	assert.Equal(float64(http.StatusServiceUnavailable), m.Value)
+	_, found = findMetric(metrics, "HTTPJSONResponseTime", url)
+	assert.True(found)
+	_, found = findMetric(metrics, "uptime", url)
+	assert.True(found)
+
+	unreachable := map[string]string{"url": metric.New("http://127.0.0.1:0/unreachable").Name}
+	m, found = findMetric(metrics, "HTTPJSONStatusCode", unreachable)
+	require.True(t, found)
+	assert.Equal(0.0, m.Value)
+}
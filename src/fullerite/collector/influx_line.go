@@ -0,0 +1,417 @@
+package collector
+
+import (
+	"fullerite/metric"
+
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	l "github.com/Sirupsen/logrus"
+)
+
+const (
+	// DefaultInfluxLineHTTPPort is the port the /write endpoint listens on
+	DefaultInfluxLineHTTPPort = "8186"
+	// DefaultInfluxLineUDPPort is the UDP port line protocol packets are read from
+	DefaultInfluxLineUDPPort = "8089"
+	// DefaultInfluxLinePrecision is used when a client does not send a precision
+	DefaultInfluxLinePrecision = "ns"
+
+	influxLineMaxPacketSize = 65536
+)
+
+// InfluxLine collector type.
+// It accepts the InfluxDB line protocol over HTTP (/write) and UDP and
+// converts every numeric field into a metric named measurement.field
+// (or just measurement if the field is called "value"). Tags become dimensions.
+type InfluxLine struct {
+	baseCollector
+	port          string
+	udpPort       string
+	udpPrecision  string
+	serverStarted bool
+	incoming      chan metric.Metric
+
+	// portMu guards the ports, the listeners replace them with the bound ports
+	portMu sync.Mutex
+}
+
+func init() {
+	RegisterCollector("InfluxLine", newInfluxLine)
+}
+
+// newInfluxLine creates a new InfluxLine collector.
+func newInfluxLine(channel chan metric.Metric, initialInterval int, log *l.Entry) Collector {
+	c := new(InfluxLine)
+
+	c.log = log
+	c.channel = channel
+	c.interval = initialInterval
+
+	c.name = "InfluxLine"
+	c.incoming = make(chan metric.Metric)
+	c.port = DefaultInfluxLineHTTPPort
+	c.udpPort = DefaultInfluxLineUDPPort
+	c.udpPrecision = DefaultInfluxLinePrecision
+	c.serverStarted = false
+	c.SetCollectorType("listener")
+	return c
+}
+
+// Configure the collector
+func (c *InfluxLine) Configure(configMap map[string]interface{}) {
+	if port, exists := configMap["port"]; exists {
+		c.port = fmt.Sprint(port)
+	}
+	if udpPort, exists := configMap["udpPort"]; exists {
+		c.udpPort = fmt.Sprint(udpPort)
+	}
+	if precision, exists := configMap["udpPrecision"]; exists {
+		asString, _ := precision.(string)
+		if _, ok := influxPrecisionMultiplier(asString); ok {
+			c.udpPrecision = asString
+		} else {
+			c.log.Warn("Invalid udpPrecision ", precision, ", using ", DefaultInfluxLinePrecision)
+			c.udpPrecision = DefaultInfluxLinePrecision
+		}
+	}
+	c.configureCommonParams(configMap)
+}
+
+// Port returns the HTTP listen port, an empty string means disabled
+func (c *InfluxLine) Port() string {
+	c.portMu.Lock()
+	defer c.portMu.Unlock()
+	return c.port
+}
+
+// UDPPort returns the UDP listen port, an empty string means disabled
+func (c *InfluxLine) UDPPort() string {
+	c.portMu.Lock()
+	defer c.portMu.Unlock()
+	return c.udpPort
+}
+
+// Collect starts the listeners on first invocation and publishes
+// the parsed metrics to the handlers.
+func (c *InfluxLine) Collect() {
+	if !c.serverStarted {
+		c.serverStarted = true
+		if c.port != "" {
+			go c.collectHTTP()
+		}
+		if c.udpPort != "" {
+			go c.collectUDP()
+		}
+	}
+
+	for m := range c.incoming {
+		c.Channel() <- m
+	}
+}
+
+// collectHTTP serves the InfluxDB compatible HTTP API.
+func (c *InfluxLine) collectHTTP() {
+	ln, err := net.Listen("tcp", ":"+c.port)
+	if err != nil {
+		c.log.Fatal("Cannot listen on InfluxLine HTTP socket", err)
+	}
+
+	// figure out the port bind for Port()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	c.portMu.Lock()
+	c.port = port
+	c.portMu.Unlock()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/write", c.serveWrite)
+	mux.HandleFunc("/query", c.serveQuery)
+	mux.HandleFunc("/ping", c.servePing)
+	if err := http.Serve(ln, mux); err != nil {
+		c.log.Error("InfluxLine HTTP server stopped: ", err)
+	}
+}
+
+// collectUDP reads line protocol packets from the UDP socket.
+func (c *InfluxLine) collectUDP() {
+	addr, err := net.ResolveUDPAddr("udp", ":"+c.udpPort)
+	if err != nil {
+		panic(err)
+	}
+
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		c.log.Fatal("Cannot listen on InfluxLine UDP socket", err)
+	}
+	defer conn.Close()
+
+	// figure out the port bind for UDPPort()
+	_, port, _ := net.SplitHostPort(conn.LocalAddr().String())
+	c.portMu.Lock()
+	c.udpPort = port
+	c.portMu.Unlock()
+
+	buf := make([]byte, influxLineMaxPacketSize)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			c.log.Warn("Error while reading InfluxLine UDP packet", err)
+			continue
+		}
+		metrics, errs := c.parseLines(buf[:n], c.udpPrecision)
+		for _, err := range errs {
+			c.log.Warn(err)
+		}
+		c.publish(metrics)
+	}
+}
+
+func (c *InfluxLine) publish(metrics []metric.Metric) {
+	for _, m := range metrics {
+		c.incoming <- m
+	}
+}
+
+// serveWrite handles the /write endpoint. Lines that parse are published
+// even if others in the same body are rejected, like InfluxDB does.
+func (c *InfluxLine) serveWrite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeInfluxError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	precision := r.URL.Query().Get("precision")
+	if precision == "" {
+		precision = DefaultInfluxLinePrecision
+	}
+	if _, ok := influxPrecisionMultiplier(precision); !ok {
+		writeInfluxError(w, http.StatusBadRequest, fmt.Sprintf("invalid precision %q", precision))
+		return
+	}
+
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			writeInfluxError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+	raw, err := ioutil.ReadAll(body)
+	if err != nil {
+		writeInfluxError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	metrics, errs := c.parseLines(raw, precision)
+	c.publish(metrics)
+
+	if len(errs) > 0 {
+		msgs := make([]string, len(errs))
+		for i, err := range errs {
+			msgs[i] = err.Error()
+		}
+		writeInfluxError(w, http.StatusBadRequest, "partial write: "+strings.Join(msgs, "; "))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveQuery only exists so that clients issuing CREATE DATABASE on
+// startup (e.g. Telegraf) do not fail.
+func (c *InfluxLine) serveQuery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, `{"results":[{}]}`)
+}
+
+func (c *InfluxLine) servePing(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeInfluxError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	b, _ := json.Marshal(map[string]string{"error": msg})
+	w.Write(b)
+}
+
+// parseLines converts a body of line protocol into metrics, collecting one error per bad line.
+func (c *InfluxLine) parseLines(raw []byte, precision string) ([]metric.Metric, []error) {
+	var (
+		metrics []metric.Metric
+		errs    []error
+	)
+	now := time.Now()
+	for _, line := range strings.Split(string(raw), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parsed, err := parseInfluxLine(line, precision, now)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		metrics = append(metrics, parsed...)
+	}
+	return metrics, errs
+}
+
+// parseInfluxLine parses a single "measurement[,tag=value...] field=value[,...] [timestamp]"
+// line and returns one gauge per numeric field.
+func parseInfluxLine(line string, precision string, now time.Time) ([]metric.Metric, error) {
+	sections := splitInfluxUnescaped(line, ' ', true)
+	if len(sections) < 2 || len(sections) > 3 {
+		return nil, fmt.Errorf("unable to parse '%s': expected measurement, fields and optional timestamp", line)
+	}
+
+	keyParts := splitInfluxUnescaped(sections[0], ',', false)
+	measurement := unescapeInflux(keyParts[0])
+	if measurement == "" {
+		return nil, fmt.Errorf("unable to parse '%s': missing measurement", line)
+	}
+	dims := map[string]string{}
+	for _, tag := range keyParts[1:] {
+		kv := splitInfluxUnescaped(tag, '=', false)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("unable to parse '%s': invalid tag '%s'", line, tag)
+		}
+		dims[unescapeInflux(kv[0])] = unescapeInflux(kv[1])
+	}
+
+	tm := now
+	if len(sections) == 3 {
+		ts, err := strconv.ParseInt(sections[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse '%s': bad timestamp '%s'", line, sections[2])
+		}
+		multiplier, ok := influxPrecisionMultiplier(precision)
+		if !ok {
+			return nil, fmt.Errorf("invalid precision %q", precision)
+		}
+		tm = time.Unix(0, ts*multiplier)
+	}
+
+	var metrics []metric.Metric
+	for _, field := range splitInfluxUnescaped(sections[1], ',', true) {
+		kv := splitInfluxUnescaped(field, '=', true)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("unable to parse '%s': invalid field '%s'", line, field)
+		}
+		value, numeric, err := parseInfluxFieldValue(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse '%s': %s", line, err)
+		}
+		if !numeric {
+			continue
+		}
+		name := measurement
+		if fieldName := unescapeInflux(kv[0]); fieldName != "value" {
+			name = measurement + "." + fieldName
+		}
+		m := metric.New(name)
+		m.Value = value
+		m.AddDimensions(dims)
+		m.SetTime(tm)
+		metrics = append(metrics, m)
+	}
+	return metrics, nil
+}
+
+// parseInfluxFieldValue returns the value of a field and whether it is numeric.
+// Strings and booleans are valid but not numeric.
+func parseInfluxFieldValue(raw string) (float64, bool, error) {
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		if len(raw) < 2 || !strings.HasSuffix(raw, `"`) {
+			return 0, false, fmt.Errorf("unterminated string value %s", raw)
+		}
+		return 0, false, nil
+	case raw == "t" || raw == "T" || raw == "true" || raw == "True" || raw == "TRUE",
+		raw == "f" || raw == "F" || raw == "false" || raw == "False" || raw == "FALSE":
+		return 0, false, nil
+	case strings.HasSuffix(raw, "i"):
+		v, err := strconv.ParseInt(strings.TrimSuffix(raw, "i"), 10, 64)
+		return float64(v), err == nil, err
+	case strings.HasSuffix(raw, "u"):
+		v, err := strconv.ParseUint(strings.TrimSuffix(raw, "u"), 10, 64)
+		return float64(v), err == nil, err
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	return v, err == nil, err
+}
+
+// influxPrecisionMultiplier returns the number of nanoseconds per unit of precision.
+func influxPrecisionMultiplier(precision string) (int64, bool) {
+	switch precision {
+	case "n", "ns":
+		return 1, true
+	case "u", "us", "µ", "µs":
+		return int64(time.Microsecond), true
+	case "ms":
+		return int64(time.Millisecond), true
+	case "s":
+		return int64(time.Second), true
+	case "m":
+		return int64(time.Minute), true
+	case "h":
+		return int64(time.Hour), true
+	}
+	return 0, false
+}
+
+// splitInfluxUnescaped splits s on sep ignoring backslash escaped separators
+// and, if quotes is set, separators within double quoted strings.
+func splitInfluxUnescaped(s string, sep byte, quotes bool) []string {
+	var (
+		parts   []string
+		start   int
+		quoted  bool
+		escaped bool
+	)
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case s[i] == '\\':
+			escaped = true
+		case quotes && s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func unescapeInflux(s string) string {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s
+	}
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case ',', ' ', '=', '"', '\\':
+				i++
+			}
+		}
+		buf.WriteByte(s[i])
+	}
+	return buf.String()
+}
@@ -0,0 +1,210 @@
+package collector
+
+import (
+	"fullerite/metric"
+	"test_utils"
+
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxLineConfigureEmptyConfig(t *testing.T) {
+	config := make(map[string]interface{})
+
+	c := newInfluxLine(nil, 12, nil).(*InfluxLine)
+	c.Configure(config)
+
+	assert := assert.New(t)
+	assert.Equal(12, c.Interval(), "should be the default collection interval")
+	assert.Equal(DefaultInfluxLineHTTPPort, c.Port())
+	assert.Equal(DefaultInfluxLineUDPPort, c.UDPPort())
+	assert.Equal("listener", c.CollectorType())
+}
+
+func TestInfluxLineConfigure(t *testing.T) {
+	config := make(map[string]interface{})
+	config["interval"] = 9999
+	config["port"] = 8086
+	config["udpPort"] = ""
+	config["udpPrecision"] = "s"
+
+	c := newInfluxLine(nil, 12, nil).(*InfluxLine)
+	c.Configure(config)
+
+	assert := assert.New(t)
+	assert.Equal(9999, c.Interval())
+	assert.Equal("8086", c.Port())
+	assert.Equal("", c.UDPPort())
+	assert.Equal("s", c.udpPrecision)
+}
+
+func TestInfluxLineConfigureInvalidPrecision(t *testing.T) {
+	c := newInfluxLine(nil, 12, test_utils.BuildLogger()).(*InfluxLine)
+
+	c.Configure(map[string]interface{}{"udpPrecision": "sec"})
+	assert.Equal(t, DefaultInfluxLinePrecision, c.udpPrecision)
+
+	c.Configure(map[string]interface{}{"udpPrecision": 1.0})
+	assert.Equal(t, DefaultInfluxLinePrecision, c.udpPrecision, "a non string precision does not panic")
+
+	c.Configure(map[string]interface{}{"udpPrecision": "u"})
+	assert.Equal(t, "u", c.udpPrecision)
+}
+
+func TestParseInfluxLine(t *testing.T) {
+	now := time.Now()
+	line := `cpu,host=server\ 01,region=us-west usage_idle=99.5,usage_user=1i,ok=true,desc="a b" 1465839830`
+
+	metrics, err := parseInfluxLine(line, "s", now)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(metrics), "only numeric fields become metrics")
+
+	byName := map[string]metric.Metric{}
+	for _, m := range metrics {
+		byName[m.Name] = m
+	}
+	dims := map[string]string{"host": "server 01", "region": "us-west"}
+
+	assert := assert.New(t)
+	assert.Equal(99.5, byName["cpu.usage_idle"].Value)
+	assert.Equal(1.0, byName["cpu.usage_user"].Value)
+	assert.Equal(dims, byName["cpu.usage_idle"].Dimensions)
+	assert.Equal(metric.Gauge, byName["cpu.usage_idle"].MetricType)
+	assert.Equal(time.Unix(1465839830, 0), byName["cpu.usage_user"].Time)
+}
+
+func TestParseInfluxLineValueField(t *testing.T) {
+	now := time.Now()
+	metrics, err := parseInfluxLine("load value=-0.25", "ns", now)
+
+	require.Nil(t, err)
+	require.Equal(t, 1, len(metrics))
+	assert.Equal(t, "load", metrics[0].Name)
+	assert.Equal(t, -0.25, metrics[0].Value)
+	assert.Equal(t, now, metrics[0].GetTime(), "missing timestamp should default to now")
+}
+
+func TestParseInfluxLinePrecision(t *testing.T) {
+	tests := []struct {
+		precision string
+		raw       string
+		expected  time.Time
+	}{
+		{"ns", "1465839830100400200", time.Unix(0, 1465839830100400200)},
+		{"u", "1465839830100400", time.Unix(0, 1465839830100400000)},
+		{"ms", "1465839830100", time.Unix(0, 1465839830100000000)},
+		{"s", "1465839830", time.Unix(1465839830, 0)},
+		{"m", "24430663", time.Unix(24430663*60, 0)},
+		{"h", "407177", time.Unix(407177*3600, 0)},
+	}
+
+	for _, test := range tests {
+		metrics, err := parseInfluxLine("m value=1 "+test.raw, test.precision, time.Now())
+		require.Nil(t, err, test.precision)
+		assert.Equal(t, test.expected, metrics[0].GetTime(), test.precision)
+	}
+}
+
+func TestParseInfluxLineInvalid(t *testing.T) {
+	lines := []string{
+		"no_fields",
+		"m value=",
+		"m,tag value=1",
+		"m value=abc",
+		"m value=1 notatime",
+		`m value="unterminated`,
+		"m value=1 1 extra",
+	}
+
+	for _, line := range lines {
+		_, err := parseInfluxLine(line, "ns", time.Now())
+		assert.NotNil(t, err, line)
+	}
+}
+
+func TestInfluxLineServeWrite(t *testing.T) {
+	testChannel := make(chan metric.Metric)
+	c := newInfluxLine(testChannel, 12, test_utils.BuildLogger()).(*InfluxLine)
+	go c.Collect()
+
+	body := bytes.NewBufferString("mem,host=a used=10i 1465839830\n")
+	req, _ := http.NewRequest("POST", "/write?db=telegraf&precision=s", body)
+	rec := httptest.NewRecorder()
+
+	go c.serveWrite(rec, req)
+
+	select {
+	case m := <-c.Channel():
+		assert.Equal(t, "mem.used", m.Name)
+		assert.Equal(t, 10.0, m.Value)
+		assert.Equal(t, time.Unix(1465839830, 0), m.GetTime())
+	case <-time.After(1 * time.Second):
+		t.Fatal("no metric received")
+	}
+}
+
+func TestInfluxLineServeWriteErrors(t *testing.T) {
+	c := newInfluxLine(nil, 12, test_utils.BuildLogger()).(*InfluxLine)
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/write", nil)
+	c.serveWrite(rec, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/write?precision=fortnight", bytes.NewBufferString("m value=1"))
+	c.serveWrite(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/write", bytes.NewBufferString("garbage\n"))
+	c.serveWrite(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "partial write")
+}
+
+func TestInfluxLineCollectUDP(t *testing.T) {
+	config := map[string]interface{}{
+		"port":    "",
+		"udpPort": "0",
+	}
+	testChannel := make(chan metric.Metric)
+	c := newInfluxLine(testChannel, 12, test_utils.BuildLogger()).(*InfluxLine)
+	c.Configure(config)
+
+	go c.Collect()
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	for retry := 0; retry < 3; retry++ {
+		if c.UDPPort() != "0" {
+			if conn, err = net.Dial("udp", "localhost:"+c.UDPPort()); err == nil {
+				break
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.Nil(t, err, "should connect")
+	require.NotNil(t, conn, "should connect")
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "disk,path=/ free=42\n")
+
+	select {
+	case m := <-c.Channel():
+		assert.Equal(t, "disk.free", m.Name)
+		assert.Equal(t, map[string]string{"path": "/"}, m.Dimensions)
+	case <-time.After(1 * time.Second):
+		t.Fail()
+	}
+}
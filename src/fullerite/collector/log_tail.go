@@ -0,0 +1,417 @@
+package collector
+
+import (
+	"fullerite/config"
+	"fullerite/metric"
+
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	l "github.com/Sirupsen/logrus"
+)
+
+// LogTail collector type.
+// Every interval it reads the lines appended to files since the last
+// interval and applies patterns to them. files are glob patterns, so new
+// files are picked up. Offsets are written to offsetFile, if configured, so
+// lines are neither lost nor counted twice across restarts.
+type LogTail struct {
+	baseCollector
+	files         []string
+	patterns      []*logPattern
+	offsetFile    string
+	fromBeginning bool
+	tailed        map[string]*tailedFile
+}
+
+// logPattern counts the lines matching regex. Named groups listed in
+// dimensions become dimensions, named groups listed in values are parsed as
+// numbers and reported as a gauge (last value) or histogram.
+type logPattern struct {
+	name       string
+	regex      *regexp.Regexp
+	dimensions []string
+	values     map[string]string
+}
+
+// tailedFile is a file being followed. inode detects rotation.
+type tailedFile struct {
+	path   string
+	file   *os.File
+	offset int64
+	inode  uint64
+}
+
+// logTailOffset is what is persisted in the offset file per path
+type logTailOffset struct {
+	Offset int64  `json:"offset"`
+	Inode  uint64 `json:"inode"`
+}
+
+// logAggregate collects the matches of a pattern with the same dimensions
+type logAggregate struct {
+	count      float64
+	dimensions map[string]string
+	samples    map[string][]float64
+}
+
+func init() {
+	RegisterCollector("LogTail", newLogTail)
+}
+
+// newLogTail creates a new LogTail collector.
+func newLogTail(channel chan metric.Metric, initialInterval int, log *l.Entry) Collector {
+	t := new(LogTail)
+
+	t.log = log
+	t.channel = channel
+	t.interval = initialInterval
+
+	t.name = "LogTail"
+	t.files = []string{}
+	t.patterns = []*logPattern{}
+	t.tailed = make(map[string]*tailedFile)
+	return t
+}
+
+// Configure takes a dictionary of values with which the collector can configure itself.
+func (t *LogTail) Configure(configMap map[string]interface{}) {
+	if files, exists := configMap["files"]; exists {
+		t.files = config.GetAsSlice(files)
+	}
+	if offsetFile, exists := configMap["offsetFile"]; exists {
+		t.offsetFile = offsetFile.(string)
+	}
+	if fromBeginning, exists := configMap["fromBeginning"]; exists {
+		t.fromBeginning, _ = fromBeginning.(bool)
+	}
+	if patterns, exists := configMap["patterns"]; exists {
+		t.patterns = parseLogPatterns(patterns)
+	}
+	t.configureCommonParams(configMap)
+	t.loadOffsets()
+}
+
+// parseLogPatterns reads a list of {"name", "regex", "dimensions", "values"} maps.
+func parseLogPatterns(value interface{}) []*logPattern {
+	patterns := []*logPattern{}
+	list, ok := value.([]interface{})
+	if !ok {
+		defaultLog.Warn("Expected a list of log patterns but got ", value)
+		return patterns
+	}
+	for _, item := range list {
+		spec, ok := item.(map[string]interface{})
+		if !ok {
+			defaultLog.Warn("Expected a log pattern but got ", item)
+			continue
+		}
+		name, _ := spec["name"].(string)
+		raw, _ := spec["regex"].(string)
+		re, err := regexp.Compile(raw)
+		if name == "" || err != nil {
+			defaultLog.Warn("Skipping invalid log pattern ", name, ": ", err)
+			continue
+		}
+		pattern := &logPattern{name: name, regex: re, values: map[string]string{}}
+		if dims, exists := spec["dimensions"]; exists {
+			pattern.dimensions = config.GetAsSlice(dims)
+		}
+		if values, exists := spec["values"]; exists {
+			for group, kind := range config.GetAsMap(values) {
+				if kind != "gauge" && kind != "histogram" {
+					defaultLog.Warn("Unknown value type ", kind, " for ", group, ", using gauge")
+					kind = "gauge"
+				}
+				pattern.values[group] = kind
+			}
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
+// Collect reads the new lines of every file and reports the matches.
+func (t *LogTail) Collect() {
+	aggregates := make(map[*logPattern]map[string]*logAggregate)
+	for _, pattern := range t.patterns {
+		aggregates[pattern] = make(map[string]*logAggregate)
+	}
+
+	current := make(map[string]bool)
+	for _, path := range t.matchingFiles() {
+		current[path] = true
+		tailed, exists := t.tailed[path]
+		if !exists {
+			tailed = &tailedFile{path: path}
+			if !t.fromBeginning {
+				tailed.offset = -1
+			}
+			t.tailed[path] = tailed
+		}
+		err := tailed.readLines(func(line string) {
+			t.matchLine(line, aggregates)
+		})
+		if err != nil {
+			t.log.Warn("Failed to read ", path, ": ", err)
+		}
+	}
+	for path, tailed := range t.tailed {
+		if current[path] {
+			continue
+		}
+		if tailed.file == nil {
+			delete(t.tailed, path)
+			continue
+		}
+		// rotated away: finish the old file and keep the path for one more
+		// interval so the recreated file is read from the start
+		tailed.readLines(func(line string) {
+			t.matchLine(line, aggregates)
+		})
+		tailed.offset = 0
+	}
+	t.saveOffsets()
+
+	for _, pattern := range t.patterns {
+		for _, m := range pattern.buildMetrics(aggregates[pattern]) {
+			t.Channel() <- m
+		}
+	}
+}
+
+func (t *LogTail) matchingFiles() []string {
+	paths := []string{}
+	for _, glob := range t.files {
+		matches, err := filepath.Glob(glob)
+		if err != nil {
+			t.log.Warn("Invalid file pattern ", glob, ": ", err)
+			continue
+		}
+		paths = append(paths, matches...)
+	}
+	return paths
+}
+
+func (t *LogTail) matchLine(line string, aggregates map[*logPattern]map[string]*logAggregate) {
+	for _, pattern := range t.patterns {
+		match := pattern.regex.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		groups := make(map[string]string)
+		for i, name := range pattern.regex.SubexpNames() {
+			if name != "" {
+				groups[name] = match[i]
+			}
+		}
+
+		dims := make(map[string]string, len(pattern.dimensions))
+		keys := make([]string, 0, len(pattern.dimensions))
+		for _, dim := range pattern.dimensions {
+			if value := groups[dim]; value != "" {
+				dims[dim] = value
+				keys = append(keys, dim+"="+value)
+			}
+		}
+		key := strings.Join(keys, ",")
+
+		aggregate, exists := aggregates[pattern][key]
+		if !exists {
+			aggregate = &logAggregate{dimensions: dims, samples: make(map[string][]float64)}
+			aggregates[pattern][key] = aggregate
+		}
+		aggregate.count++
+		for group := range pattern.values {
+			if value, err := strconv.ParseFloat(groups[group], 64); err == nil {
+				aggregate.samples[group] = append(aggregate.samples[group], value)
+			}
+		}
+	}
+}
+
+// buildMetrics reports a counter per dimension set. Gauges report the last
+// value, histograms count, min, max, mean and percentiles of the values.
+func (p *logPattern) buildMetrics(aggregates map[string]*logAggregate) []metric.Metric {
+	metrics := []metric.Metric{}
+	now := time.Now()
+	add := func(name, metricType string, value float64, dims map[string]string) {
+		m := metric.New(name)
+		m.MetricType = metricType
+		m.Value = value
+		m.AddDimensions(dims)
+		m.SetTime(now)
+		metrics = append(metrics, m)
+	}
+
+	for _, aggregate := range aggregates {
+		add(p.name, metric.Counter, aggregate.count, aggregate.dimensions)
+		for group, kind := range p.values {
+			samples := aggregate.samples[group]
+			if len(samples) == 0 {
+				continue
+			}
+			name := p.name + "." + group
+			if kind == "gauge" {
+				add(name, metric.Gauge, samples[len(samples)-1], aggregate.dimensions)
+				continue
+			}
+			sort.Float64s(samples)
+			sum := 0.0
+			for _, sample := range samples {
+				sum += sample
+			}
+			add(name+".count", metric.Counter, float64(len(samples)), aggregate.dimensions)
+			add(name+".min", metric.Gauge, samples[0], aggregate.dimensions)
+			add(name+".max", metric.Gauge, samples[len(samples)-1], aggregate.dimensions)
+			add(name+".mean", metric.Gauge, sum/float64(len(samples)), aggregate.dimensions)
+			for _, percentile := range []float64{50, 95, 99} {
+				add(name+".p"+strconv.Itoa(int(percentile)), metric.Gauge,
+					logPercentile(samples, percentile), aggregate.dimensions)
+			}
+		}
+	}
+	return metrics
+}
+
+// logPercentile uses the nearest rank method on sorted samples.
+func logPercentile(sorted []float64, percentile float64) float64 {
+	rank := int(math.Ceil(percentile / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// readLines calls handle for every complete line appended since the last
+// call. A rotated file is read to the end before switching to the new file,
+// also while the new file does not exist yet, a truncated file is read from
+// the start. An offset of -1 starts at the end.
+func (f *tailedFile) readLines(handle func(string)) error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		if f.file != nil {
+			// rotated but not recreated yet: finish the old file first
+			f.readFrom(handle)
+		}
+		f.close()
+		return err
+	}
+	inode := fileInode(info)
+
+	if f.file != nil && inode != f.inode {
+		// rotated: finish the old file first
+		f.readFrom(handle)
+		f.close()
+		f.offset = 0
+	}
+	if f.file == nil {
+		if f.inode != 0 && inode != f.inode {
+			f.offset = 0
+		}
+		if f.file, err = os.Open(f.path); err != nil {
+			return err
+		}
+		f.inode = inode
+	}
+	if f.offset < 0 {
+		f.offset = info.Size()
+	}
+	if info.Size() < f.offset {
+		// truncated
+		f.offset = 0
+	}
+	return f.readFrom(handle)
+}
+
+func (f *tailedFile) readFrom(handle func(string)) error {
+	if _, err := f.file.Seek(f.offset, os.SEEK_SET); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(f.file)
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			// an incomplete line is read again once it is complete
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		f.offset += int64(len(line))
+		handle(strings.TrimRight(line, "\r\n"))
+	}
+}
+
+func (f *tailedFile) close() {
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+}
+
+func fileInode(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino)
+	}
+	return 0
+}
+
+// loadOffsets restores the offsets saved by a previous run.
+func (t *LogTail) loadOffsets() {
+	if t.offsetFile == "" {
+		return
+	}
+	raw, err := ioutil.ReadFile(t.offsetFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			defaultLog.Warn("Failed to read log offsets from ", t.offsetFile, ": ", err)
+		}
+		return
+	}
+	offsets := map[string]logTailOffset{}
+	if err := json.Unmarshal(raw, &offsets); err != nil {
+		defaultLog.Warn("Failed to parse log offsets from ", t.offsetFile, ": ", err)
+		return
+	}
+	for path, offset := range offsets {
+		t.tailed[path] = &tailedFile{path: path, offset: offset.Offset, inode: offset.Inode}
+	}
+}
+
+// saveOffsets atomically replaces the offset file.
+func (t *LogTail) saveOffsets() {
+	if t.offsetFile == "" {
+		return
+	}
+	offsets := make(map[string]logTailOffset, len(t.tailed))
+	for path, tailed := range t.tailed {
+		if tailed.offset >= 0 {
+			offsets[path] = logTailOffset{Offset: tailed.offset, Inode: tailed.inode}
+		}
+	}
+	raw, err := json.Marshal(offsets)
+	if err != nil {
+		t.log.Error("Failed to encode log offsets: ", err)
+		return
+	}
+	tmp := t.offsetFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, raw, 0644); err != nil {
+		t.log.Error("Failed to write log offsets to ", tmp, ": ", err)
+		return
+	}
+	if err := os.Rename(tmp, t.offsetFile); err != nil {
+		t.log.Error("Failed to write log offsets to ", t.offsetFile, ": ", err)
+	}
+}
@@ -0,0 +1,186 @@
+package collector
+
+import (
+	"fullerite/metric"
+	"test_utils"
+
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testNginxRegex = `^(?P<vhost>\S+) "\S+ \S+ \S+" (?P<status>\d{3}) (?P<request_time>[\d.]+)$`
+
+func getTestLogTail(t *testing.T, dir string, extra map[string]interface{}) *LogTail {
+	config := map[string]interface{}{
+		"files": []interface{}{filepath.Join(dir, "*.log")},
+		"patterns": []interface{}{
+			map[string]interface{}{
+				"name":       "nginx.requests",
+				"regex":      testNginxRegex,
+				"dimensions": []interface{}{"vhost", "status"},
+				"values":     map[string]interface{}{"request_time": "histogram"},
+			},
+		},
+	}
+	for k, v := range extra {
+		config[k] = v
+	}
+	c := newLogTail(nil, 10, test_utils.BuildLogger()).(*LogTail)
+	c.Configure(config)
+	return c
+}
+
+func collectLogTail(c *LogTail) []metric.Metric {
+	c.channel = make(chan metric.Metric)
+	go func() {
+		c.Collect()
+		close(c.channel)
+	}()
+	metrics := []metric.Metric{}
+	for m := range c.Channel() {
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+func appendLog(t *testing.T, path string, lines string) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	require.Nil(t, err)
+	defer f.Close()
+	_, err = f.WriteString(lines)
+	require.Nil(t, err)
+}
+
+func requestCount(t *testing.T, metrics []metric.Metric, dims map[string]string) float64 {
+	m, found := findMetric(metrics, "nginx.requests", dims)
+	if !found {
+		return 0
+	}
+	assert.Equal(t, metric.Counter, m.MetricType)
+	return m.Value
+}
+
+func TestLogTailConfigure(t *testing.T) {
+	c := getTestLogTail(t, "/var/log/nginx", map[string]interface{}{"fromBeginning": true})
+
+	assert := assert.New(t)
+	assert.Equal([]string{"/var/log/nginx/*.log"}, c.files)
+	assert.True(c.fromBeginning)
+	require.Equal(t, 1, len(c.patterns))
+	assert.Equal("nginx.requests", c.patterns[0].name)
+	assert.Equal([]string{"vhost", "status"}, c.patterns[0].dimensions)
+	assert.Equal(map[string]string{"request_time": "histogram"}, c.patterns[0].values)
+}
+
+func TestLogTailInvalidPatterns(t *testing.T) {
+	patterns := parseLogPatterns([]interface{}{
+		map[string]interface{}{"name": "broken", "regex": "("},
+		map[string]interface{}{"regex": "no name"},
+		map[string]interface{}{"name": "ok", "regex": "ok", "values": map[string]interface{}{"x": "median"}},
+	})
+	require.Equal(t, 1, len(patterns))
+	assert.Equal(t, "gauge", patterns[0].values["x"])
+}
+
+func TestLogTailCollect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fullerite_logtail")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "access.log")
+	appendLog(t, path, "a.example.com \"GET / HTTP/1.1\" 200 0.100\n")
+
+	c := getTestLogTail(t, dir, nil)
+	assert.Empty(t, collectLogTail(c), "existing lines should be skipped")
+
+	appendLog(t, path, "a.example.com \"GET / HTTP/1.1\" 200 0.200\n"+
+		"a.example.com \"GET /x HTTP/1.1\" 200 0.400\n"+
+		"b.example.com \"GET / HTTP/1.1\" 502 1.000\n"+
+		"garbage\n"+
+		"b.example.com \"GET / HTTP/1.1\" 502 1.0")
+	metrics := collectLogTail(c)
+
+	assert := assert.New(t)
+	assert.Equal(2.0, requestCount(t, metrics, map[string]string{"vhost": "a.example.com", "status": "200"}))
+	assert.Equal(1.0, requestCount(t, metrics, map[string]string{"vhost": "b.example.com", "status": "502"}),
+		"the incomplete last line should not be counted yet")
+
+	dims := map[string]string{"vhost": "a.example.com"}
+	for name, value := range map[string]float64{
+		"nginx.requests.request_time.count": 2,
+		"nginx.requests.request_time.min":   0.2,
+		"nginx.requests.request_time.max":   0.4,
+		"nginx.requests.request_time.p50":   0.2,
+		"nginx.requests.request_time.p99":   0.4,
+	} {
+		m, found := findMetric(metrics, name, dims)
+		if assert.True(found, name) {
+			assert.InDelta(value, m.Value, 1e-9, name)
+		}
+	}
+
+	appendLog(t, path, "00\n")
+	metrics = collectLogTail(c)
+	assert.Equal(1.0, requestCount(t, metrics, map[string]string{"vhost": "b.example.com", "status": "502"}))
+	m, found := findMetric(metrics, "nginx.requests.request_time.max", nil)
+	require.True(t, found)
+	assert.Equal(1.0, m.Value)
+}
+
+func TestLogTailRotationAndTruncation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fullerite_logtail")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "access.log")
+	appendLog(t, path, "")
+
+	c := getTestLogTail(t, dir, nil)
+	collectLogTail(c)
+
+	line := "a.example.com \"GET / HTTP/1.1\" 200 0.1\n"
+	dims := map[string]string{"vhost": "a.example.com"}
+
+	// lines written to the old file before rotation are not lost
+	appendLog(t, path, line)
+	require.Nil(t, os.Rename(path, filepath.Join(dir, "access.log.1")))
+	appendLog(t, filepath.Join(dir, "access.log.1"), line)
+	appendLog(t, path, line)
+	assert.Equal(t, 3.0, requestCount(t, collectLogTail(c), dims))
+
+	// lines written before a rotation are also read while the new file is missing
+	appendLog(t, path, line)
+	require.Nil(t, os.Rename(path, filepath.Join(dir, "access.log.2")))
+	assert.Equal(t, 1.0, requestCount(t, collectLogTail(c), dims))
+	appendLog(t, path, line)
+	assert.Equal(t, 1.0, requestCount(t, collectLogTail(c), dims), "the new file should be read from the start")
+
+	appendLog(t, path, line+line)
+	assert.Equal(t, 2.0, requestCount(t, collectLogTail(c), dims))
+	require.Nil(t, os.Truncate(path, 0))
+	assert.Equal(t, 0.0, requestCount(t, collectLogTail(c), dims))
+	appendLog(t, path, line)
+	assert.Equal(t, 1.0, requestCount(t, collectLogTail(c), dims), "a truncated file should be read from the start")
+}
+
+func TestLogTailOffsetsAcrossRestarts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fullerite_logtail")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "access.log")
+	offsetFile := filepath.Join(dir, "offsets.json")
+	line := "a.example.com \"GET / HTTP/1.1\" 200 0.1\n"
+	dims := map[string]string{"vhost": "a.example.com"}
+
+	appendLog(t, path, line)
+	c := getTestLogTail(t, dir, map[string]interface{}{"offsetFile": offsetFile, "fromBeginning": true})
+	assert.Equal(t, 1.0, requestCount(t, collectLogTail(c), dims))
+
+	appendLog(t, path, line+line)
+	restarted := getTestLogTail(t, dir, map[string]interface{}{"offsetFile": offsetFile, "fromBeginning": true})
+	assert.Equal(t, 2.0, requestCount(t, collectLogTail(restarted), dims),
+		"only the lines written since the last offset should be counted")
+}
@@ -0,0 +1,54 @@
+package collector
+
+import (
+	"fullerite/metric"
+
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultMesosTimeout is the request timeout in seconds for the mesos endpoints
+const DefaultMesosTimeout = 5
+
+// getMesosJSON GETs url and decodes the JSON response into v.
+func getMesosJSON(client *http.Client, url string, v interface{}) error {
+	rsp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+
+	body, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return err
+	}
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d from %s", rsp.StatusCode, url)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// mesosSnapshotMetrics converts a /metrics/snapshot document into metrics.
+// "master/cpus_used" becomes "mesos.master.cpus_used". Keys matching one of
+// counterPrefixes are cumulative counters, everything else is a gauge.
+func mesosSnapshotMetrics(snapshot map[string]float64, counterPrefixes []string) []metric.Metric {
+	metrics := make([]metric.Metric, 0, len(snapshot))
+	now := time.Now()
+	for key, value := range snapshot {
+		m := metric.New("mesos." + strings.Replace(key, "/", ".", -1))
+		m.Value = value
+		m.SetTime(now)
+		for _, prefix := range counterPrefixes {
+			if strings.HasPrefix(key, prefix) {
+				m.MetricType = metric.CumulativeCounter
+				break
+			}
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
@@ -0,0 +1,180 @@
+package collector
+
+import (
+	"fullerite/config"
+	"fullerite/metric"
+	"fullerite/util"
+
+	"fmt"
+	"net/http"
+	"time"
+
+	l "github.com/Sirupsen/logrus"
+)
+
+const (
+	// DefaultMesosMasterPort is the port the mesos master listens on
+	DefaultMesosMasterPort = 5050
+
+	// DefaultMesosLeaderTTL is how long in seconds the elected leader is cached
+	DefaultMesosLeaderTTL = 60
+)
+
+// Dependency injection: Makes writing unit tests much easier, by being able to override these values in the *_test.go files.
+var (
+	newMesosLeaderElect = func() util.MesosLeaderElectInterface { return new(util.MesosLeaderElect) }
+	mesosExternalIP     = util.ExternalIP
+)
+
+// mesosMasterCounters are the snapshot keys that only ever go up
+var mesosMasterCounters = []string{
+	"master/messages_",
+	"master/valid_",
+	"master/invalid_",
+	"master/dropped_messages",
+	"master/slave_registrations",
+	"master/slave_reregistrations",
+	"master/slave_removals",
+	"master/tasks_error",
+	"master/tasks_failed",
+	"master/tasks_finished",
+	"master/tasks_killed",
+	"master/tasks_lost",
+}
+
+// MesosMaster collector type.
+// Every master runs the collector but only the elected leader reports, so
+// cluster-level metrics are not reported once per master.
+// mesosNodes is the comma separated list of masters, e.g. "http://1.2.3.4:5050/,http://5.6.7.8:5050/".
+type MesosMaster struct {
+	baseCollector
+	mesosNodes string
+	port       int
+	leaderTTL  int
+	timeout    int
+	client     *http.Client
+	leader     util.MesosLeaderElectInterface
+}
+
+type mesosMasterState struct {
+	Frameworks []mesosFramework `json:"frameworks"`
+}
+
+type mesosFramework struct {
+	ID            string                 `json:"id"`
+	Name          string                 `json:"name"`
+	Active        bool                   `json:"active"`
+	UsedResources map[string]interface{} `json:"used_resources"`
+	Tasks         []interface{}          `json:"tasks"`
+}
+
+func init() {
+	RegisterCollector("MesosMaster", newMesosMaster)
+}
+
+// newMesosMaster creates a new MesosMaster collector.
+func newMesosMaster(channel chan metric.Metric, initialInterval int, log *l.Entry) Collector {
+	m := new(MesosMaster)
+
+	m.log = log
+	m.channel = channel
+	m.interval = initialInterval
+
+	m.name = "MesosMaster"
+	m.port = DefaultMesosMasterPort
+	m.leaderTTL = DefaultMesosLeaderTTL
+	m.timeout = DefaultMesosTimeout
+	return m
+}
+
+// Configure takes a dictionary of values with which the collector can configure itself.
+func (m *MesosMaster) Configure(configMap map[string]interface{}) {
+	if nodes, exists := configMap["mesosNodes"]; exists {
+		m.mesosNodes = nodes.(string)
+	}
+	if port, exists := configMap["port"]; exists {
+		m.port = config.GetAsInt(port, DefaultMesosMasterPort)
+	}
+	if ttl, exists := configMap["leaderTTL"]; exists {
+		m.leaderTTL = config.GetAsInt(ttl, DefaultMesosLeaderTTL)
+	}
+	if timeout, exists := configMap["timeout"]; exists {
+		m.timeout = config.GetAsInt(timeout, DefaultMesosTimeout)
+	}
+	m.configureCommonParams(configMap)
+
+	m.client = &http.Client{Timeout: time.Duration(m.timeout) * time.Second}
+	if m.mesosNodes == "" {
+		defaultLog.Error("MesosMaster requires mesosNodes to find the leader")
+		return
+	}
+	m.leader = newMesosLeaderElect()
+	m.leader.Configure(m.mesosNodes, time.Duration(m.leaderTTL)*time.Second)
+}
+
+// Collect reports the cluster metrics if this host is the elected leader.
+func (m *MesosMaster) Collect() {
+	if m.leader == nil {
+		return
+	}
+	leader := m.leader.Get()
+	ip, err := mesosExternalIP()
+	if err != nil {
+		m.log.Error("Unable to determine the IP of this host: ", err)
+		return
+	}
+	if leader == "" || leader != ip {
+		m.log.Debug("Not the mesos leader (", leader, "), skipping collection")
+		return
+	}
+
+	baseURL := fmt.Sprintf("http://%s:%d", leader, m.port)
+	snapshot := map[string]float64{}
+	if err := getMesosJSON(m.client, baseURL+"/metrics/snapshot", &snapshot); err != nil {
+		m.log.Error("Failed to get the mesos master snapshot: ", err)
+	} else {
+		for _, stat := range mesosSnapshotMetrics(snapshot, mesosMasterCounters) {
+			m.Channel() <- stat
+		}
+	}
+
+	state := new(mesosMasterState)
+	if err := getMesosJSON(m.client, baseURL+"/master/state.json", state); err != nil {
+		m.log.Error("Failed to get the mesos master state: ", err)
+		return
+	}
+	for _, stat := range mesosFrameworkMetrics(state.Frameworks) {
+		m.Channel() <- stat
+	}
+}
+
+// mesosFrameworkMetrics reports the resources used and the number of running
+// tasks per framework.
+func mesosFrameworkMetrics(frameworks []mesosFramework) []metric.Metric {
+	metrics := []metric.Metric{}
+	active := 0.0
+	for _, framework := range frameworks {
+		if framework.Active {
+			active++
+		}
+		frameworkMetrics := []metric.Metric{
+			metric.WithValue("mesos.framework.tasks", float64(len(framework.Tasks))),
+		}
+		for resource, raw := range framework.UsedResources {
+			// ranges such as ports are not numeric
+			if value, ok := raw.(float64); ok {
+				frameworkMetrics = append(frameworkMetrics, metric.WithValue("mesos.framework."+resource+"_used", value))
+			}
+		}
+		metric.AddToAll(&frameworkMetrics, map[string]string{
+			"framework":    framework.Name,
+			"framework_id": framework.ID,
+		})
+		metrics = append(metrics, frameworkMetrics...)
+	}
+	metrics = append(metrics,
+		metric.WithValue("mesos.frameworks.total", float64(len(frameworks))),
+		metric.WithValue("mesos.frameworks.active", active),
+	)
+	return metrics
+}
@@ -0,0 +1,167 @@
+package collector
+
+import (
+	"fullerite/metric"
+	"fullerite/util"
+	"test_utils"
+
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testMesosMasterSnapshot = `{
+	"master/cpus_total": 24,
+	"master/cpus_used": 6.5,
+	"master/elected": 1,
+	"master/frameworks_active": 2,
+	"master/tasks_finished": 1337,
+	"master/messages_launch_tasks": 42
+}`
+
+const testMesosMasterState = `{
+	"frameworks": [
+		{
+			"id": "20150101-0000-0000-0000-000000000000-0000",
+			"name": "marathon",
+			"active": true,
+			"used_resources": {"cpus": 4.5, "mem": 2048, "disk": 0, "ports": "[31000-31002]"},
+			"tasks": [{"id": "a"}, {"id": "b"}, {"id": "c"}]
+		},
+		{
+			"id": "20150101-0000-0000-0000-000000000000-0001",
+			"name": "chronos",
+			"active": false,
+			"used_resources": {"cpus": 2, "mem": 512, "disk": 0},
+			"tasks": []
+		}
+	]
+}`
+
+type mockMesosLeaderElect struct {
+	util.MesosLeaderElect
+	nodes  string
+	leader string
+}
+
+func (m *mockMesosLeaderElect) Configure(nodes string, ttl time.Duration) {
+	m.nodes = nodes
+}
+
+func (m *mockMesosLeaderElect) Get() string {
+	return m.leader
+}
+
+func getTestMesosMaster(t *testing.T, leader string, ts *httptest.Server) (*MesosMaster, func()) {
+	oldNewMesosLeaderElect, oldExternalIP := newMesosLeaderElect, mesosExternalIP
+	newMesosLeaderElect = func() util.MesosLeaderElectInterface { return &mockMesosLeaderElect{leader: leader} }
+	mesosExternalIP = func() (string, error) { return "127.0.0.1", nil }
+
+	m := newMesosMaster(make(chan metric.Metric), 10, test_utils.BuildLogger()).(*MesosMaster)
+	m.Configure(map[string]interface{}{
+		"mesosNodes": "http://127.0.0.1:5050/",
+		"port":       serverPort(t, ts),
+	})
+	return m, func() {
+		newMesosLeaderElect, mesosExternalIP = oldNewMesosLeaderElect, oldExternalIP
+	}
+}
+
+func collectMesosMaster(m *MesosMaster) []metric.Metric {
+	go func() {
+		m.Collect()
+		close(m.channel)
+	}()
+	metrics := []metric.Metric{}
+	for stat := range m.Channel() {
+		metrics = append(metrics, stat)
+	}
+	return metrics
+}
+
+func testMesosMasterServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metrics/snapshot":
+			fmt.Fprint(w, testMesosMasterSnapshot)
+		case "/master/state.json":
+			fmt.Fprint(w, testMesosMasterState)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestMesosMasterConfigure(t *testing.T) {
+	oldNewMesosLeaderElect := newMesosLeaderElect
+	defer func() { newMesosLeaderElect = oldNewMesosLeaderElect }()
+	mock := new(mockMesosLeaderElect)
+	newMesosLeaderElect = func() util.MesosLeaderElectInterface { return mock }
+
+	m := newMesosMaster(nil, 12, nil).(*MesosMaster)
+	m.Configure(map[string]interface{}{
+		"interval":   30,
+		"mesosNodes": "http://1.2.3.4:5050/,http://5.6.7.8:5050/",
+		"leaderTTL":  "10",
+		"port":       5051,
+	})
+
+	assert := assert.New(t)
+	assert.Equal(30, m.Interval())
+	assert.Equal(10, m.leaderTTL)
+	assert.Equal(5051, m.port)
+	assert.Equal("http://1.2.3.4:5050/,http://5.6.7.8:5050/", mock.nodes)
+}
+
+func TestMesosMasterConfigureWithoutNodes(t *testing.T) {
+	m := newMesosMaster(nil, 12, nil).(*MesosMaster)
+	m.Configure(map[string]interface{}{})
+	assert.Nil(t, m.leader)
+}
+
+func TestMesosMasterCollectLeader(t *testing.T) {
+	ts := testMesosMasterServer()
+	defer ts.Close()
+	m, restore := getTestMesosMaster(t, "127.0.0.1", ts)
+	defer restore()
+
+	metrics := collectMesosMaster(m)
+	assert := assert.New(t)
+
+	stat, found := findMetric(metrics, "mesos.master.cpus_used", nil)
+	require.True(t, found)
+	assert.Equal(6.5, stat.Value)
+	assert.Equal(metric.Gauge, stat.MetricType)
+
+	stat, found = findMetric(metrics, "mesos.master.tasks_finished", nil)
+	require.True(t, found)
+	assert.Equal(metric.CumulativeCounter, stat.MetricType)
+
+	stat, found = findMetric(metrics, "mesos.framework.cpus_used", map[string]string{"framework": "marathon"})
+	require.True(t, found)
+	assert.Equal(4.5, stat.Value)
+	_, found = findMetric(metrics, "mesos.framework.ports_used", nil)
+	assert.False(found, "port ranges are not numeric")
+
+	stat, found = findMetric(metrics, "mesos.framework.tasks", map[string]string{"framework": "marathon"})
+	require.True(t, found)
+	assert.Equal(3.0, stat.Value)
+
+	stat, found = findMetric(metrics, "mesos.frameworks.active", nil)
+	require.True(t, found)
+	assert.Equal(1.0, stat.Value)
+}
+
+func TestMesosMasterCollectNotLeader(t *testing.T) {
+	ts := testMesosMasterServer()
+	defer ts.Close()
+	m, restore := getTestMesosMaster(t, "10.0.0.1", ts)
+	defer restore()
+
+	assert.Equal(t, 0, len(collectMesosMaster(m)), "only the leader should report")
+}
@@ -0,0 +1,158 @@
+package collector
+
+import (
+	"fullerite/config"
+	"fullerite/metric"
+
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	l "github.com/Sirupsen/logrus"
+)
+
+// DefaultMesosSlavePort is the port the mesos agent listens on
+const DefaultMesosSlavePort = 5051
+
+// mesosSlaveCounters are the snapshot keys that only ever go up
+var mesosSlaveCounters = []string{
+	"slave/valid_",
+	"slave/invalid_",
+	"slave/executors_terminated",
+	"slave/recovery_errors",
+	"slave/tasks_failed",
+	"slave/tasks_finished",
+	"slave/tasks_killed",
+	"slave/tasks_lost",
+}
+
+// MesosSlave collector type.
+// It reports the resource usage of the agent on this host as well as the
+// usage of every executor running on it.
+type MesosSlave struct {
+	baseCollector
+	host    string
+	port    int
+	timeout int
+	client  *http.Client
+}
+
+type mesosExecutorStatistics struct {
+	ExecutorID  string                 `json:"executor_id"`
+	FrameworkID string                 `json:"framework_id"`
+	Source      string                 `json:"source"`
+	Statistics  map[string]interface{} `json:"statistics"`
+}
+
+func init() {
+	RegisterCollector("MesosSlave", newMesosSlave)
+}
+
+// newMesosSlave creates a new MesosSlave collector.
+func newMesosSlave(channel chan metric.Metric, initialInterval int, log *l.Entry) Collector {
+	m := new(MesosSlave)
+
+	m.log = log
+	m.channel = channel
+	m.interval = initialInterval
+
+	m.name = "MesosSlave"
+	m.host = "localhost"
+	m.port = DefaultMesosSlavePort
+	m.timeout = DefaultMesosTimeout
+	m.client = &http.Client{Timeout: time.Duration(m.timeout) * time.Second}
+	return m
+}
+
+// Configure takes a dictionary of values with which the collector can configure itself.
+func (m *MesosSlave) Configure(configMap map[string]interface{}) {
+	if host, exists := configMap["host"]; exists {
+		m.host = host.(string)
+	}
+	if port, exists := configMap["port"]; exists {
+		m.port = config.GetAsInt(port, DefaultMesosSlavePort)
+	}
+	if timeout, exists := configMap["timeout"]; exists {
+		m.timeout = config.GetAsInt(timeout, DefaultMesosTimeout)
+	}
+	m.client = &http.Client{Timeout: time.Duration(m.timeout) * time.Second}
+	m.configureCommonParams(configMap)
+}
+
+// Collect reports the agent snapshot and the per executor statistics.
+func (m *MesosSlave) Collect() {
+	baseURL := fmt.Sprintf("http://%s:%d", m.host, m.port)
+
+	snapshot := map[string]float64{}
+	if err := getMesosJSON(m.client, baseURL+"/metrics/snapshot", &snapshot); err != nil {
+		m.log.Error("Failed to get the mesos agent snapshot: ", err)
+	} else {
+		for _, stat := range mesosSnapshotMetrics(snapshot, mesosSlaveCounters) {
+			m.Channel() <- stat
+		}
+	}
+
+	executors := []mesosExecutorStatistics{}
+	if err := getMesosJSON(m.client, baseURL+"/monitor/statistics.json", &executors); err != nil {
+		m.log.Error("Failed to get the mesos executor statistics: ", err)
+		return
+	}
+	for _, stat := range mesosExecutorMetrics(executors, m.frameworkNames(baseURL)) {
+		m.Channel() <- stat
+	}
+}
+
+// frameworkNames maps the IDs of the frameworks running on the agent to
+// their names, it is empty if the agent state is unavailable
+func (m *MesosSlave) frameworkNames(baseURL string) map[string]string {
+	names := map[string]string{}
+	state := struct {
+		Frameworks []mesosFramework `json:"frameworks"`
+	}{}
+	if err := getMesosJSON(m.client, baseURL+"/state.json", &state); err != nil {
+		m.log.Warn("Failed to get the mesos agent state, reporting executors without framework names: ", err)
+		return names
+	}
+	for _, framework := range state.Frameworks {
+		names[framework.ID] = framework.Name
+	}
+	return names
+}
+
+// mesosExecutorMetrics turns the numeric executor statistics into metrics
+// with framework_id, executor and task dimensions, like the master's
+// framework metrics they have a framework dimension with the name of the
+// framework if it is known. CPU times, throttling and network statistics
+// are cumulative counters.
+func mesosExecutorMetrics(executors []mesosExecutorStatistics, frameworkNames map[string]string) []metric.Metric {
+	metrics := []metric.Metric{}
+	now := time.Now()
+	for _, executor := range executors {
+		dims := map[string]string{
+			"framework_id": executor.FrameworkID,
+			"executor":     executor.ExecutorID,
+			"task":         executor.Source,
+		}
+		if name := frameworkNames[executor.FrameworkID]; name != "" {
+			dims["framework"] = name
+		}
+		for field, raw := range executor.Statistics {
+			value, ok := raw.(float64)
+			if !ok || field == "timestamp" {
+				continue
+			}
+			m := metric.New("mesos.executor." + field)
+			m.Value = value
+			if strings.HasSuffix(field, "_time_secs") ||
+				strings.HasPrefix(field, "cpus_nr_") ||
+				strings.HasPrefix(field, "net_") {
+				m.MetricType = metric.CumulativeCounter
+			}
+			m.AddDimensions(dims)
+			m.SetTime(now)
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics
+}
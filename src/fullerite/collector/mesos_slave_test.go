@@ -0,0 +1,153 @@
+package collector
+
+import (
+	"fullerite/metric"
+	"test_utils"
+
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testMesosSlaveSnapshot = `{
+	"slave/cpus_total": 8,
+	"slave/mem_used": 4096,
+	"slave/tasks_failed": 3
+}`
+
+const testMesosExecutorStatistics = `[
+	{
+		"executor_id": "web.1234",
+		"executor_name": "Command Executor",
+		"framework_id": "20150101-0000-0000-0000-000000000000-0000",
+		"source": "web.1234",
+		"statistics": {
+			"timestamp": 1452000000.5,
+			"cpus_limit": 1.1,
+			"cpus_user_time_secs": 12.5,
+			"cpus_nr_throttled": 7,
+			"mem_rss_bytes": 1048576,
+			"net_rx_bytes": 2048
+		}
+	}
+]`
+
+const testMesosSlaveState = `{
+	"frameworks": [
+		{"id": "20150101-0000-0000-0000-000000000000-0000", "name": "marathon"}
+	]
+}`
+
+func TestMesosSlaveConfigure(t *testing.T) {
+	m := newMesosSlave(nil, 12, nil).(*MesosSlave)
+	m.Configure(map[string]interface{}{})
+
+	assert := assert.New(t)
+	assert.Equal(12, m.Interval())
+	assert.Equal("localhost", m.host)
+	assert.Equal(DefaultMesosSlavePort, m.port)
+
+	m.Configure(map[string]interface{}{"host": "10.0.0.2", "port": "5052", "timeout": 1})
+	assert.Equal("10.0.0.2", m.host)
+	assert.Equal(5052, m.port)
+	assert.Equal(1, m.timeout)
+}
+
+func TestMesosSlaveCollect(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metrics/snapshot":
+			fmt.Fprint(w, testMesosSlaveSnapshot)
+		case "/monitor/statistics.json":
+			fmt.Fprint(w, testMesosExecutorStatistics)
+		case "/state.json":
+			fmt.Fprint(w, testMesosSlaveState)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	metrics := collectMesosSlave(t, ts)
+
+	assert := assert.New(t)
+	assert.Equal(8, len(metrics), "the executor timestamp should be skipped")
+
+	stat, found := findMetric(metrics, "mesos.slave.tasks_failed", nil)
+	require.True(t, found)
+	assert.Equal(metric.CumulativeCounter, stat.MetricType)
+
+	dims := map[string]string{
+		"framework":    "marathon",
+		"framework_id": "20150101-0000-0000-0000-000000000000-0000",
+		"executor":     "web.1234",
+		"task":         "web.1234",
+	}
+	stat, found = findMetric(metrics, "mesos.executor.mem_rss_bytes", dims)
+	require.True(t, found)
+	assert.Equal(1048576.0, stat.Value)
+	assert.Equal(metric.Gauge, stat.MetricType)
+
+	for _, name := range []string{"cpus_user_time_secs", "cpus_nr_throttled", "net_rx_bytes"} {
+		stat, found = findMetric(metrics, "mesos.executor."+name, dims)
+		require.True(t, found, name)
+		assert.Equal(metric.CumulativeCounter, stat.MetricType, name)
+	}
+}
+
+func TestMesosSlaveCollectWithoutState(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/monitor/statistics.json" {
+			fmt.Fprint(w, testMesosExecutorStatistics)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer ts.Close()
+
+	metrics := collectMesosSlave(t, ts)
+	stat, found := findMetric(metrics, "mesos.executor.mem_rss_bytes", nil)
+	require.True(t, found)
+	_, hasName := stat.Dimensions["framework"]
+	assert.False(t, hasName, "the framework name is left out if it is unknown")
+	assert.Equal(t, "20150101-0000-0000-0000-000000000000-0000", stat.Dimensions["framework_id"])
+}
+
+func collectMesosSlave(t *testing.T, ts *httptest.Server) []metric.Metric {
+	m := newMesosSlave(make(chan metric.Metric), 10, test_utils.BuildLogger()).(*MesosSlave)
+	m.Configure(map[string]interface{}{"host": "127.0.0.1", "port": serverPort(t, ts)})
+
+	go func() {
+		m.Collect()
+		close(m.channel)
+	}()
+	metrics := []metric.Metric{}
+	for stat := range m.Channel() {
+		metrics = append(metrics, stat)
+	}
+	return metrics
+}
+
+func TestMesosSlaveCollectUnavailable(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	m := newMesosSlave(make(chan metric.Metric), 10, test_utils.BuildLogger()).(*MesosSlave)
+	m.Configure(map[string]interface{}{"host": "127.0.0.1", "port": serverPort(t, ts)})
+
+	go func() {
+		m.Collect()
+		close(m.channel)
+	}()
+	count := 0
+	for range m.Channel() {
+		count++
+	}
+	assert.Equal(t, 0, count)
+}
@@ -0,0 +1,152 @@
+package collector
+
+import (
+	"fullerite/config"
+	"fullerite/metric"
+	"fullerite/util"
+
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	l "github.com/Sirupsen/logrus"
+)
+
+const (
+	// DefaultNerveConfigPath is where nerve keeps its configuration
+	DefaultNerveConfigPath = "/etc/nerve/nerve.conf.json"
+
+	// DefaultNerveStatusPath is the path scraped on every service
+	DefaultNerveStatusPath = "/status/metrics"
+
+	// DefaultNerveStatusTimeout is the request timeout in seconds
+	DefaultNerveStatusTimeout = 5
+)
+
+// For dependency injection
+var parseNerveConfig = util.ParseNerveConfig
+
+// NerveStatus collector type.
+// It re-reads the nerve config every interval and scrapes the JSON status
+// endpoint of every service registered on this host, so new services are
+// picked up without touching the fullerite config.
+type NerveStatus struct {
+	baseCollector
+	configFilePath string
+	statusPath     string
+	servicePaths   map[string]string
+	timeout        int
+	selectors      []jsonSelector
+	client         *http.Client
+}
+
+func init() {
+	RegisterCollector("NerveStatus", newNerveStatus)
+}
+
+// newNerveStatus creates a new NerveStatus collector.
+func newNerveStatus(channel chan metric.Metric, initialInterval int, log *l.Entry) Collector {
+	n := new(NerveStatus)
+
+	n.log = log
+	n.channel = channel
+	n.interval = initialInterval
+
+	n.name = "NerveStatus"
+	n.configFilePath = DefaultNerveConfigPath
+	n.statusPath = DefaultNerveStatusPath
+	n.servicePaths = map[string]string{}
+	n.timeout = DefaultNerveStatusTimeout
+	n.client = &http.Client{Timeout: time.Duration(n.timeout) * time.Second}
+	return n
+}
+
+// Configure takes a dictionary of values with which the collector can configure itself.
+func (n *NerveStatus) Configure(configMap map[string]interface{}) {
+	if path, exists := configMap["configFilePath"]; exists {
+		n.configFilePath = path.(string)
+	}
+	if path, exists := configMap["statusPath"]; exists {
+		n.statusPath = path.(string)
+	}
+	if paths, exists := configMap["servicePaths"]; exists {
+		n.servicePaths = config.GetAsMap(paths)
+	}
+	if timeout, exists := configMap["timeout"]; exists {
+		n.timeout = config.GetAsInt(timeout, DefaultNerveStatusTimeout)
+	}
+	if selectors, exists := configMap["metrics"]; exists {
+		n.selectors = parseJSONSelectors(selectors)
+	}
+	n.client = &http.Client{Timeout: time.Duration(n.timeout) * time.Second}
+	n.configureCommonParams(configMap)
+}
+
+// Collect reads the nerve config and scrapes every local service in parallel.
+func (n *NerveStatus) Collect() {
+	rawConfig, err := ioutil.ReadFile(n.configFilePath)
+	if err != nil {
+		n.log.Error("Failed to read the nerve config at ", n.configFilePath, ": ", err)
+		return
+	}
+	services, err := parseNerveConfig(&rawConfig)
+	if err != nil {
+		n.log.Error("Failed to parse the nerve config at ", n.configFilePath, ": ", err)
+		return
+	}
+	n.log.Debug("Found ", len(services), " services in the nerve config")
+
+	var wg sync.WaitGroup
+	for port, service := range services {
+		wg.Add(1)
+		go func(service string, port int) {
+			defer wg.Done()
+			for _, m := range n.collectService(service, port) {
+				n.Channel() <- m
+			}
+		}(service, port)
+	}
+	wg.Wait()
+}
+
+// collectService scrapes a single service. NerveStatusUp is always reported.
+func (n *NerveStatus) collectService(service string, port int) []metric.Metric {
+	path := n.statusPath
+	if servicePath, exists := n.servicePaths[service]; exists {
+		path = servicePath
+	}
+	url := fmt.Sprintf("http://localhost:%d%s", port, path)
+
+	metrics, err := n.fetch(url)
+	up := metric.WithValue("NerveStatusUp", 1)
+	if err != nil {
+		n.log.Warn("Failed to collect ", service, " from ", url, ": ", err)
+		up.Value = 0
+	}
+	metrics = append(metrics, up)
+	metric.AddToAll(&metrics, map[string]string{
+		"service_name": service,
+		"port":         strconv.Itoa(port),
+	})
+	return metrics
+}
+
+func (n *NerveStatus) fetch(url string) ([]metric.Metric, error) {
+	rsp, err := n.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	body, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", rsp.StatusCode)
+	}
+	return parseJSONDocument(body, n.selectors)
+}
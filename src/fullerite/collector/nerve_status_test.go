@@ -0,0 +1,159 @@
+package collector
+
+import (
+	"fullerite/metric"
+	"test_utils"
+
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeNerveConfig reads a simple {"service": port} document instead of a
+// real nerve config, which would require the services to be on this host.
+func fakeNerveConfig(raw *[]byte) (map[int]string, error) {
+	services := map[string]int{}
+	if err := json.Unmarshal(*raw, &services); err != nil {
+		return nil, err
+	}
+	results := map[int]string{}
+	for name, port := range services {
+		results[port] = name
+	}
+	return results, nil
+}
+
+func serverPort(t *testing.T, ts *httptest.Server) int {
+	port, err := strconv.Atoi(ts.URL[strings.LastIndex(ts.URL, ":")+1:])
+	require.Nil(t, err)
+	return port
+}
+
+func collectNerveStatus(n *NerveStatus) []metric.Metric {
+	n.channel = make(chan metric.Metric)
+	go func() {
+		n.Collect()
+		close(n.channel)
+	}()
+	metrics := []metric.Metric{}
+	for m := range n.Channel() {
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+func TestNerveStatusConfigureEmptyConfig(t *testing.T) {
+	n := newNerveStatus(nil, 12, nil).(*NerveStatus)
+	n.Configure(map[string]interface{}{})
+
+	assert := assert.New(t)
+	assert.Equal(12, n.Interval())
+	assert.Equal(DefaultNerveConfigPath, n.configFilePath)
+	assert.Equal(DefaultNerveStatusPath, n.statusPath)
+	assert.Equal(DefaultNerveStatusTimeout, n.timeout)
+}
+
+func TestNerveStatusConfigure(t *testing.T) {
+	config := map[string]interface{}{
+		"interval":       30,
+		"configFilePath": "/tmp/nerve.json",
+		"statusPath":     "/metrics",
+		"servicePaths":   map[string]interface{}{"uwsgi_service": "/uwsgi"},
+		"timeout":        2,
+		"metrics":        []interface{}{map[string]interface{}{"path": "gauges.*.value"}},
+	}
+	n := newNerveStatus(nil, 12, nil).(*NerveStatus)
+	n.Configure(config)
+
+	assert := assert.New(t)
+	assert.Equal(30, n.Interval())
+	assert.Equal("/tmp/nerve.json", n.configFilePath)
+	assert.Equal("/metrics", n.statusPath)
+	assert.Equal("/uwsgi", n.servicePaths["uwsgi_service"])
+	assert.Equal(2, n.timeout)
+	assert.Equal(1, len(n.selectors))
+}
+
+func TestNerveStatusCollect(t *testing.T) {
+	oldParse := parseNerveConfig
+	parseNerveConfig = fakeNerveConfig
+	defer func() { parseNerveConfig = oldParse }()
+
+	first := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/status/metrics", r.URL.Path)
+		fmt.Fprint(w, `{"gauges": {"jvm.threads": {"value": 12}}}`)
+	}))
+	defer first.Close()
+	second := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/uwsgi", r.URL.Path)
+		fmt.Fprint(w, `{"gauges": {"workers": {"value": 4}}}`)
+	}))
+	defer second.Close()
+
+	tmpFile, err := ioutil.TempFile("", "fullerite_nerve")
+	require.Nil(t, err)
+	defer os.Remove(tmpFile.Name())
+	firstPort, secondPort := serverPort(t, first), serverPort(t, second)
+	ioutil.WriteFile(tmpFile.Name(), []byte(fmt.Sprintf(`{"java_service": %d}`, firstPort)), 0644)
+
+	n := newNerveStatus(nil, 10, test_utils.BuildLogger()).(*NerveStatus)
+	n.Configure(map[string]interface{}{
+		"configFilePath": tmpFile.Name(),
+		"servicePaths":   map[string]interface{}{"uwsgi_service": "/uwsgi"},
+		"metrics":        []interface{}{map[string]interface{}{"path": "gauges.*.value"}},
+	})
+
+	metrics := collectNerveStatus(n)
+	assert.Equal(t, 2, len(metrics))
+	m, found := findMetric(metrics, "gauges.jvm.threads.value", map[string]string{
+		"service_name": "java_service",
+		"port":         strconv.Itoa(firstPort),
+	})
+	require.True(t, found)
+	assert.Equal(t, 12.0, m.Value)
+
+	// a service registered later is picked up on the next interval
+	ioutil.WriteFile(tmpFile.Name(), []byte(fmt.Sprintf(
+		`{"java_service": %d, "uwsgi_service": %d}`, firstPort, secondPort)), 0644)
+
+	metrics = collectNerveStatus(n)
+	assert.Equal(t, 4, len(metrics))
+	m, found = findMetric(metrics, "gauges.workers.value", map[string]string{"service_name": "uwsgi_service"})
+	require.True(t, found)
+	assert.Equal(t, 4.0, m.Value)
+	m, found = findMetric(metrics, "NerveStatusUp", map[string]string{"service_name": "uwsgi_service"})
+	require.True(t, found)
+	assert.Equal(t, 1.0, m.Value)
+}
+
+func TestNerveStatusCollectServiceDown(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	n := newNerveStatus(nil, 10, test_utils.BuildLogger()).(*NerveStatus)
+	n.Configure(map[string]interface{}{})
+
+	metrics := n.collectService("broken_service", serverPort(t, ts))
+	require.Equal(t, 1, len(metrics))
+	assert.Equal(t, "NerveStatusUp", metrics[0].Name)
+	assert.Equal(t, 0.0, metrics[0].Value)
+	assert.Equal(t, "broken_service", metrics[0].Dimensions["service_name"])
+}
+
+func TestNerveStatusCollectMissingConfig(t *testing.T) {
+	n := newNerveStatus(nil, 10, test_utils.BuildLogger()).(*NerveStatus)
+	n.Configure(map[string]interface{}{"configFilePath": "/does/not/exist"})
+
+	assert.Equal(t, 0, len(collectNerveStatus(n)))
+}
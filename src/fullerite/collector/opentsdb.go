@@ -1,14 +1,23 @@
 package collector
 
 import (
+	"fullerite/metric"
+
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"encoding/json"
 	"fmt"
-	"fullerite/metric"
+	"io"
+	"io/ioutil"
+	"math"
 	"net"
+	"net/http"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	l "github.com/Sirupsen/logrus"
@@ -21,13 +30,56 @@ const (
 	MetricRegex = `put (?P<name>[0-9\.\-\_a-zA-Z]+)\s+(?P<dimensions>[0-9\.\-\_\=\,a-zA-Z]+)\s+(?P<time>\d+)\s+(?P<value>[0-9\.]+)`
 )
 
-// OpenTSDB collector type
+// Error types counted by the OpenTSDB collector, named like OpenTSDB's own
+// tsd.rpc.errors types
+const (
+	openTSDBIllegalArguments = "illegal_arguments"
+	openTSDBInvalidValues    = "invalid_values"
+	openTSDBUnknownCommands  = "unknown_commands"
+	openTSDBBadRequests      = "bad_requests"
+)
+
+// OpenTSDB collector type.
+// It speaks the OpenTSDB telnet protocol on port, supporting the put, version,
+// stats, help and exit commands, and serves the /api/put endpoint on httpPort
+// if set. Besides "put <metric> <timestamp> <value> <tagk=tagv> ..." the
+// older "put <metric> <tagk=tagv,...> <timestamp> <value>" layout is accepted.
+// The number of rejected data points per error type is reported as the
+// OpenTSDBErrors counter.
 type OpenTSDB struct {
 	baseCollector
 	port          string
+	httpPort      string
 	serverStarted bool
 	metricRegex   *regexp.Regexp
-	incoming      chan string
+	incoming      chan metric.Metric
+
+	mu       sync.Mutex
+	received map[string]uint64
+	errors   map[string]uint64
+}
+
+// openTSDBError is a rejected data point or command
+type openTSDBError struct {
+	kind string
+	msg  string
+}
+
+func (e *openTSDBError) Error() string {
+	return e.msg
+}
+
+func newOpenTSDBError(kind string, format string, args ...interface{}) *openTSDBError {
+	return &openTSDBError{kind: kind, msg: fmt.Sprintf(format, args...)}
+}
+
+// openTSDBDataPoint is a data point of the HTTP API, timestamp and value may
+// be numbers or strings
+type openTSDBDataPoint struct {
+	Metric    string            `json:"metric"`
+	Timestamp interface{}       `json:"timestamp"`
+	Value     interface{}       `json:"value"`
+	Tags      map[string]string `json:"tags"`
 }
 
 func init() {
@@ -43,9 +95,11 @@ func newOpenTSDB(channel chan metric.Metric, initialInterval int, log *l.Entry)
 	d.interval = initialInterval
 
 	d.name = "OpenTSDB"
-	d.incoming = make(chan string)
+	d.incoming = make(chan metric.Metric)
 	d.port = DefaultOpenTSDBCollectorPort
 	d.serverStarted = false
+	d.received = make(map[string]uint64)
+	d.errors = make(map[string]uint64)
 	d.SetCollectorType("listener")
 	return d
 }
@@ -53,12 +107,13 @@ func newOpenTSDB(channel chan metric.Metric, initialInterval int, log *l.Entry)
 // Configure the collector
 func (c *OpenTSDB) Configure(configMap map[string]interface{}) {
 	if port, exists := configMap["port"]; exists {
-		c.port = port.(string)
+		c.port = fmt.Sprint(port)
+	}
+	if httpPort, exists := configMap["httpPort"]; exists {
+		c.httpPort = fmt.Sprint(httpPort)
 	}
 	if regex, exists := configMap["metric-regex"]; exists {
 		c.metricRegex = regexp.MustCompile(regex.(string))
-	} else {
-		c.metricRegex = regexp.MustCompile(MetricRegex)
 	}
 	c.configureCommonParams(configMap)
 }
@@ -68,6 +123,38 @@ func (c *OpenTSDB) Port() string {
 	return c.port
 }
 
+// HTTPPort returns the /api/put listen port, an empty string means disabled
+func (c *OpenTSDB) HTTPPort() string {
+	return c.httpPort
+}
+
+// Errors returns the number of rejected data points and commands per error type
+func (c *OpenTSDB) Errors() map[string]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	errors := make(map[string]uint64, len(c.errors))
+	for kind, count := range c.errors {
+		errors[kind] = count
+	}
+	return errors
+}
+
+func (c *OpenTSDB) countReceived(rpc string) {
+	c.mu.Lock()
+	c.received[rpc]++
+	c.mu.Unlock()
+}
+
+func (c *OpenTSDB) countError(err error) {
+	kind := openTSDBIllegalArguments
+	if tsdbErr, ok := err.(*openTSDBError); ok {
+		kind = tsdbErr.kind
+	}
+	c.mu.Lock()
+	c.errors[kind]++
+	c.mu.Unlock()
+}
+
 // collectOpenTSDB opens up and reads from the a TCP socket and
 // writes what it's read to a local channel.
 
@@ -95,7 +182,8 @@ func (c *OpenTSDB) collectOpenTSDB() {
 	}
 }
 
-// readOpenTSDBMetrics reads from the connection
+// readOpenTSDBMetrics reads commands from the connection until it is
+// closed or the client sends exit.
 func (c *OpenTSDB) readOpenTSDBMetrics(conn *net.TCPConn) {
 	defer conn.Close()
 	conn.SetKeepAlive(true)
@@ -103,50 +191,338 @@ func (c *OpenTSDB) readOpenTSDBMetrics(conn *net.TCPConn) {
 	reader := bufio.NewReader(conn)
 	c.log.Info("Connection started: ", conn.RemoteAddr())
 	for {
-		line, err := reader.ReadBytes('\n')
+		line, err := reader.ReadString('\n')
 		if err != nil {
-			c.log.Warn("Error while reading OpenTSDB metrics", err)
+			if err != io.EOF {
+				c.log.Warn("Error while reading OpenTSDB metrics", err)
+			}
 			break
 		}
 		c.log.Debug("Read: ", line)
-		c.incoming <- bytes.NewBuffer(line).String()
+		if !c.handleCommand(strings.TrimSpace(line), conn) {
+			break
+		}
 	}
 	c.log.Info("Connection closed: ", conn.RemoteAddr())
 }
 
+// handleCommand runs a telnet command and writes its reply, if any.
+// It returns false if the connection should be closed.
+func (c *OpenTSDB) handleCommand(line string, w io.Writer) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return true
+	}
+	command := fields[0]
+	switch command {
+	case "put":
+		c.countReceived("put")
+		m, err := c.parsePut(line, fields[1:])
+		if err != nil {
+			c.countError(err)
+			fmt.Fprintf(w, "put: %s\n", err)
+			return true
+		}
+		c.incoming <- m
+	case "version":
+		c.countReceived("version")
+		fmt.Fprint(w, "net.opentsdb compatible fullerite OpenTSDB collector\n")
+	case "stats":
+		c.countReceived("stats")
+		c.writeStats(w, time.Now())
+	case "help":
+		c.countReceived("help")
+		fmt.Fprint(w, "available commands: exit help put stats version\n")
+	case "exit":
+		return false
+	default:
+		c.countError(newOpenTSDBError(openTSDBUnknownCommands, "unknown command"))
+		fmt.Fprintf(w, "unknown command: %s.  Try `help'.\n", command)
+	}
+	return true
+}
+
+// writeStats reports the collector's counters in the telnet put format
+func (c *OpenTSDB) writeStats(w io.Writer, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, stat := range []struct {
+		name   string
+		counts map[string]uint64
+	}{{"tsd.rpc.received", c.received}, {"tsd.rpc.errors", c.errors}} {
+		kinds := make([]string, 0, len(stat.counts))
+		for kind := range stat.counts {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		for _, kind := range kinds {
+			fmt.Fprintf(w, "%s %d %d type=%s\n", stat.name, now.Unix(), stat.counts[kind], kind)
+		}
+	}
+}
+
 // Collect reads metrics collected from OpenTSDB collectors, converts
 // them to fullerite's Metric type and publishes them to handlers.
 func (c *OpenTSDB) Collect() {
 	if !c.serverStarted {
 		c.serverStarted = true
 		go c.collectOpenTSDB()
+		if c.httpPort != "" {
+			go c.collectHTTP()
+		}
+		go c.reportErrors()
 	}
 
-	for line := range c.incoming {
-		if metric, ok := c.parseMetric(string(line)); ok {
-			c.Channel() <- metric
+	for m := range c.incoming {
+		c.Channel() <- m
+	}
+}
+
+// reportErrors publishes the error counters every interval
+func (c *OpenTSDB) reportErrors() {
+	if c.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(c.interval) * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		for kind, count := range c.Errors() {
+			m := metric.New("OpenTSDBErrors")
+			m.MetricType = metric.CumulativeCounter
+			m.Value = float64(count)
+			m.AddDimension("type", kind)
+			c.incoming <- m
 		}
 	}
 }
 
+// parseMetric parses a put line, it is kept for callers which only care
+// whether the line is valid.
 func (c *OpenTSDB) parseMetric(line string) (metric.Metric, bool) {
-	match := c.metricRegex.FindStringSubmatch(line)
-	if match == nil {
-		msg := fmt.Sprintf("could not match '%s' against regex", line)
-		return metric.New(msg), false
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != "put" {
+		return metric.Metric{}, false
+	}
+	m, err := c.parsePut(line, fields[1:])
+	return m, err == nil
+}
+
+// parsePut parses the arguments of a put command, using metric-regex on
+// the whole line if it is configured.
+func (c *OpenTSDB) parsePut(line string, args []string) (metric.Metric, error) {
+	if c.metricRegex != nil {
+		match := c.metricRegex.FindStringSubmatch(line)
+		if match == nil || len(match) < 5 {
+			return metric.Metric{}, newOpenTSDBError(openTSDBIllegalArguments, "could not match '%s' against regex", line)
+		}
+		return buildOpenTSDBMetric(match[1], match[3], match[4], parseOpenTSDBTags(strings.Split(match[2], ",")))
+	}
+
+	if len(args) < 4 {
+		return metric.Metric{}, newOpenTSDBError(openTSDBIllegalArguments,
+			"illegal argument: not enough arguments (need at least 4, got %d)", len(args))
+	}
+	if strings.Contains(args[1], "=") {
+		// put <metric> <tagk=tagv,...> <timestamp> <value>
+		if len(args) != 4 {
+			return metric.Metric{}, newOpenTSDBError(openTSDBIllegalArguments,
+				"illegal argument: expected metric, tags, timestamp and value")
+		}
+		return buildOpenTSDBMetric(args[0], args[2], args[3], parseOpenTSDBTags(strings.Split(args[1], ",")))
+	}
+	return buildOpenTSDBMetric(args[0], args[1], args[2], parseOpenTSDBTags(args[3:]))
+}
+
+// parseOpenTSDBTags parses tagk=tagv pairs, invalid pairs are returned
+// with an empty key so that they are rejected by buildOpenTSDBMetric.
+func parseOpenTSDBTags(pairs []string) map[string]string {
+	tags := map[string]string{}
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			tags[""] = pair
+			continue
+		}
+		tags[kv[0]] = kv[1]
+	}
+	return tags
+}
+
+// buildOpenTSDBMetric validates a data point like OpenTSDB does and converts
+// it to a gauge.
+func buildOpenTSDBMetric(name string, timestamp string, value string, tags map[string]string) (metric.Metric, error) {
+	if name == "" {
+		return metric.Metric{}, newOpenTSDBError(openTSDBIllegalArguments, "illegal argument: empty metric name")
+	}
+	if len(tags) == 0 {
+		return metric.Metric{}, newOpenTSDBError(openTSDBIllegalArguments, "illegal argument: need at least one tag")
 	}
-	dims := map[string]string{}
-	for _, item := range strings.Split(match[2], ",") {
-		i := strings.Split(item, "=")
-		dims[i[0]] = i[1]
+	for k, v := range tags {
+		if k == "" || v == "" {
+			return metric.Metric{}, newOpenTSDBError(openTSDBIllegalArguments, "illegal argument: invalid tag '%s=%s'", k, v)
+		}
 	}
-	i, err := strconv.ParseInt(match[3], 10, 64)
+	tm, err := parseOpenTSDBTimestamp(timestamp)
 	if err != nil {
-		msg := fmt.Sprintf("Not an UNIX epoch '%s'", match[3])
-		return metric.New(msg), false
+		return metric.Metric{}, err
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return metric.Metric{}, newOpenTSDBError(openTSDBInvalidValues, "invalid value: '%s'", value)
+	}
+	return metric.NewExt(name, metric.Gauge, v, tags, tm, false), nil
+}
+
+// parseOpenTSDBTimestamp accepts seconds or milliseconds, the latter either
+// with 13 digits or as seconds with a 3 digit fraction.
+func parseOpenTSDBTimestamp(raw string) (time.Time, error) {
+	invalid := newOpenTSDBError(openTSDBIllegalArguments, "illegal argument: invalid timestamp '%s'", raw)
+	digits := raw
+	if parts := strings.SplitN(raw, ".", 2); len(parts) == 2 {
+		if len(parts[0]) > 10 || len(parts[1]) != 3 {
+			return time.Time{}, invalid
+		}
+		digits = parts[0] + parts[1]
+	} else if len(raw) <= 10 {
+		digits = raw + "000"
+	}
+	if len(digits) > 13 {
+		return time.Time{}, invalid
 	}
-	tm := time.Unix(i, 0)
-	v, _ := strconv.Atoi(match[4])
-	m := metric.NewExt(match[1], "gauge", float64(v), dims, tm, false)
-	return m, true
+	ms, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || ms <= 0 {
+		return time.Time{}, invalid
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)), nil
+}
+
+// collectHTTP serves the OpenTSDB HTTP API.
+func (c *OpenTSDB) collectHTTP() {
+	ln, err := net.Listen("tcp", ":"+c.httpPort)
+	if err != nil {
+		c.log.Fatal("Cannot listen on OpenTSDB HTTP socket", err)
+	}
+
+	// figure out the port bind for HTTPPort()
+	_, c.httpPort, _ = net.SplitHostPort(ln.Addr().String())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/put", c.servePut)
+	if err := http.Serve(ln, mux); err != nil {
+		c.log.Error("OpenTSDB HTTP server stopped: ", err)
+	}
+}
+
+// servePut handles /api/put with a single data point or a list of them.
+// Valid data points are published even if others are rejected. The summary
+// and details query parameters add the counts and the errors to the response.
+func (c *OpenTSDB) servePut(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		c.countError(newOpenTSDBError(openTSDBBadRequests, "method not allowed"))
+		writeOpenTSDBError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	c.countReceived("put")
+
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			c.countError(newOpenTSDBError(openTSDBBadRequests, "%s", err))
+			writeOpenTSDBError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+	raw, err := ioutil.ReadAll(body)
+	if err != nil {
+		c.countError(newOpenTSDBError(openTSDBBadRequests, "%s", err))
+		writeOpenTSDBError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	points, err := splitOpenTSDBDataPoints(raw)
+	if err != nil {
+		c.countError(newOpenTSDBError(openTSDBBadRequests, "%s", err))
+		writeOpenTSDBError(w, http.StatusBadRequest, "Unable to parse the given JSON: "+err.Error())
+		return
+	}
+
+	type pointError struct {
+		Datapoint json.RawMessage `json:"datapoint"`
+		Error     string          `json:"error"`
+	}
+	errs := []pointError{}
+	success := 0
+	for _, point := range points {
+		m, err := parseOpenTSDBDataPoint(point)
+		if err != nil {
+			c.countError(err)
+			errs = append(errs, pointError{Datapoint: point, Error: err.Error()})
+			continue
+		}
+		c.incoming <- m
+		success++
+	}
+
+	query := r.URL.Query()
+	_, details := query["details"]
+	_, summary := query["summary"]
+	status := http.StatusOK
+	if len(errs) > 0 {
+		status = http.StatusBadRequest
+	}
+	switch {
+	case details:
+		writeOpenTSDBJSON(w, status, map[string]interface{}{"errors": errs, "failed": len(errs), "success": success})
+	case summary:
+		writeOpenTSDBJSON(w, status, map[string]interface{}{"failed": len(errs), "success": success})
+	case len(errs) > 0:
+		writeOpenTSDBError(w, status, "One or more data points had errors")
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// splitOpenTSDBDataPoints returns the raw data points of a body holding an
+// object or a list of objects.
+func splitOpenTSDBDataPoints(raw []byte) ([]json.RawMessage, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && raw[0] == '[' {
+		points := []json.RawMessage{}
+		err := json.Unmarshal(raw, &points)
+		return points, err
+	}
+	var point json.RawMessage
+	if err := json.Unmarshal(raw, &point); err != nil {
+		return nil, err
+	}
+	return []json.RawMessage{point}, nil
+}
+
+func parseOpenTSDBDataPoint(raw json.RawMessage) (metric.Metric, error) {
+	var point openTSDBDataPoint
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&point); err != nil {
+		return metric.Metric{}, newOpenTSDBError(openTSDBIllegalArguments, "illegal argument: %s", err)
+	}
+	if point.Timestamp == nil || point.Value == nil {
+		return metric.Metric{}, newOpenTSDBError(openTSDBIllegalArguments, "illegal argument: missing timestamp or value")
+	}
+	return buildOpenTSDBMetric(point.Metric, fmt.Sprint(point.Timestamp), fmt.Sprint(point.Value), point.Tags)
+}
+
+func writeOpenTSDBJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	b, _ := json.Marshal(v)
+	w.Write(b)
+}
+
+func writeOpenTSDBError(w http.ResponseWriter, code int, msg string) {
+	writeOpenTSDBJSON(w, code, map[string]interface{}{
+		"error": map[string]interface{}{"code": code, "message": msg},
+	})
 }
@@ -1,8 +1,13 @@
 package collector
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -103,3 +108,168 @@ func connectToOpenTSDBCollector(c *OpenTSDB) (net.Conn, error) {
 	}
 	return conn, err
 }
+
+func TestOpenTSDBConfigureHTTPPort(t *testing.T) {
+	c := newOpenTSDB(nil, 12, nil).(*OpenTSDB)
+	c.Configure(map[string]interface{}{"port": 4343, "httpPort": 4344})
+
+	assert.Equal(t, "4343", c.Port())
+	assert.Equal(t, "4344", c.HTTPPort())
+}
+
+func TestParseOpenTSDBPut(t *testing.T) {
+	c := newOpenTSDB(nil, 12, nil).(*OpenTSDB)
+	c.Configure(map[string]interface{}{})
+
+	m, err := c.parsePut("", []string{"sys.cpu.nice", "1346846400123", "-18.5", "host=web01", "dc=lga"})
+	require.Nil(t, err)
+
+	assert := assert.New(t)
+	assert.Equal("sys.cpu.nice", m.Name)
+	assert.Equal(-18.5, m.Value)
+	assert.Equal(metric.Gauge, m.MetricType)
+	assert.Equal(map[string]string{"host": "web01", "dc": "lga"}, m.Dimensions)
+	assert.Equal(time.Unix(1346846400, 123000000), m.GetTime())
+
+	m, err = c.parsePut("", []string{"sys.cpu.nice", "1346846400.5e0", "1", "host=web01"})
+	assert.NotNil(err)
+
+	m, err = c.parsePut("", []string{"sys.cpu.nice", "1346846400.250", "1e3", "host=web01"})
+	require.Nil(t, err)
+	assert.Equal(1000.0, m.Value)
+	assert.Equal(time.Unix(1346846400, 250000000), m.GetTime())
+}
+
+func TestParseOpenTSDBPutErrors(t *testing.T) {
+	c := newOpenTSDB(nil, 12, nil).(*OpenTSDB)
+	c.Configure(map[string]interface{}{})
+
+	for args, kind := range map[string]string{
+		"sys.cpu 1346846400 1":                     openTSDBIllegalArguments,
+		"sys.cpu 1346846400 1 host":                openTSDBIllegalArguments,
+		"sys.cpu 1346846400 1 host=":               openTSDBIllegalArguments,
+		"sys.cpu -5 1 host=a":                      openTSDBIllegalArguments,
+		"sys.cpu 13468464000000 1 host=a":          openTSDBIllegalArguments,
+		"sys.cpu 1346846400 abc host=a":            openTSDBInvalidValues,
+		"sys.cpu 1346846400 NaN host=a":            openTSDBInvalidValues,
+		"sys.cpu host=a,b=c 1346846400 1 host=b":   openTSDBIllegalArguments,
+		"sys.cpu host=a,b=c 1346846400 notanumber": openTSDBInvalidValues,
+	} {
+		_, err := c.parsePut("", strings.Fields(args))
+		require.NotNil(t, err, args)
+		assert.Equal(t, kind, err.(*openTSDBError).kind, args)
+	}
+}
+
+func TestParseOpenTSDBMetricRegex(t *testing.T) {
+	c := newOpenTSDB(nil, 12, nil).(*OpenTSDB)
+	c.Configure(map[string]interface{}{"metric-regex": MetricRegex})
+
+	m, ok := c.parseMetric("put sys.cpu.user host=webserver01,cpu=0 1356998400 1.5")
+	assert.True(t, ok)
+	assert.Equal(t, 1.5, m.Value)
+
+	_, ok = c.parseMetric("put sys.cpu.user 1356998400 1.5 host=webserver01")
+	assert.False(t, ok, "only the configured regex is accepted")
+}
+
+func TestOpenTSDBHandleCommand(t *testing.T) {
+	c := newOpenTSDB(nil, 12, nil).(*OpenTSDB)
+	c.Configure(map[string]interface{}{})
+
+	assert := assert.New(t)
+	var out bytes.Buffer
+	assert.True(c.handleCommand("version", &out))
+	assert.Contains(out.String(), "OpenTSDB")
+
+	out.Reset()
+	assert.True(c.handleCommand("put sys.cpu 1346846400 abc host=a", &out))
+	assert.Equal("put: invalid value: 'abc'\n", out.String())
+
+	out.Reset()
+	assert.True(c.handleCommand("rollup", &out))
+	assert.Equal("unknown command: rollup.  Try `help'.\n", out.String())
+
+	out.Reset()
+	c.writeStats(&out, time.Unix(1346846400, 0))
+	assert.Equal("tsd.rpc.received 1346846400 1 type=put\n"+
+		"tsd.rpc.received 1346846400 1 type=version\n"+
+		"tsd.rpc.errors 1346846400 1 type=invalid_values\n"+
+		"tsd.rpc.errors 1346846400 1 type=unknown_commands\n", out.String())
+	assert.Equal(map[string]uint64{openTSDBInvalidValues: 1, openTSDBUnknownCommands: 1}, c.Errors())
+
+	assert.False(c.handleCommand("exit", &out))
+}
+
+func TestOpenTSDBServePut(t *testing.T) {
+	c := newOpenTSDB(make(chan metric.Metric), 12, test_utils.BuildLogger()).(*OpenTSDB)
+	c.Configure(map[string]interface{}{})
+
+	received := make(chan metric.Metric, 10)
+	go func() {
+		for m := range c.incoming {
+			received <- m
+		}
+	}()
+
+	assert := assert.New(t)
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/put",
+		bytes.NewBufferString(`{"metric":"sys.cpu.nice","timestamp":1346846400,"value":18,"tags":{"host":"web01"}}`))
+	c.servePut(rec, req)
+	assert.Equal(http.StatusNoContent, rec.Code)
+	m := <-received
+	assert.Equal("sys.cpu.nice", m.Name)
+	assert.Equal(18.0, m.Value)
+
+	body := `[
+		{"metric":"sys.cpu.nice","timestamp":1346846400000,"value":"-1.5","tags":{"host":"web01"}},
+		{"metric":"sys.cpu.nice","timestamp":1346846400,"value":"x","tags":{"host":"web01"}},
+		{"metric":"sys.cpu.nice","timestamp":1346846400,"value":1}
+	]`
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/put?details", bytes.NewBufferString(body))
+	c.servePut(rec, req)
+	assert.Equal(http.StatusBadRequest, rec.Code)
+	m = <-received
+	assert.Equal(-1.5, m.Value)
+
+	var details struct {
+		Errors []struct {
+			Datapoint map[string]interface{} `json:"datapoint"`
+			Error     string                 `json:"error"`
+		} `json:"errors"`
+		Failed  int `json:"failed"`
+		Success int `json:"success"`
+	}
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &details))
+	assert.Equal(2, details.Failed)
+	assert.Equal(1, details.Success)
+	require.Equal(t, 2, len(details.Errors))
+	assert.Equal("x", details.Errors[0].Datapoint["value"])
+	assert.Equal("invalid value: 'x'", details.Errors[0].Error)
+
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/put?summary", bytes.NewBufferString(body))
+	c.servePut(rec, req)
+	<-received
+	assert.Equal(http.StatusBadRequest, rec.Code)
+	assert.JSONEq(`{"failed":2,"success":1}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/put", bytes.NewBufferString("{"))
+	c.servePut(rec, req)
+	assert.Equal(http.StatusBadRequest, rec.Code)
+	assert.Contains(rec.Body.String(), "Unable to parse")
+
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/put", nil)
+	c.servePut(rec, req)
+	assert.Equal(http.StatusMethodNotAllowed, rec.Code)
+
+	assert.Equal(map[string]uint64{
+		openTSDBInvalidValues:    2,
+		openTSDBIllegalArguments: 2,
+		openTSDBBadRequests:      2,
+	}, c.Errors())
+}
@@ -0,0 +1,286 @@
+package collector
+
+import (
+	"fullerite/config"
+	"fullerite/metric"
+
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	l "github.com/Sirupsen/logrus"
+)
+
+const prometheusAcceptHeader = "text/plain;version=0.0.4;q=1,*/*;q=0.1"
+
+// prometheusMaxLine is the longest exposition line which is parsed
+const prometheusMaxLine = 1024 * 1024
+
+// PrometheusScrape collector type.
+// targets are the /metrics URLs scraped every interval.
+// scrapeTimeout bounds a single scrape and is always shorter than the interval.
+type PrometheusScrape struct {
+	baseCollector
+	targets       []string
+	scrapeTimeout time.Duration
+	client        *http.Client
+}
+
+func init() {
+	RegisterCollector("PrometheusScrape", newPrometheusScrape)
+}
+
+// newPrometheusScrape creates a new PrometheusScrape collector.
+func newPrometheusScrape(channel chan metric.Metric, initialInterval int, log *l.Entry) Collector {
+	p := new(PrometheusScrape)
+
+	p.log = log
+	p.channel = channel
+	p.interval = initialInterval
+
+	p.name = "PrometheusScrape"
+	p.targets = []string{}
+	return p
+}
+
+// Configure takes a dictionary of values with which the collector can configure itself.
+func (p *PrometheusScrape) Configure(configMap map[string]interface{}) {
+	p.configureCommonParams(configMap)
+
+	if targets, exists := configMap["targets"]; exists {
+		p.targets = config.GetAsSlice(targets)
+	}
+
+	// a scrape has to finish before the next one starts
+	maxTimeout := time.Duration(p.interval)*time.Second - 500*time.Millisecond
+	if maxTimeout <= 0 {
+		maxTimeout = time.Duration(p.interval) * time.Second
+	}
+	p.scrapeTimeout = maxTimeout
+	if timeout, exists := configMap["scrapeTimeout"]; exists {
+		configured := time.Duration(config.GetAsFloat(timeout, maxTimeout.Seconds()) * float64(time.Second))
+		if configured > 0 && configured < maxTimeout {
+			p.scrapeTimeout = configured
+		} else if p.log != nil {
+			p.log.Warn("scrapeTimeout must be shorter than the interval, using ", maxTimeout)
+		}
+	}
+	p.client = &http.Client{Timeout: p.scrapeTimeout}
+}
+
+// Targets returns the configured scrape targets
+func (p *PrometheusScrape) Targets() []string {
+	return p.targets
+}
+
+// ScrapeTimeout returns the timeout applied to each scrape
+func (p *PrometheusScrape) ScrapeTimeout() time.Duration {
+	return p.scrapeTimeout
+}
+
+// Collect scrapes all targets in parallel and waits for them to finish.
+func (p *PrometheusScrape) Collect() {
+	var wg sync.WaitGroup
+	for _, target := range p.targets {
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+			for _, m := range p.scrapeTarget(target) {
+				p.Channel() <- m
+			}
+		}(target)
+	}
+	wg.Wait()
+}
+
+// scrapeTarget fetches one target and always reports whether it was reachable.
+func (p *PrometheusScrape) scrapeTarget(target string) []metric.Metric {
+	start := time.Now()
+	metrics, invalid, err := p.fetch(target)
+	duration := time.Since(start)
+
+	up := metric.WithValue("PrometheusScrapeUp", 1)
+	if err != nil {
+		p.log.Error("Failed to scrape ", target, ": ", err)
+		up.Value = 0
+	} else {
+		metrics = append(metrics, metric.WithValue("PrometheusScrapeInvalidLines", float64(invalid)))
+	}
+	metrics = append(metrics, up, metric.WithValue("PrometheusScrapeDuration", duration.Seconds()))
+	metric.AddToAll(&metrics, map[string]string{"target": target})
+	return metrics
+}
+
+func (p *PrometheusScrape) fetch(target string) ([]metric.Metric, int, error) {
+	req, err := http.NewRequest("GET", target, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Accept", prometheusAcceptHeader)
+
+	rsp, err := p.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rsp.Body.Close()
+
+	body, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	if rsp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("unexpected status code %d", rsp.StatusCode)
+	}
+	return parsePrometheusText(body, time.Now(), p.log.WithField("target", target))
+}
+
+// parsePrometheusText converts the Prometheus text exposition format into metrics.
+// Counters become cumulative counters and gauges/untyped samples gauges.
+// Histogram buckets and summary quantiles keep their le/quantile labels as
+// dimensions, _sum and _count series are cumulative counters. Invalid lines
+// are logged, skipped and counted.
+func parsePrometheusText(body []byte, now time.Time, log *l.Entry) ([]metric.Metric, int, error) {
+	types := map[string]string{}
+	metrics := []metric.Metric{}
+	invalid := 0
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), prometheusMaxLine)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			fields := strings.Fields(line)
+			if len(fields) >= 4 && fields[1] == "TYPE" {
+				types[fields[2]] = fields[3]
+			}
+			continue
+		}
+
+		name, labels, value, ts, err := parsePrometheusSample(line)
+		if err != nil {
+			log.Warn("Skipping line ", lineNo, ": ", err)
+			invalid++
+			continue
+		}
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			continue
+		}
+
+		m := metric.New(name)
+		m.MetricType = prometheusMetricType(name, types)
+		m.Value = value
+		m.AddDimensions(labels)
+		if ts != nil {
+			m.SetTime(*ts)
+		} else {
+			m.SetTime(now)
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, invalid, scanner.Err()
+}
+
+// prometheusMetricType maps a sample to a fullerite metric type using the
+// TYPE of the family it belongs to.
+func prometheusMetricType(name string, types map[string]string) string {
+	switch types[name] {
+	case "counter":
+		return metric.CumulativeCounter
+	case "gauge", "untyped", "summary":
+		return metric.Gauge
+	}
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		if !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		switch types[strings.TrimSuffix(name, suffix)] {
+		case "histogram", "summary":
+			return metric.CumulativeCounter
+		}
+	}
+	return metric.Gauge
+}
+
+// parsePrometheusSample parses `name{label="value",...} value [timestamp]`.
+func parsePrometheusSample(line string) (string, map[string]string, float64, *time.Time, error) {
+	labels := map[string]string{}
+
+	nameEnd := strings.IndexAny(line, "{ \t")
+	if nameEnd <= 0 {
+		return "", nil, 0, nil, fmt.Errorf("invalid sample %q", line)
+	}
+	name := line[:nameEnd]
+	rest := line[nameEnd:]
+
+	if strings.HasPrefix(rest, "{") {
+		var err error
+		if labels, rest, err = parsePrometheusLabels(rest[1:]); err != nil {
+			return "", nil, 0, nil, err
+		}
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) < 1 || len(fields) > 2 {
+		return "", nil, 0, nil, fmt.Errorf("invalid sample %q", line)
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return "", nil, 0, nil, fmt.Errorf("invalid value in %q", line)
+	}
+	if len(fields) == 1 {
+		return name, labels, value, nil, nil
+	}
+	ms, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return "", nil, 0, nil, fmt.Errorf("invalid timestamp in %q", line)
+	}
+	ts := time.Unix(0, ms*int64(time.Millisecond))
+	return name, labels, value, &ts, nil
+}
+
+// parsePrometheusLabels reads labels up to the closing brace and returns the remainder.
+func parsePrometheusLabels(s string) (map[string]string, string, error) {
+	labels := map[string]string{}
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if strings.HasPrefix(s, "}") {
+			return labels, s[1:], nil
+		}
+		eq := strings.Index(s, "=")
+		if eq <= 0 || len(s) < eq+2 || s[eq+1] != '"' {
+			return nil, "", fmt.Errorf("invalid label set near %q", s)
+		}
+		key := strings.TrimSpace(s[:eq])
+
+		var value bytes.Buffer
+		i := eq + 2
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+				switch s[i] {
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					value.WriteByte(s[i])
+				}
+				continue
+			}
+			value.WriteByte(s[i])
+		}
+		if i >= len(s) {
+			return nil, "", fmt.Errorf("unterminated label value for %q", key)
+		}
+		labels[key] = value.String()
+		s = s[i+1:]
+	}
+}
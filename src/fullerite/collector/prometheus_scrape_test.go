@@ -0,0 +1,212 @@
+package collector
+
+import (
+	"fullerite/metric"
+	"test_utils"
+
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPrometheusExposition = `# HELP http_requests_total The total number of HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{method="post",code="200"} 1027 1395066363000
+http_requests_total{method="post",code="400"}    3 1395066363000
+
+# A gauge without timestamp and an escaped label value
+# TYPE queue_depth gauge
+queue_depth{path="C:\\DIR\\FILE.TXT",error="Cannot find \"file\""} 42
+
+untyped_thing 7
+nan_gauge NaN
+
+# TYPE request_duration_seconds histogram
+request_duration_seconds_bucket{le="0.05"} 24054
+request_duration_seconds_bucket{le="+Inf"} 144320
+request_duration_seconds_sum 53423
+request_duration_seconds_count 144320
+
+# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.5"} 4773
+rpc_duration_seconds{quantile="0.99"} 76656
+rpc_duration_seconds_sum 1.7560473e+07
+rpc_duration_seconds_count 2693
+`
+
+func findMetric(metrics []metric.Metric, name string, dims map[string]string) (metric.Metric, bool) {
+	for _, m := range metrics {
+		if m.Name == name && m.IsSubDim(dims) {
+			return m, true
+		}
+	}
+	return metric.Metric{}, false
+}
+
+func TestPrometheusScrapeConfigure(t *testing.T) {
+	config := map[string]interface{}{
+		"interval":      5,
+		"targets":       []interface{}{"http://localhost:9100/metrics", "http://localhost:9200/metrics"},
+		"scrapeTimeout": "2",
+	}
+
+	p := newPrometheusScrape(nil, 12, nil).(*PrometheusScrape)
+	p.Configure(config)
+
+	assert := assert.New(t)
+	assert.Equal(5, p.Interval())
+	assert.Equal(2, len(p.Targets()))
+	assert.Equal(2*time.Second, p.ScrapeTimeout())
+}
+
+func TestPrometheusScrapeTimeoutShorterThanInterval(t *testing.T) {
+	config := map[string]interface{}{
+		"interval":      5,
+		"scrapeTimeout": 30,
+	}
+
+	p := newPrometheusScrape(nil, 12, nil).(*PrometheusScrape)
+	p.Configure(config)
+	assert.True(t, p.ScrapeTimeout() < 5*time.Second, "timeout should be capped by the interval")
+
+	p = newPrometheusScrape(nil, 12, nil).(*PrometheusScrape)
+	p.Configure(map[string]interface{}{})
+	assert.True(t, p.ScrapeTimeout() < 12*time.Second, "default timeout should be shorter than the interval")
+}
+
+func TestParsePrometheusText(t *testing.T) {
+	now := time.Now()
+	metrics, invalid, err := parsePrometheusText([]byte(testPrometheusExposition), now, defaultLog)
+	require.Nil(t, err)
+	assert := assert.New(t)
+	assert.Equal(0, invalid)
+
+	_, found := findMetric(metrics, "nan_gauge", nil)
+	assert.False(found, "NaN samples should be dropped")
+
+	m, found := findMetric(metrics, "http_requests_total", map[string]string{"code": "400"})
+	require.True(t, found)
+	assert.Equal(metric.CumulativeCounter, m.MetricType)
+	assert.Equal(3.0, m.Value)
+	assert.Equal("post", m.Dimensions["method"])
+	assert.Equal(time.Unix(1395066363, 0), m.Time)
+
+	m, found = findMetric(metrics, "queue_depth", nil)
+	require.True(t, found)
+	assert.Equal(metric.Gauge, m.MetricType)
+	assert.Equal(`C-\DIR\FILE.TXT`, m.Dimensions["path"])
+	assert.Equal(`Cannot find "file"`, m.Dimensions["error"])
+	assert.Equal(now, m.Time)
+
+	m, found = findMetric(metrics, "untyped_thing", nil)
+	require.True(t, found)
+	assert.Equal(metric.Gauge, m.MetricType)
+
+	m, found = findMetric(metrics, "request_duration_seconds_bucket", map[string]string{"le": "+Inf"})
+	require.True(t, found)
+	assert.Equal(metric.CumulativeCounter, m.MetricType)
+	assert.Equal(144320.0, m.Value)
+	m, found = findMetric(metrics, "request_duration_seconds_count", nil)
+	require.True(t, found)
+	assert.Equal(metric.CumulativeCounter, m.MetricType)
+
+	m, found = findMetric(metrics, "rpc_duration_seconds", map[string]string{"quantile": "0.99"})
+	require.True(t, found)
+	assert.Equal(metric.Gauge, m.MetricType)
+	assert.Equal(76656.0, m.Value)
+	m, found = findMetric(metrics, "rpc_duration_seconds_sum", nil)
+	require.True(t, found)
+	assert.Equal(metric.CumulativeCounter, m.MetricType)
+	assert.Equal(1.7560473e+07, m.Value)
+}
+
+func TestParsePrometheusTextInvalid(t *testing.T) {
+	body := strings.Join([]string{
+		"metric{label=\"unterminated} 1",
+		"metric not_a_number",
+		"metric 1 not_a_timestamp",
+		"{label=\"x\"} 1",
+		"valid 2",
+	}, "\n")
+	metrics, invalid, err := parsePrometheusText([]byte(body), time.Now(), defaultLog)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, invalid)
+	require.Equal(t, 1, len(metrics), "lines after invalid ones are parsed")
+	assert.Equal(t, "valid", metrics[0].Name)
+}
+
+func TestParsePrometheusTextLongLine(t *testing.T) {
+	body := "long{label=\"" + strings.Repeat("x", 100000) + "\"} 1\nafter 2\n"
+	metrics, invalid, err := parsePrometheusText([]byte(body), time.Now(), defaultLog)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, invalid)
+	assert.Equal(t, 2, len(metrics))
+}
+
+func TestPrometheusScrapeCollect(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Contains(t, r.Header.Get("Accept"), "text/plain")
+		fmt.Fprint(w, testPrometheusExposition)
+	}))
+	defer ts.Close()
+
+	testChannel := make(chan metric.Metric)
+	p := newPrometheusScrape(testChannel, 10, test_utils.BuildLogger()).(*PrometheusScrape)
+	p.Configure(map[string]interface{}{"targets": []string{ts.URL}})
+
+	go func() {
+		p.Collect()
+		close(testChannel)
+	}()
+
+	metrics := []metric.Metric{}
+	for m := range testChannel {
+		metrics = append(metrics, m)
+	}
+
+	target := map[string]string{"target": metric.New(ts.URL).Name}
+	m, found := findMetric(metrics, "PrometheusScrapeUp", target)
+	require.True(t, found)
+	assert.Equal(t, 1.0, m.Value)
+	_, found = findMetric(metrics, "queue_depth", target)
+	assert.True(t, found, "every metric should carry the target dimension")
+	m, found = findMetric(metrics, "PrometheusScrapeInvalidLines", target)
+	require.True(t, found)
+	assert.Equal(t, 0.0, m.Value)
+}
+
+func TestPrometheusScrapeCollectTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+		fmt.Fprint(w, testPrometheusExposition)
+	}))
+	defer ts.Close()
+
+	testChannel := make(chan metric.Metric)
+	p := newPrometheusScrape(testChannel, 10, test_utils.BuildLogger()).(*PrometheusScrape)
+	p.Configure(map[string]interface{}{
+		"targets":       []string{ts.URL},
+		"scrapeTimeout": "0.1",
+	})
+
+	go func() {
+		p.Collect()
+		close(testChannel)
+	}()
+
+	metrics := []metric.Metric{}
+	for m := range testChannel {
+		metrics = append(metrics, m)
+	}
+
+	m, found := findMetric(metrics, "PrometheusScrapeUp", nil)
+	require.True(t, found)
+	assert.Equal(t, 0.0, m.Value)
+	assert.Equal(t, 2, len(metrics), "only up and duration should be reported")
+}
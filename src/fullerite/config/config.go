@@ -22,6 +22,7 @@ type Config struct {
 	Collectors            []string                          `json:"collectors"`
 	DefaultDimensions     map[string]string                 `json:"defaultDimensions"`
 	InternalServerConfig  map[string]interface{}            `json:"internalServer"`
+	RelabelRules          []interface{}                     `json:"relabelRules"`
 }
 
 // ReadConfig reads a fullerite configuration file
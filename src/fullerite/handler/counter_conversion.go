@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"fullerite/config"
+	"fullerite/metric"
+
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The ways a handler can convert cumulative counters
+const (
+	counterConversionRate  = "rate"
+	counterConversionDelta = "delta"
+)
+
+// DefaultCounterStaleAfter is the number of seconds after which a series
+// which was not seen is forgotten
+const DefaultCounterStaleAfter = 600
+
+// counterSample is the last value seen for a series
+type counterSample struct {
+	value float64
+	time  time.Time
+}
+
+// counterConverter turns cumulative counters into per second rates (gauges)
+// or into the increase since the previous sample (counters). The first
+// sample of a series only sets the baseline and is not emitted. A value
+// below the previous one is taken as a reset of the counter to zero.
+//
+// Series which were not seen for staleAfter are evicted, the next sample
+// of such a series is handled as a first sample again.
+type counterConverter struct {
+	mode       string
+	staleAfter time.Duration
+
+	mu         sync.Mutex
+	last       map[string]counterSample
+	lastSweep  time.Time
+	suppressed uint64
+	resets     uint64
+	evicted    uint64
+}
+
+func newCounterConverter(mode string, staleAfter time.Duration) *counterConverter {
+	return &counterConverter{
+		mode:       mode,
+		staleAfter: staleAfter,
+		last:       make(map[string]counterSample),
+		lastSweep:  time.Now(),
+	}
+}
+
+// parseCounterConverter reads the counterConversion and counterStaleAfter
+// options, it returns nil if cumulative counters are left untouched.
+func parseCounterConverter(configMap map[string]interface{}) *counterConverter {
+	asInterface, exists := configMap["counterConversion"]
+	if !exists {
+		return nil
+	}
+	mode, _ := asInterface.(string)
+	switch mode {
+	case counterConversionRate, counterConversionDelta:
+	case "", "none":
+		return nil
+	default:
+		defaultLog.Warn("Unknown counterConversion ", mode, ", expected rate or delta")
+		return nil
+	}
+
+	staleAfter := DefaultCounterStaleAfter
+	if asInterface, exists := configMap["counterStaleAfter"]; exists {
+		staleAfter = config.GetAsInt(asInterface, DefaultCounterStaleAfter)
+	}
+	return newCounterConverter(mode, time.Duration(staleAfter)*time.Second)
+}
+
+// convert returns the converted metric and false if it should not be
+// emitted. Metrics which are not cumulative counters are returned as is.
+func (c *counterConverter) convert(m metric.Metric) (metric.Metric, bool) {
+	if c == nil || m.MetricType != metric.CumulativeCounter {
+		return m, true
+	}
+	now := m.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+	key := seriesKey(m)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.evictStale(now)
+	previous, seen := c.last[key]
+	if seen && now.Before(previous.time) {
+		// an out of order sample would turn into a negative rate
+		c.suppressed++
+		return m, false
+	}
+	if seen && c.staleAfter > 0 && now.Sub(previous.time) >= c.staleAfter {
+		// not swept yet, the old baseline is stale all the same
+		seen = false
+		c.evicted++
+	}
+	c.last[key] = counterSample{value: m.Value, time: now}
+	if !seen {
+		c.suppressed++
+		return m, false
+	}
+
+	delta := m.Value - previous.value
+	if delta < 0 {
+		c.resets++
+		delta = m.Value
+	}
+
+	if c.mode == counterConversionDelta {
+		m.MetricType = metric.Counter
+		m.Value = delta
+		return m, true
+	}
+	elapsed := now.Sub(previous.time).Seconds()
+	if elapsed <= 0 {
+		c.suppressed++
+		return m, false
+	}
+	m.MetricType = metric.Gauge
+	m.Value = delta / elapsed
+	return m, true
+}
+
+// evictStale frees the series not seen for staleAfter, the map is swept at
+// most once per staleAfter. convert checks the age of a baseline itself.
+func (c *counterConverter) evictStale(now time.Time) {
+	if c.staleAfter <= 0 || now.Sub(c.lastSweep) < c.staleAfter {
+		return
+	}
+	for key, sample := range c.last {
+		if now.Sub(sample.time) >= c.staleAfter {
+			delete(c.last, key)
+			c.evicted++
+		}
+	}
+	c.lastSweep = now
+}
+
+// internalMetrics adds the converter's counters and the number of tracked series
+func (c *counterConverter) internalMetrics(counters, gauges map[string]float64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counters["counterConversion.suppressed"] = float64(c.suppressed)
+	counters["counterConversion.resets"] = float64(c.resets)
+	counters["counterConversion.evicted"] = float64(c.evicted)
+	gauges["counterConversion.series"] = float64(len(c.last))
+}
+
+// seriesKey identifies a series by its name and sorted dimensions
+func seriesKey(m metric.Metric) string {
+	dims := make([]string, 0, len(m.Dimensions))
+	for k, v := range m.Dimensions {
+		dims = append(dims, k+"="+v)
+	}
+	sort.Strings(dims)
+	return m.Name + "|" + strings.Join(dims, ",")
+}
@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"fullerite/metric"
+
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func cumulative(name string, value float64, at time.Time) metric.Metric {
+	return metric.NewExt(name, metric.CumulativeCounter, value, map[string]string{"container": "a"}, at, false)
+}
+
+func TestParseCounterConverter(t *testing.T) {
+	assert.Nil(t, parseCounterConverter(map[string]interface{}{}))
+	assert.Nil(t, parseCounterConverter(map[string]interface{}{"counterConversion": "none"}))
+	assert.Nil(t, parseCounterConverter(map[string]interface{}{"counterConversion": "derivative"}))
+
+	c := parseCounterConverter(map[string]interface{}{"counterConversion": "rate"})
+	require.NotNil(t, c)
+	assert.Equal(t, counterConversionRate, c.mode)
+	assert.Equal(t, DefaultCounterStaleAfter*time.Second, c.staleAfter)
+
+	c = parseCounterConverter(map[string]interface{}{"counterConversion": "delta", "counterStaleAfter": "30"})
+	require.NotNil(t, c)
+	assert.Equal(t, counterConversionDelta, c.mode)
+	assert.Equal(t, 30*time.Second, c.staleAfter)
+}
+
+func TestCounterConverterRate(t *testing.T) {
+	c := newCounterConverter(counterConversionRate, time.Minute)
+	start := time.Now()
+
+	_, keep := c.convert(cumulative("DockerTxBytes", 100, start))
+	assert.False(t, keep, "the first sample is suppressed")
+
+	m, keep := c.convert(cumulative("DockerTxBytes", 300, start.Add(10*time.Second)))
+	require.True(t, keep)
+	assert.Equal(t, metric.Gauge, m.MetricType)
+	assert.Equal(t, 20.0, m.Value)
+	assert.Equal(t, "a", m.Dimensions["container"])
+
+	// a reset counts from zero
+	m, keep = c.convert(cumulative("DockerTxBytes", 50, start.Add(20*time.Second)))
+	require.True(t, keep)
+	assert.Equal(t, 5.0, m.Value)
+
+	_, keep = c.convert(cumulative("DockerTxBytes", 60, start.Add(20*time.Second)))
+	assert.False(t, keep, "no rate without elapsed time")
+	_, keep = c.convert(cumulative("DockerTxBytes", 70, start.Add(15*time.Second)))
+	assert.False(t, keep, "out of order samples are suppressed")
+
+	counters := map[string]float64{}
+	gauges := map[string]float64{}
+	c.internalMetrics(counters, gauges)
+	assert.Equal(t, map[string]float64{
+		"counterConversion.suppressed": 3,
+		"counterConversion.resets":     1,
+		"counterConversion.evicted":    0,
+	}, counters)
+	assert.Equal(t, map[string]float64{"counterConversion.series": 1}, gauges)
+}
+
+func TestCounterConverterDelta(t *testing.T) {
+	c := newCounterConverter(counterConversionDelta, time.Minute)
+	start := time.Now()
+
+	_, keep := c.convert(cumulative("DockerRxBytes", 100, start))
+	assert.False(t, keep)
+	m, keep := c.convert(cumulative("DockerRxBytes", 250, start.Add(time.Second)))
+	require.True(t, keep)
+	assert.Equal(t, metric.Counter, m.MetricType)
+	assert.Equal(t, 150.0, m.Value)
+
+	// series are told apart by their dimensions
+	other := cumulative("DockerRxBytes", 1000, start.Add(time.Second))
+	other.Dimensions = map[string]string{"container": "b"}
+	_, keep = c.convert(other)
+	assert.False(t, keep)
+}
+
+func TestCounterConverterPassesOtherTypes(t *testing.T) {
+	c := newCounterConverter(counterConversionRate, time.Minute)
+	gauge := metric.WithValue("load", 3)
+	m, keep := c.convert(gauge)
+	assert.True(t, keep)
+	assert.Equal(t, gauge, m)
+
+	var disabled *counterConverter
+	m, keep = disabled.convert(cumulative("DockerTxBytes", 1, time.Now()))
+	assert.True(t, keep)
+	assert.Equal(t, metric.CumulativeCounter, m.MetricType)
+}
+
+func TestCounterConverterEvictsStaleSeries(t *testing.T) {
+	c := newCounterConverter(counterConversionDelta, time.Minute)
+	start := time.Now()
+
+	c.convert(cumulative("gone", 1, start))
+	c.convert(cumulative("DockerTxBytes", 1, start))
+	_, keep := c.convert(cumulative("DockerTxBytes", 2, start.Add(50*time.Second)))
+	assert.True(t, keep)
+
+	_, keep = c.convert(cumulative("DockerTxBytes", 3, start.Add(2*time.Minute)))
+	assert.False(t, keep, "a stale series starts over")
+	assert.Equal(t, uint64(2), c.evicted)
+	assert.Equal(t, 1, len(c.last))
+}
+
+func TestCounterConverterStaleBeforeSweep(t *testing.T) {
+	c := newCounterConverter(counterConversionDelta, time.Minute)
+	start := c.lastSweep
+
+	c.convert(cumulative("DockerTxBytes", 1, start.Add(30*time.Second)))
+	// sweeps the map while DockerTxBytes is not stale yet
+	c.convert(cumulative("other", 1, start.Add(time.Minute)))
+
+	_, keep := c.convert(cumulative("DockerTxBytes", 5, start.Add(100*time.Second)))
+	assert.False(t, keep, "a stale baseline is not used before the next sweep either")
+	assert.Equal(t, uint64(1), c.evicted)
+
+	m, keep := c.convert(cumulative("DockerTxBytes", 7, start.Add(110*time.Second)))
+	assert.True(t, keep)
+	assert.Equal(t, 2.0, m.Value)
+}
+
+func TestHandlerRunConvertsCounters(t *testing.T) {
+	base := BaseHandler{}
+	base.log = defaultLog
+	base.interval = 1
+	base.maxBufferSize = 1
+	base.channel = make(chan metric.Metric)
+	base.configureCommonParams(map[string]interface{}{"counterConversion": "delta"})
+
+	emitted := make(chan metric.Metric, 2)
+	// run only starts the listeners, calling it directly keeps its reads
+	// of the handler ahead of the first emission
+	base.run(func(metrics []metric.Metric) bool {
+		for _, m := range metrics {
+			emitted <- m
+		}
+		return true
+	})
+
+	start := time.Now()
+	base.channel <- cumulative("DockerTxBytes", 10, start)
+	base.channel <- cumulative("DockerTxBytes", 15, start.Add(time.Second))
+
+	select {
+	case m := <-emitted:
+		assert.Equal(t, 5.0, m.Value)
+		assert.Equal(t, metric.Counter, m.MetricType)
+	case <-time.After(2 * time.Second):
+		t.Fatal("the converted counter was not emitted")
+	}
+	// InternalMetrics copies the handler while emissions update it, the
+	// converter guards its own counters
+	counters := make(map[string]float64)
+	base.counterConverter.internalMetrics(counters, make(map[string]float64))
+	assert.Equal(t, 1.0, counters["counterConversion.suppressed"])
+	base.channel <- metric.Metric{}
+}
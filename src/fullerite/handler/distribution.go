@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"fullerite/config"
+	"fullerite/metric"
+
+	"strconv"
+	"strings"
+)
+
+// DefaultPercentiles are rendered for histograms and timers unless a
+// handler configures its own percentiles
+var DefaultPercentiles = []float64{50, 75, 90, 95, 99}
+
+// distributionStat is one value derived from a distribution
+type distributionStat struct {
+	suffix     string
+	metricType string
+	value      float64
+}
+
+// distributionStats returns the count, sum, mean, the min and max of
+// samples and the percentiles of d
+func distributionStats(d *metric.Distribution, percentiles []float64) []distributionStat {
+	stats := []distributionStat{
+		{"count", metric.Counter, float64(d.Count)},
+		{"sum", metric.Counter, d.Sum},
+		{"mean", metric.Gauge, d.Mean()},
+	}
+	if min, ok := d.Min(); ok {
+		stats = append(stats, distributionStat{"min", metric.Gauge, min})
+	}
+	if max, ok := d.Max(); ok {
+		stats = append(stats, distributionStat{"max", metric.Gauge, max})
+	}
+	if d.Count == 0 {
+		return stats
+	}
+	for _, p := range percentiles {
+		stats = append(stats, distributionStat{percentileName(p), metric.Gauge, d.Quantile(p / 100)})
+	}
+	return stats
+}
+
+// percentileName names a percentile p50, p99, p99_9 etc.
+func percentileName(p float64) string {
+	return "p" + strings.Replace(strconv.FormatFloat(p, 'f', -1, 64), ".", "_", -1)
+}
+
+// expandDistribution renders a histogram or timer as one metric per stat,
+// called name.count, name.p99 etc., for backends with single valued
+// series. Other metrics are returned as is.
+func expandDistribution(m metric.Metric, percentiles []float64) []metric.Metric {
+	if !m.IsDistribution() {
+		return []metric.Metric{m}
+	}
+	stats := distributionStats(m.Distribution, percentiles)
+	expanded := make([]metric.Metric, 0, len(stats))
+	for _, stat := range stats {
+		sub := m
+		sub.Name = m.Name + "." + stat.suffix
+		sub.MetricType = stat.metricType
+		sub.Value = stat.value
+		sub.Distribution = nil
+		expanded = append(expanded, sub)
+	}
+	return expanded
+}
+
+// distributionFields renders a histogram or timer as a field per stat for
+// backends with multi valued points
+func distributionFields(d *metric.Distribution, percentiles []float64) map[string]interface{} {
+	fields := make(map[string]interface{})
+	for _, stat := range distributionStats(d, percentiles) {
+		fields[stat.suffix] = stat.value
+	}
+	return fields
+}
+
+// parsePercentiles reads a list of percentiles between 0 and 100
+func parsePercentiles(value interface{}) []float64 {
+	list, ok := value.([]interface{})
+	if !ok {
+		defaultLog.Warn("Expected a list of percentiles but got ", value)
+		return DefaultPercentiles
+	}
+	percentiles := []float64{}
+	for _, item := range list {
+		p := config.GetAsFloat(item, -1)
+		if p < 0 || p > 100 {
+			defaultLog.Warn("Skipping percentile ", item, ", expected a number between 0 and 100")
+			continue
+		}
+		percentiles = append(percentiles, p)
+	}
+	return percentiles
+}
+
+// Percentiles : the percentiles rendered for histograms and timers
+func (base BaseHandler) Percentiles() []float64 {
+	if base.percentiles == nil {
+		return DefaultPercentiles
+	}
+	return base.percentiles
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"fullerite/metric"
+
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func timerMetric() metric.Metric {
+	r := metric.NewTimerRecorder()
+	for _, v := range []float64{1, 2, 3, 4, 5} {
+		r.Observe(v)
+	}
+	m := r.Flush("request_time")
+	m.AddDimension("service", "api")
+	return m
+}
+
+func TestPercentileName(t *testing.T) {
+	assert.Equal(t, "p50", percentileName(50))
+	assert.Equal(t, "p99_9", percentileName(99.9))
+}
+
+func TestExpandDistribution(t *testing.T) {
+	expanded := expandDistribution(timerMetric(), []float64{50, 100})
+
+	values := map[string]float64{}
+	for _, m := range expanded {
+		assert.Nil(t, m.Distribution)
+		assert.Equal(t, "api", m.Dimensions["service"])
+		values[m.Name] = m.Value
+	}
+	assert.Equal(t, map[string]float64{
+		"request_time.count": 5,
+		"request_time.sum":   15,
+		"request_time.mean":  3,
+		"request_time.min":   1,
+		"request_time.max":   5,
+		"request_time.p50":   3,
+		"request_time.p100":  5,
+	}, values)
+	assert.Equal(t, metric.Counter, expanded[0].MetricType)
+
+	gauge := metric.WithValue("load", 1)
+	assert.Equal(t, []metric.Metric{gauge}, expandDistribution(gauge, DefaultPercentiles))
+}
+
+func TestDistributionFields(t *testing.T) {
+	r := metric.NewHistogramRecorder(10)
+	fields := distributionFields(r.Flush("empty").Distribution, DefaultPercentiles)
+	assert.Equal(t, map[string]interface{}{"count": 0.0, "sum": 0.0, "mean": 0.0}, fields,
+		"percentiles of an empty distribution are left out")
+}
+
+func TestParsePercentiles(t *testing.T) {
+	base := BaseHandler{}
+	assert.Equal(t, DefaultPercentiles, base.Percentiles())
+
+	base.configureCommonParams(map[string]interface{}{
+		"percentiles": []interface{}{50.0, "99.9", 150.0},
+	})
+	assert.Equal(t, []float64{50, 99.9}, base.Percentiles())
+}
+
+func TestGraphiteEmitsPercentiles(t *testing.T) {
+	g := getTestGraphiteHandler(12, 13, 14)
+	g.Configure(map[string]interface{}{"server": "localhost", "port": 2003, "percentiles": []interface{}{90.0}})
+
+	var lines []string
+	for _, m := range expandDistribution(timerMetric(), g.Percentiles()) {
+		lines = append(lines, g.convertToGraphite(m))
+	}
+	assert.Equal(t, 6, len(lines))
+	assert.True(t, strings.HasPrefix(lines[5], "request_time.p90.service.api 4.600000 "), lines[5])
+}
+
+func TestConvertDistributionToInfluxDB(t *testing.T) {
+	i := getTestInfluxDBHandler(12, 13, 14)
+	i.Configure(map[string]interface{}{"server": "localhost", "port": "8086", "percentiles": []interface{}{50.0}})
+
+	point := i.convertToInfluxDB(timerMetric()).String()
+	assert.Contains(t, point, "count=5")
+	assert.Contains(t, point, "p50=3")
+	assert.Contains(t, point, "max=5")
+	assert.NotContains(t, point, "value=")
+}
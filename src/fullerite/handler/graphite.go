@@ -2,8 +2,9 @@ package handler
 
 import (
 	"fmt"
+	"fullerite/config"
 	"fullerite/metric"
-	"net"
+	"regexp"
 	"sort"
 	"strings"
 	"time"
@@ -15,12 +16,35 @@ func init() {
 	RegisterHandler("Graphite", newGraphite)
 }
 
+// The formats the Graphite handler writes metric paths in
+const (
+	graphiteModePath     = "path"
+	graphiteModeTagged   = "tagged"
+	graphiteModeTemplate = "template"
+)
+
+// graphiteTemplatePlaceholder is {name} or {dimension} in a template
+var graphiteTemplatePlaceholder = regexp.MustCompile(`\{([^{}]+)\}`)
+
 // Graphite type
 type Graphite struct {
 	BaseHandler
 	server     string
 	port       string
 	prefixKeys bool
+
+	// mode selects how dimensions are written:
+	//
+	//	path      name.key1.value1.key2.value2, sorted by key
+	//	tagged    name;key1=value1;key2=value2, Graphite 1.1 tagged series
+	//	template  the segments of template, see graphitePathFromTemplate
+	mode     string
+	template []string
+
+	// protocol is tcp or udp for plaintext or pickle for pickle over tcp
+	protocol        string
+	maxPacketSize   int
+	pickleBatchSize int
 }
 
 // newGraphite returns a new Graphite handler.
@@ -39,6 +63,11 @@ func newGraphite(
 	inst.timeout = initialTimeout
 	inst.log = log
 	inst.channel = channel
+	inst.sanitizer = graphiteSanitizePolicy()
+	inst.mode = graphiteModePath
+	inst.protocol = graphiteProtocolTCP
+	inst.maxPacketSize = DefaultGraphiteMaxPacketSize
+	inst.pickleBatchSize = DefaultGraphitePickleBatchSize
 
 	return inst
 }
@@ -69,6 +98,53 @@ func (g *Graphite) Configure(configMap map[string]interface{}) {
 	if prefixKeys, exists := configMap["prefixKeys"]; exists {
 		g.prefixKeys = prefixKeys.(bool)
 	}
+
+	g.mode = graphiteModePath
+	if mode, exists := configMap["mode"]; exists {
+		switch mode {
+		case graphiteModePath, graphiteModeTagged:
+			g.mode = mode.(string)
+		case graphiteModeTemplate:
+			template, _ := configMap["template"].(string)
+			if !strings.Contains(template, "{name}") {
+				g.log.Error("The template ", template, " has no {name}, using path mode")
+				break
+			}
+			g.mode = graphiteModeTemplate
+			g.template = strings.Split(template, ".")
+		default:
+			g.log.Error("Unknown mode ", mode, ", using path mode")
+		}
+	}
+	if g.prefixKeys && g.mode != graphiteModePath {
+		g.log.Error("prefixKeys only applies to the path mode, it is ignored in ", g.mode, " mode")
+	}
+	g.protocol = graphiteProtocolTCP
+	if protocol, exists := configMap["protocol"]; exists {
+		switch protocol {
+		case graphiteProtocolTCP, graphiteProtocolUDP, graphiteProtocolPickle:
+			g.protocol = protocol.(string)
+		default:
+			g.log.Error("Unknown protocol ", protocol, ", using tcp")
+		}
+	}
+	g.maxPacketSize = DefaultGraphiteMaxPacketSize
+	if maxPacketSize, exists := configMap["maxPacketSize"]; exists {
+		g.maxPacketSize = config.GetAsInt(maxPacketSize, DefaultGraphiteMaxPacketSize)
+	}
+	g.pickleBatchSize = DefaultGraphitePickleBatchSize
+	if pickleBatchSize, exists := configMap["pickleBatchSize"]; exists {
+		g.pickleBatchSize = config.GetAsInt(pickleBatchSize, DefaultGraphitePickleBatchSize)
+		if g.pickleBatchSize <= 0 {
+			g.pickleBatchSize = DefaultGraphitePickleBatchSize
+		}
+	}
+
+	g.sanitizer = graphiteSanitizePolicy()
+	if g.mode == graphiteModeTagged {
+		// tag values may contain dots
+		g.sanitizer = graphiteTaggedSanitizePolicy()
+	}
 	g.configureCommonParams(configMap)
 }
 
@@ -77,25 +153,81 @@ func (g *Graphite) Run() {
 	g.run(g.emitMetrics)
 }
 
+// Protocol returns the transport the datapoints are sent with
+func (g Graphite) Protocol() string {
+	return g.protocol
+}
+
 func (g Graphite) convertToGraphite(incomingMetric metric.Metric) (datapoint string) {
+	return g.graphiteDatapoint(incomingMetric).plaintext()
+}
+
+func (g Graphite) graphiteDatapoint(incomingMetric metric.Metric) graphiteDatapoint {
+	return graphiteDatapoint{
+		path:      g.graphitePath(incomingMetric),
+		value:     incomingMetric.Value,
+		timestamp: incomingMetric.GetTime().Unix(),
+	}
+}
+
+// graphitePath returns the prefixed path of a metric in the configured mode
+func (g Graphite) graphitePath(incomingMetric metric.Metric) string {
 	//orders dimensions so datapoint keeps consistent name
 	var keys []string
-	dimensions := incomingMetric.GetDimensions(g.DefaultDimensions())
+	name, dimensions := g.sanitizer.apply(incomingMetric.Name, incomingMetric.GetDimensions(g.DefaultDimensions()))
 	for k := range dimensions {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
 
+	switch g.mode {
+	case graphiteModeTagged:
+		path := g.Prefix() + name
+		for _, key := range keys {
+			path = fmt.Sprintf("%s;%s=%s", path, key, dimensions[key])
+		}
+		return path
+	case graphiteModeTemplate:
+		return g.Prefix() + graphitePathFromTemplate(g.template, name, dimensions)
+	}
+
+	var path string
 	if g.prefixKeys && len(keys) > 0 {
-		datapoint = g.Prefix() + strings.Join(keys, "_") + "." + incomingMetric.Name
+		path = g.Prefix() + strings.Join(keys, "_") + "." + name
 	} else {
-		datapoint = g.Prefix() + incomingMetric.Name
+		path = g.Prefix() + name
 	}
 	for _, key := range keys {
-		datapoint = fmt.Sprintf("%s.%s.%s", datapoint, key, dimensions[key])
+		path = fmt.Sprintf("%s.%s.%s", path, key, dimensions[key])
+	}
+	return path
+}
+
+// graphitePathFromTemplate expands the {name} and {dimension} placeholders
+// of the template segments. Segments referring to a dimension the metric
+// does not have are left out, dimensions the template does not refer to
+// are dropped. e.g. the template servers.{host}.{collector}.{name} gives
+// the paths diamond writes.
+func graphitePathFromTemplate(template []string, name string, dimensions map[string]string) string {
+	segments := make([]string, 0, len(template))
+	for _, segment := range template {
+		complete := true
+		expanded := graphiteTemplatePlaceholder.ReplaceAllStringFunc(segment, func(placeholder string) string {
+			key := placeholder[1 : len(placeholder)-1]
+			if key == "name" {
+				return name
+			}
+			value, exists := dimensions[key]
+			if !exists || value == "" {
+				complete = false
+			}
+			return value
+		})
+		if complete && expanded != "" {
+			segments = append(segments, expanded)
+		}
 	}
-	datapoint = fmt.Sprintf("%s %f %d\n", datapoint, incomingMetric.Value, incomingMetric.GetTime().Unix())
-	return datapoint
+	return strings.Join(segments, ".")
 }
 
 func (g *Graphite) emitMetrics(metrics []metric.Metric) bool {
@@ -106,15 +238,26 @@ func (g *Graphite) emitMetrics(metrics []metric.Metric) bool {
 		return false
 	}
 
+	datapoints := make([]graphiteDatapoint, 0, len(metrics))
+	for _, m := range metrics {
+		for _, expanded := range expandDistribution(m, g.Percentiles()) {
+			datapoints = append(datapoints, g.graphiteDatapoint(expanded))
+		}
+	}
+
 	addr := fmt.Sprintf("%s:%s", g.server, g.port)
-	conn, err := net.DialTimeout("tcp", addr, g.timeout)
+	var err error
+	switch g.protocol {
+	case graphiteProtocolUDP:
+		err = g.sendPlaintextUDP(addr, datapoints)
+	case graphiteProtocolPickle:
+		err = g.sendPickle(addr, datapoints)
+	default:
+		err = g.sendPlaintextTCP(addr, datapoints)
+	}
 	if err != nil {
-		g.log.Error("Failed to connect ", addr)
+		g.log.Error("Failed to send to ", addr, " over ", g.protocol, ": ", err)
 		return false
 	}
-
-	for _, m := range metrics {
-		fmt.Fprintf(conn, g.convertToGraphite(m))
-	}
 	return true
 }
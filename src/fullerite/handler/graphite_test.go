@@ -151,3 +151,65 @@ func TestConvertToGraphitePrefixKey(t *testing.T) {
 
 	assert.Equal(t, fmt.Sprintf("container_id_container_name.TestMetric.container_id.test-id.container_name.test-container 0.000000 %d\n", now), dpString)
 }
+
+func TestGraphiteConfigureMode(t *testing.T) {
+	g := getTestGraphiteHandler(12, 13, 14)
+	g.Configure(map[string]interface{}{"server": "localhost", "port": 2003})
+	assert.Equal(t, graphiteModePath, g.mode)
+
+	g.Configure(map[string]interface{}{"server": "localhost", "port": 2003, "mode": "tagged"})
+	assert.Equal(t, graphiteModeTagged, g.mode)
+
+	g.Configure(map[string]interface{}{"server": "localhost", "port": 2003, "mode": "template", "template": "servers.{host}"})
+	assert.Equal(t, graphiteModePath, g.mode, "a template without {name} is rejected")
+
+	g.Configure(map[string]interface{}{"server": "localhost", "port": 2003, "mode": "fancy"})
+	assert.Equal(t, graphiteModePath, g.mode)
+}
+
+func TestGraphitePrefixKeysOnlyInPathMode(t *testing.T) {
+	log, hook := getTestLogHook()
+	g := newGraphite(make(chan metric.Metric), 12, 13, time.Second, log).(*Graphite)
+
+	g.Configure(map[string]interface{}{"server": "localhost", "port": 2003, "prefixKeys": true})
+	assert.Empty(t, hook.messages)
+
+	g.Configure(map[string]interface{}{"server": "localhost", "port": 2003, "prefixKeys": true, "mode": "tagged"})
+	assert.Equal(t, []string{"prefixKeys only applies to the path mode, it is ignored in tagged mode"}, hook.messages)
+}
+
+func TestConvertToGraphiteTagged(t *testing.T) {
+	g := getTestGraphiteHandler(12, 13, 14)
+	g.Configure(map[string]interface{}{"server": "localhost", "port": 2003, "mode": "tagged"})
+	g.SetPrefix("fullerite.")
+
+	m := metric.New("cpu.user")
+	m.AddDimension("host", "web-01.example.com")
+	m.AddDimension("core", "0;1")
+	now := m.GetTime().Unix()
+
+	assert.Equal(t, fmt.Sprintf("fullerite.cpu.user;core=0_1;host=web-01.example.com 0.000000 %d\n", now), g.convertToGraphite(m))
+}
+
+func TestConvertToGraphiteTemplate(t *testing.T) {
+	g := getTestGraphiteHandler(12, 13, 14)
+	g.Configure(map[string]interface{}{
+		"server":   "localhost",
+		"port":     2003,
+		"mode":     "template",
+		"template": "servers.{host}.{collector}.{name}.core_{core}",
+	})
+
+	m := metric.New("cpu.user")
+	m.AddDimension("host", "web-01.example.com")
+	m.AddDimension("collector", "CPUCollector")
+	m.AddDimension("core", "3")
+	m.AddDimension("pid", "1234")
+	now := m.GetTime().Unix()
+	assert.Equal(t, fmt.Sprintf("servers.web-01_example_com.CPUCollector.cpu.user.core_3 0.000000 %d\n", now), g.convertToGraphite(m))
+
+	// segments of missing dimensions are left out
+	m.RemoveDimension("core")
+	m.RemoveDimension("collector")
+	assert.Equal(t, "servers.web-01_example_com.cpu.user", g.graphitePath(m))
+}
@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+)
+
+// The transports the Graphite handler sends datapoints with
+const (
+	graphiteProtocolTCP    = "tcp"
+	graphiteProtocolUDP    = "udp"
+	graphiteProtocolPickle = "pickle"
+)
+
+// Defaults for the udp and pickle transports
+const (
+	// DefaultGraphiteMaxPacketSize keeps udp packets below the usual MTU
+	DefaultGraphiteMaxPacketSize = 1400
+	// DefaultGraphitePickleBatchSize is the number of datapoints per pickle
+	DefaultGraphitePickleBatchSize = 500
+)
+
+// graphiteDatapoint is one value of a series
+type graphiteDatapoint struct {
+	path      string
+	value     float64
+	timestamp int64
+}
+
+func (dp graphiteDatapoint) plaintext() string {
+	return fmt.Sprintf("%s %f %d\n", dp.path, dp.value, dp.timestamp)
+}
+
+// sendPlaintextTCP writes all datapoints over one connection
+func (g *Graphite) sendPlaintextTCP(addr string, datapoints []graphiteDatapoint) error {
+	conn, err := net.DialTimeout("tcp", addr, g.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var payload bytes.Buffer
+	for _, dp := range datapoints {
+		payload.WriteString(dp.plaintext())
+	}
+	_, err = conn.Write(payload.Bytes())
+	return err
+}
+
+// sendPlaintextUDP writes the datapoints in as few packets as fit into
+// maxPacketSize, a datapoint is never split across packets
+func (g *Graphite) sendPlaintextUDP(addr string, datapoints []graphiteDatapoint) error {
+	conn, err := net.DialTimeout("udp", addr, g.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for _, packet := range graphitePackets(datapoints, g.maxPacketSize) {
+		if _, err := conn.Write(packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// graphitePackets batches the plaintext lines into packets of at most
+// maxSize bytes, longer lines get a packet of their own
+func graphitePackets(datapoints []graphiteDatapoint, maxSize int) [][]byte {
+	packets := [][]byte{}
+	var packet bytes.Buffer
+	for _, dp := range datapoints {
+		line := dp.plaintext()
+		if packet.Len() > 0 && packet.Len()+len(line) > maxSize {
+			packets = append(packets, append([]byte{}, packet.Bytes()...))
+			packet.Reset()
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		packets = append(packets, packet.Bytes())
+	}
+	return packets
+}
+
+// sendPickle writes the datapoints in batches of pickleBatchSize with the
+// pickle protocol of carbon's pickle receiver
+func (g *Graphite) sendPickle(addr string, datapoints []graphiteDatapoint) error {
+	conn, err := net.DialTimeout("tcp", addr, g.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for start := 0; start < len(datapoints); start += g.pickleBatchSize {
+		end := start + g.pickleBatchSize
+		if end > len(datapoints) {
+			end = len(datapoints)
+		}
+		if _, err := conn.Write(graphitePickle(datapoints[start:end])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Python pickle opcodes, protocol 2
+const (
+	pickleProto     = 0x80
+	pickleEmptyList = ']'
+	pickleMark      = '('
+	pickleAppends   = 'e'
+	pickleUnicode   = 'X'
+	pickleInt       = 'J'
+	pickleLong1     = 0x8a
+	pickleFloat     = 'G'
+	pickleTuple2    = 0x86
+	pickleStop      = '.'
+)
+
+// graphitePickle encodes the datapoints as the pickled list
+// [(path, (timestamp, value)), ...] preceded by its length as a 4 byte
+// big endian integer
+func graphitePickle(datapoints []graphiteDatapoint) []byte {
+	var pickle bytes.Buffer
+	pickle.Write([]byte{pickleProto, 2, pickleEmptyList})
+	if len(datapoints) > 0 {
+		pickle.WriteByte(pickleMark)
+		for _, dp := range datapoints {
+			pickle.WriteByte(pickleUnicode)
+			binary.Write(&pickle, binary.LittleEndian, uint32(len(dp.path)))
+			pickle.WriteString(dp.path)
+
+			if dp.timestamp >= math.MinInt32 && dp.timestamp <= math.MaxInt32 {
+				pickle.WriteByte(pickleInt)
+				binary.Write(&pickle, binary.LittleEndian, int32(dp.timestamp))
+			} else {
+				pickle.Write([]byte{pickleLong1, 8})
+				binary.Write(&pickle, binary.LittleEndian, dp.timestamp)
+			}
+			pickle.WriteByte(pickleFloat)
+			binary.Write(&pickle, binary.BigEndian, dp.value)
+			pickle.Write([]byte{pickleTuple2, pickleTuple2})
+		}
+		pickle.WriteByte(pickleAppends)
+	}
+	pickle.WriteByte(pickleStop)
+
+	message := make([]byte, 4, 4+pickle.Len())
+	binary.BigEndian.PutUint32(message, uint32(pickle.Len()))
+	return append(message, pickle.Bytes()...)
+}
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"fullerite/metric"
+
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGraphiteConfigureProtocol(t *testing.T) {
+	g := getTestGraphiteHandler(12, 13, 14)
+	assert.Equal(t, "tcp", g.Protocol())
+
+	g.Configure(map[string]interface{}{"server": "localhost", "port": 2003, "protocol": "udp", "maxPacketSize": "512"})
+	assert.Equal(t, "udp", g.Protocol())
+	assert.Equal(t, 512, g.maxPacketSize)
+
+	g.Configure(map[string]interface{}{"server": "localhost", "port": 2004, "protocol": "pickle", "pickleBatchSize": 0})
+	assert.Equal(t, "pickle", g.Protocol())
+	assert.Equal(t, DefaultGraphitePickleBatchSize, g.pickleBatchSize)
+
+	g.Configure(map[string]interface{}{"server": "localhost", "port": 2003, "protocol": "amqp"})
+	assert.Equal(t, "tcp", g.Protocol())
+}
+
+func TestGraphitePackets(t *testing.T) {
+	datapoints := []graphiteDatapoint{
+		{"a.b", 1, 1450000000},
+		{"c.d", 2, 1450000000},
+		{"a.very.long.path.which.does.not.fit", 3, 1450000000},
+		{"e.f", 4, 1450000000},
+	}
+	line := len(datapoints[0].plaintext())
+
+	packets := graphitePackets(datapoints, 2*line)
+	require.Equal(t, 3, len(packets))
+	assert.Equal(t, datapoints[0].plaintext()+datapoints[1].plaintext(), string(packets[0]))
+	assert.Equal(t, datapoints[2].plaintext(), string(packets[1]), "long lines get a packet of their own")
+	assert.Equal(t, datapoints[3].plaintext(), string(packets[2]))
+
+	assert.Equal(t, 0, len(graphitePackets(nil, 100)))
+}
+
+func TestGraphitePickle(t *testing.T) {
+	message := graphitePickle([]graphiteDatapoint{{"a.b", 1.5, 1450000000}})
+	expected := []byte{
+		0x80, 2, ']', '(',
+		'X', 3, 0, 0, 0, 'a', '.', 'b',
+		'J', 0x80, 0x3e, 0x6d, 0x56,
+		'G', 0x3f, 0xf8, 0, 0, 0, 0, 0, 0,
+		0x86, 0x86, 'e', '.',
+	}
+	assert.Equal(t, uint32(len(expected)), binary.BigEndian.Uint32(message[:4]))
+	assert.Equal(t, expected, message[4:])
+
+	assert.Equal(t, []byte{0, 0, 0, 4, 0x80, 2, ']', '.'}, graphitePickle(nil))
+
+	// timestamps beyond 2038 do not fit into an int32
+	message = graphitePickle([]graphiteDatapoint{{"a", 0, 5000000000}})
+	assert.Equal(t, []byte{0x8a, 8, 0x00, 0xf2, 0x05, 0x2a, 0x01, 0, 0, 0}, message[14:24])
+
+}
+
+func TestGraphiteEmitUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer conn.Close()
+	_, port, _ := net.SplitHostPort(conn.LocalAddr().String())
+
+	g := getTestGraphiteHandler(12, 13, 14)
+	g.Configure(map[string]interface{}{"server": "127.0.0.1", "port": port, "protocol": "udp", "maxPacketSize": 40})
+
+	metrics := []metric.Metric{metric.WithValue("first", 1), metric.WithValue("second", 2)}
+	require.True(t, g.emitMetrics(metrics))
+
+	buf := make([]byte, 1500)
+	for _, name := range []string{"first", "second"} {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		require.Nil(t, err)
+		assert.True(t, strings.HasPrefix(string(buf[:n]), name+" "), string(buf[:n]))
+		assert.Equal(t, 1, strings.Count(string(buf[:n]), "\n"))
+	}
+}
+
+func TestGraphiteEmitTCPAndPickle(t *testing.T) {
+	for _, protocol := range []string{"tcp", "pickle"} {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.Nil(t, err)
+		received := make(chan []byte, 1)
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			data, _ := ioutil.ReadAll(conn)
+			conn.Close()
+			received <- data
+		}()
+		_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+		g := getTestGraphiteHandler(12, 13, 14)
+		g.Configure(map[string]interface{}{"server": "127.0.0.1", "port": port, "protocol": protocol, "pickleBatchSize": 1})
+		m := metric.WithValue("load", 2)
+		require.True(t, g.emitMetrics([]metric.Metric{m, m}))
+
+		select {
+		case data := <-received:
+			if protocol == "tcp" {
+				assert.Equal(t, strings.Repeat(g.convertToGraphite(m), 2), string(data))
+			} else {
+				single := graphitePickle([]graphiteDatapoint{g.graphiteDatapoint(m)})
+				assert.Equal(t, bytes.Repeat(single, 2), data, "one pickle per batch")
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("nothing was sent over ", protocol)
+		}
+		listener.Close()
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"sync/atomic"
 
 	"container/list"
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
@@ -100,6 +101,14 @@ type Handler interface {
 	SetCollectorWhiteList([]string)
 	CollectorWhiteList() map[string]bool
 	IsCollectorWhiteListed(string) (bool, bool)
+
+	// Return true if the metric passes the
+	// metric white and black lists of the handler
+	IsMetricAccepted(metric.Metric) bool
+
+	// Relabel rules of the global config, they are applied
+	// before the rules of the handler
+	SetGlobalRelabelRules([]interface{})
 }
 
 type emissionTiming struct {
@@ -138,6 +147,25 @@ type BaseHandler struct {
 	// List of whitelisted collectors
 	// the handler will accept metrics from
 	whiteListedCollectors map[string]bool
+
+	// Filters selecting the metrics the handler accepts,
+	// a metric matching the blacklist is never accepted
+	metricWhiteList []metric.Filter
+	metricBlackList []metric.Filter
+
+	// Rules applied to every metric before it is buffered
+	globalRelabelRules relabelRules
+	relabelRules       relabelRules
+
+	// Converts cumulative counters to rates or deltas, nil if disabled
+	counterConverter *counterConverter
+
+	// Percentiles rendered for histograms and timers, nil for the defaults
+	percentiles []float64
+
+	// Rewrites names and dimensions into what the backend accepts, nil
+	// if the handler sends them as they are
+	sanitizer *sanitizePolicy
 }
 
 // SetMaxBufferSize : set the buffer size
@@ -255,6 +283,63 @@ func (base BaseHandler) CollectorWhiteList() map[string]bool {
 	return base.whiteListedCollectors
 }
 
+// IsMetricAccepted : return true if the metric passes the handler's metric filters.
+// With a whitelist the metric has to match one of its filters.
+func (base *BaseHandler) IsMetricAccepted(m metric.Metric) bool {
+	for _, f := range base.metricBlackList {
+		if m.IsFiltered(f) {
+			return false
+		}
+	}
+	if len(base.metricWhiteList) == 0 {
+		return true
+	}
+	for _, f := range base.metricWhiteList {
+		if m.IsFiltered(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseMetricFilters reads a list of filters in the JSON format of
+// metric.Filter, invalid filters are skipped
+func parseMetricFilters(value interface{}) []metric.Filter {
+	filters := []metric.Filter{}
+	list, ok := value.([]interface{})
+	if !ok {
+		defaultLog.Warn("Expected a list of metric filters but got ", value)
+		return filters
+	}
+	for _, item := range list {
+		if _, ok := item.(map[string]interface{}); !ok {
+			defaultLog.Warn("Expected a metric filter but got ", item)
+			continue
+		}
+		raw, err := json.Marshal(item)
+		if err != nil {
+			defaultLog.Warn("Skipping metric filter ", item, ": ", err)
+			continue
+		}
+		f, err := metric.ParseFilter(raw)
+		if err != nil {
+			defaultLog.Warn("Skipping metric filter ", string(raw), ": ", err)
+			continue
+		}
+		filters = append(filters, f)
+	}
+	return filters
+}
+
+// SetGlobalRelabelRules : set the relabel rules shared by all handlers
+func (base *BaseHandler) SetGlobalRelabelRules(rules []interface{}) {
+	if len(rules) == 0 {
+		return
+	}
+	base.globalRelabelRules = parseRelabelRules(rules, "global")
+	base.relabelRules = base.globalRelabelRules
+}
+
 // MaxIdleConnectionsPerHost : return max idle connections per host
 func (base BaseHandler) MaxIdleConnectionsPerHost() int {
 	return base.maxIdleConnectionsPerHost
@@ -305,10 +390,15 @@ func (base BaseHandler) InternalMetrics() metric.InternalMetrics {
 		"metricsDropped": float64(base.metricsDropped),
 		"metricsSent":    float64(base.metricsSent),
 	}
+	for name, hits := range base.relabelRules.hitCounters() {
+		counters[name] = hits
+	}
 	gauges := map[string]float64{
 		"intervalLength":    float64(base.interval),
 		"emissionsInWindow": float64(base.emissionTimes.Len()),
 	}
+	base.counterConverter.internalMetrics(counters, gauges)
+	base.sanitizer.internalMetrics(counters)
 
 	// now we calculate the average emission seconds for
 	if base.emissionTimes.Len() > 0 {
@@ -375,15 +465,45 @@ func (base *BaseHandler) configureCommonParams(configMap map[string]interface{})
 		whiteList := config.GetAsSlice(asInterface)
 		base.SetCollectorWhiteList(whiteList)
 	}
+
+	if asInterface, exists := configMap["metricWhiteList"]; exists {
+		base.metricWhiteList = parseMetricFilters(asInterface)
+	}
+
+	if asInterface, exists := configMap["metricBlackList"]; exists {
+		base.metricBlackList = parseMetricFilters(asInterface)
+	}
+
+	if asInterface, exists := configMap["relabelRules"]; exists {
+		rules := append(relabelRules{}, base.globalRelabelRules...)
+		base.relabelRules = append(rules, parseRelabelRules(asInterface, "handler")...)
+	}
+
+	base.counterConverter = parseCounterConverter(configMap)
+
+	if asInterface, exists := configMap["percentiles"]; exists {
+		base.percentiles = parsePercentiles(asInterface)
+	}
+
+	if asInterface, exists := configMap["sanitize"]; exists {
+		if base.sanitizer == nil {
+			// only handlers with a default policy rewrite names
+			if enabled, ok := asInterface.(bool); !ok || enabled {
+				base.log.Warn("sanitize is not supported by the ", base.name, " handler, names are sent unchanged")
+			}
+		} else {
+			base.sanitizer = parseSanitizePolicy(asInterface, base.sanitizer)
+		}
+	}
 }
 
 func (base *BaseHandler) run(emitFunc func([]metric.Metric) bool) {
 	emissionResults := make(chan emissionTiming)
 	go base.recordEmissions(emissionResults)
 
-	go base.listenForMetrics(emitFunc, base.Channel(), emissionResults)
-	for k := range base.CollectorChannels() {
-		go base.listenForMetrics(emitFunc, base.CollectorChannels()[k], emissionResults)
+	go base.listenForMetrics(emitFunc, base.channel, emissionResults)
+	for k := range base.collectorChannels {
+		go base.listenForMetrics(emitFunc, base.collectorChannels[k], emissionResults)
 	}
 }
 
@@ -392,10 +512,13 @@ func (base *BaseHandler) listenForMetrics(
 	c <-chan metric.Metric,
 	emissionResults chan<- emissionTiming) {
 
-	metrics := make([]metric.Metric, 0, base.MaxBufferSize())
+	// the value receivers copy the handler while emissions update its
+	// counters, read the fields instead
+	maxBufferSize := base.maxBufferSize
+	metrics := make([]metric.Metric, 0, maxBufferSize)
 	currentBufferSize := 0
 
-	ticker := time.NewTicker(time.Duration(base.Interval()) * time.Second)
+	ticker := time.NewTicker(time.Duration(base.interval) * time.Second)
 	flusher := ticker.C
 
 stopReading:
@@ -407,21 +530,31 @@ stopReading:
 				// we have been asked to stop reading.
 				break stopReading
 			}
-			base.log.Debug(base.Name(), " metric: ", incomingMetric)
-			metrics = append(metrics, incomingMetric)
+			if !base.IsMetricAccepted(incomingMetric) {
+				continue
+			}
+			relabeled, keep := base.relabelRules.apply(incomingMetric)
+			if !keep {
+				continue
+			}
+			if relabeled, keep = base.counterConverter.convert(relabeled); !keep {
+				continue
+			}
+			base.log.Debug(base.name, " metric: ", relabeled)
+			metrics = append(metrics, relabeled)
 			currentBufferSize++
 
-			if int(currentBufferSize) >= base.MaxBufferSize() {
+			if int(currentBufferSize) >= maxBufferSize {
 				go base.emitAndTime(metrics, emitFunc, emissionResults)
 
 				// will get copied into this call, meaning it's ok to clear it
-				metrics = make([]metric.Metric, 0, base.MaxBufferSize())
+				metrics = make([]metric.Metric, 0, maxBufferSize)
 				currentBufferSize = 0
 			}
 		case <-flusher:
 			if currentBufferSize > 0 {
 				go base.emitAndTime(metrics, emitFunc, emissionResults)
-				metrics = make([]metric.Metric, 0, base.MaxBufferSize())
+				metrics = make([]metric.Metric, 0, maxBufferSize)
 				currentBufferSize = 0
 			}
 		}
@@ -4,13 +4,37 @@ import (
 	"fullerite/metric"
 
 	"fmt"
+	"io/ioutil"
 	"testing"
 	"time"
 
 	l "github.com/Sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// testLogHook records the messages of warnings and errors
+type testLogHook struct {
+	messages []string
+}
+
+func (h *testLogHook) Levels() []l.Level {
+	return []l.Level{l.WarnLevel, l.ErrorLevel}
+}
+
+func (h *testLogHook) Fire(entry *l.Entry) error {
+	h.messages = append(h.messages, entry.Message)
+	return nil
+}
+
+func getTestLogHook() (*l.Entry, *testLogHook) {
+	hook := &testLogHook{}
+	logger := l.New()
+	logger.Out = ioutil.Discard
+	logger.Hooks.Add(hook)
+	return l.NewEntry(logger), hook
+}
+
 func assertEmpty(t *testing.T, channel chan metric.Metric) {
 	close(channel)
 	for range channel {
@@ -266,3 +290,44 @@ func TestKeepAliveConfig(t *testing.T) {
 	assert.Equal(t, 0, base.KeepAliveInterval())
 	assert.Equal(t, 0, base.MaxIdleConnectionsPerHost())
 }
+
+func TestMetricFilters(t *testing.T) {
+	base := BaseHandler{}
+	base.configureCommonParams(map[string]interface{}{
+		"metricWhiteList": []interface{}{
+			map[string]interface{}{"name": "^Docker", "type": "gauge"},
+			map[string]interface{}{"name": "^cpu", "type": "counter", "dimensions": map[string]interface{}{"core": "0"}},
+			map[string]interface{}{"name": "(", "type": "gauge"},
+		},
+		"metricBlackList": []interface{}{
+			map[string]interface{}{"name": "Memory", "type": "gauge"},
+			map[string]interface{}{
+				"nameGlob": "Docker*",
+				"not":      map[string]interface{}{"dimensionAbsent": []interface{}{"exited"}},
+			},
+		},
+	})
+	require.Equal(t, 2, len(base.metricWhiteList), "invalid filters should be skipped")
+
+	docker := metric.New("DockerCpuPercentage")
+	cpu := metric.New("cpu.idle")
+	cpu.MetricType = metric.Counter
+	cpu.AddDimension("core", "0")
+	otherCore := metric.New("cpu.idle")
+	otherCore.MetricType = metric.Counter
+	otherCore.AddDimension("core", "1")
+
+	assert := assert.New(t)
+	assert.True(base.IsMetricAccepted(docker))
+	assert.True(base.IsMetricAccepted(cpu))
+	assert.False(base.IsMetricAccepted(otherCore))
+	assert.False(base.IsMetricAccepted(metric.New("load")))
+	assert.False(base.IsMetricAccepted(metric.New("DockerMemoryUsage")), "the blacklist should win")
+	docker.AddDimension("exited", "true")
+	assert.False(base.IsMetricAccepted(docker))
+}
+
+func TestMetricFiltersEmpty(t *testing.T) {
+	base := BaseHandler{}
+	assert.True(t, base.IsMetricAccepted(metric.New("anything")))
+}
@@ -2,8 +2,14 @@ package handler
 
 import (
 	"fmt"
+	"fullerite/config"
 	"fullerite/metric"
-	"log"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	l "github.com/Sirupsen/logrus"
@@ -14,15 +20,41 @@ func init() {
 	RegisterHandler("InfluxDB", newInfluxDB)
 }
 
+// DefaultInfluxDBPrecision is the precision timestamps are written with
+const DefaultInfluxDBPrecision = "s"
+
 // InfluxDB type
 type InfluxDB struct {
 	BaseHandler
-	server   string
-	port     string
-	database string
-	username string
-	password string
-	influxdb client.Client
+	server          string
+	port            string
+	database        string
+	username        string
+	password        string
+	precision       string
+	retentionPolicy string
+	consistency     string
+	fieldMappings   []influxFieldMapping
+	createDatabase  bool
+	influxdb        client.Client
+
+	// points which could not be built and points the server did not store
+	pointsSkipped  uint64
+	pointsRejected uint64
+}
+
+// partialWrite matches the error of a write of which only some points
+// were stored, e.g. "partial write: field type conflict: ... dropped=2"
+var partialWrite = regexp.MustCompile(`partial write:.*dropped=(\d+)`)
+
+// influxFieldMapping writes the metrics whose name matches match as field
+// of measurement, both may refer to the groups of match with $1 etc.
+// Metrics mapped to the same measurement with the same dimensions and
+// timestamp are written as a single point.
+type influxFieldMapping struct {
+	match       *regexp.Regexp
+	measurement string
+	field       string
 }
 
 // newInfluxDB returns a new InfluxDB handler.
@@ -39,6 +71,7 @@ func newInfluxDB(
 	inst.timeout = initialTimeout
 	inst.log = log
 	inst.channel = channel
+	inst.sanitizer = influxDBSanitizePolicy()
 
 	return inst
 }
@@ -81,6 +114,31 @@ func (i *InfluxDB) Configure(configMap map[string]interface{}) {
 	} else {
 		i.log.Error("There was no database specified for the InfluxDB Handler, there won't be any emissions")
 	}
+	i.precision = DefaultInfluxDBPrecision
+	if precision, exists := configMap["precision"]; exists {
+		if normalized, _, ok := influxDBPrecision(fmt.Sprint(precision)); ok {
+			i.precision = normalized
+		} else {
+			i.log.Warn("Invalid precision ", precision, ", using ", DefaultInfluxDBPrecision)
+		}
+	}
+	if retentionPolicy, exists := configMap["retentionPolicy"]; exists {
+		i.retentionPolicy = fmt.Sprint(retentionPolicy)
+	}
+	if consistency, exists := configMap["consistency"]; exists {
+		switch consistency {
+		case "any", "one", "quorum", "all":
+			i.consistency = consistency.(string)
+		default:
+			i.log.Warn("Invalid consistency ", consistency, ", expected any, one, quorum or all")
+		}
+	}
+	if fieldMappings, exists := configMap["fieldMappings"]; exists {
+		i.fieldMappings = i.parseFieldMappings(fieldMappings)
+	}
+	if createDatabase, exists := configMap["createDatabase"]; exists {
+		i.createDatabase, _ = createDatabase.(bool)
+	}
 	// Make client
 	addr := fmt.Sprintf("http://%s:%s", i.server, i.port)
 
@@ -93,23 +151,197 @@ func (i *InfluxDB) Configure(configMap map[string]interface{}) {
 	if err != nil {
 		i.log.Warn("Error: ", err)
 	}
+	i.sanitizer = influxDBSanitizePolicy()
 	i.configureCommonParams(configMap)
 }
 
 // Run runs the handler main loop
 func (i *InfluxDB) Run() {
+	if i.createDatabase {
+		i.ensureDatabase()
+	}
 	i.run(i.emitMetrics)
 }
 
+// ensureDatabase creates the database unless it exists
+func (i *InfluxDB) ensureDatabase() bool {
+	if i.influxdb == nil || i.database == "" {
+		return false
+	}
+	command := "CREATE DATABASE " + strconv.Quote(i.database)
+	response, err := i.influxdb.Query(client.NewQuery(command, "", ""))
+	if err == nil {
+		err = response.Error()
+	}
+	if err != nil {
+		i.log.Error("Failed to create database ", i.database, ": ", err)
+		return false
+	}
+	i.log.Info("Created database ", i.database)
+	return true
+}
+
+// InternalMetrics adds the points which were skipped or rejected to the
+// handler's internal metrics
+func (i *InfluxDB) InternalMetrics() metric.InternalMetrics {
+	internal := i.BaseHandler.InternalMetrics()
+	internal.Counters["pointsSkipped"] = float64(atomic.LoadUint64(&i.pointsSkipped))
+	internal.Counters["pointsRejected"] = float64(atomic.LoadUint64(&i.pointsRejected))
+	return internal
+}
+
+// influxDBPrecision returns InfluxDB's name of a precision, which the client
+// and the write API understand, and its duration
+func influxDBPrecision(precision string) (string, time.Duration, bool) {
+	switch precision {
+	case "n", "ns":
+		return "n", time.Nanosecond, true
+	case "u", "us":
+		return "u", time.Microsecond, true
+	case "ms":
+		return "ms", time.Millisecond, true
+	case "s":
+		return "s", time.Second, true
+	case "m":
+		return "m", time.Minute, true
+	case "h":
+		return "h", time.Hour, true
+	}
+	return "", 0, false
+}
+
+// Precision returns the precision timestamps are written with
+func (i InfluxDB) Precision() string {
+	return i.precision
+}
+
+// parseFieldMappings reads a list of {"match", "measurement", "field"} maps,
+// invalid mappings are skipped
+func (i *InfluxDB) parseFieldMappings(value interface{}) []influxFieldMapping {
+	mappings := []influxFieldMapping{}
+	list, ok := value.([]interface{})
+	if !ok {
+		i.log.Warn("Expected a list of field mappings but got ", value)
+		return mappings
+	}
+	for _, item := range list {
+		spec := config.GetAsMap(item)
+		if spec["match"] == "" || spec["measurement"] == "" || spec["field"] == "" {
+			i.log.Warn("Skipping field mapping ", item, ", it needs match, measurement and field")
+			continue
+		}
+		match, err := regexp.Compile(spec["match"])
+		if err != nil {
+			i.log.Warn("Skipping field mapping ", item, ": ", err)
+			continue
+		}
+		mappings = append(mappings, influxFieldMapping{match, spec["measurement"], spec["field"]})
+	}
+	return mappings
+}
+
+// measurementAndField returns where a metric is written to, metrics which
+// are not mapped are written as the value field of a measurement named
+// after the metric
+func (i InfluxDB) measurementAndField(name string) (string, string, bool) {
+	for _, mapping := range i.fieldMappings {
+		match := mapping.match.FindStringSubmatchIndex(name)
+		if match == nil {
+			continue
+		}
+		measurement := mapping.match.ExpandString(nil, mapping.measurement, name, match)
+		field := mapping.match.ExpandString(nil, mapping.field, name, match)
+		return string(measurement), string(field), true
+	}
+	return name, "value", false
+}
+
+// influxPoint collects the fields of one point
+type influxPoint struct {
+	measurement string
+	tags        map[string]string
+	fields      map[string]interface{}
+	time        time.Time
+}
+
+// groupPoints converts metrics into points, metrics mapped to the same
+// measurement with the same tags and timestamp, at the configured
+// precision, share a point. Points keep the order of their first metric.
+func (i *InfluxDB) groupPoints(metrics []metric.Metric) []*client.Point {
+	_, precision, _ := influxDBPrecision(i.precision)
+	groups := make(map[string]*influxPoint)
+	order := []*influxPoint{}
+	points := []*client.Point{}
+
+	for _, m := range metrics {
+		measurement, field, mapped := i.measurementAndField(m.Name)
+		if !mapped {
+			if pt := i.convertToInfluxDB(m); pt != nil {
+				points = append(points, pt)
+			} else {
+				atomic.AddUint64(&i.pointsSkipped, 1)
+			}
+			continue
+		}
+		if math.IsNaN(m.Value) || math.IsInf(m.Value, 0) {
+			i.log.Warn("Skipping metric ", m.Name, " with value ", m.Value)
+			atomic.AddUint64(&i.pointsSkipped, 1)
+			continue
+		}
+
+		measurement, tags := i.sanitizer.apply(measurement, m.GetDimensions(i.DefaultDimensions()))
+		timestamp := m.Time.Truncate(precision)
+		key := influxGroupKey(measurement, tags, timestamp)
+		group, exists := groups[key]
+		if !exists {
+			group = &influxPoint{measurement, tags, make(map[string]interface{}), timestamp}
+			groups[key] = group
+			order = append(order, group)
+		}
+		if m.IsDistribution() {
+			for stat, value := range distributionFields(m.Distribution, i.Percentiles()) {
+				group.fields[field+"_"+stat] = value
+			}
+		} else {
+			group.fields[field] = m.Value
+		}
+	}
+
+	for _, group := range order {
+		pt, err := client.NewPoint(group.measurement, group.tags, group.fields, group.time)
+		if err != nil {
+			i.log.Error("Skipping point ", group.measurement, ": ", err)
+			atomic.AddUint64(&i.pointsSkipped, 1)
+			continue
+		}
+		points = append(points, pt)
+	}
+	return points
+}
+
+func influxGroupKey(measurement string, tags map[string]string, timestamp time.Time) string {
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return fmt.Sprintf("%s\x00%s\x00%d", measurement, strings.Join(pairs, ","), timestamp.UnixNano())
+}
+
+// convertToInfluxDB returns nil for metrics which are no valid point
 func (i InfluxDB) convertToInfluxDB(incomingMetric metric.Metric) (datapoint *client.Point) {
-	tags := incomingMetric.GetDimensions(i.DefaultDimensions())
+	measurement, tags := i.sanitizer.apply(incomingMetric.Name, incomingMetric.GetDimensions(i.DefaultDimensions()))
 	// Assemble field (could be improved to convey multiple fields)
 	fields := map[string]interface{}{
 		"value": incomingMetric.Value,
 	}
-	pt, err := client.NewPoint(incomingMetric.Name, tags, fields, incomingMetric.Time)
+	if incomingMetric.IsDistribution() {
+		fields = distributionFields(incomingMetric.Distribution, i.Percentiles())
+	}
+	pt, err := client.NewPoint(measurement, tags, fields, incomingMetric.Time)
 	if err != nil {
-		log.Fatalln("Error: ", err)
+		i.log.Error("Skipping metric ", incomingMetric.Name, ": ", err)
+		return nil
 	}
 	return pt
 }
@@ -124,19 +356,49 @@ func (i *InfluxDB) emitMetrics(metrics []metric.Metric) bool {
 
 	// Create a new point batch to be send in bulk
 	bp, err := client.NewBatchPoints(client.BatchPointsConfig{
-		Database:  i.database,
-		Precision: "s",
+		Database:         i.database,
+		Precision:        i.precision,
+		RetentionPolicy:  i.retentionPolicy,
+		WriteConsistency: i.consistency,
 	})
 	if err != nil {
-		log.Fatalln("Error: ", err)
+		i.log.Error("Failed to create a batch: ", err)
+		return false
 	}
-
-	//iterate over metrics
-	for _, m := range metrics {
-		bp.AddPoint(i.convertToInfluxDB(m))
+	if i.influxdb == nil {
+		i.log.Error("There is no InfluxDB client, dropping ", len(metrics), " metrics")
+		return false
+	}
+	points := i.groupPoints(metrics)
+	if len(points) == 0 {
+		i.log.Warn("Skipping send because none of the metrics is a valid point")
+		return false
+	}
+	for _, pt := range points {
+		bp.AddPoint(pt)
 	}
 
 	// Write the batch
-	i.influxdb.Write(bp)
-	return true
+	return i.handleWriteError(i.influxdb.Write(bp), len(points))
+}
+
+// handleWriteError returns whether the write succeeded. A partial write
+// counts as success, the points the server dropped are counted as
+// rejected. A missing database is created for the next write if the
+// handler is configured to.
+func (i *InfluxDB) handleWriteError(err error, numPoints int) bool {
+	if err == nil {
+		return true
+	}
+	if match := partialWrite.FindStringSubmatch(err.Error()); match != nil {
+		dropped, _ := strconv.ParseUint(match[1], 10, 64)
+		atomic.AddUint64(&i.pointsRejected, dropped)
+		i.log.Warn("InfluxDB dropped ", dropped, " of ", numPoints, " points: ", err)
+		return true
+	}
+	i.log.Error("Failed to write ", numPoints, " points to InfluxDB: ", err)
+	if i.createDatabase && strings.Contains(err.Error(), "database not found") {
+		i.ensureDatabase()
+	}
+	return false
 }
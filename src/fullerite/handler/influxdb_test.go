@@ -3,6 +3,12 @@ package handler
 import (
 	"fmt"
 	"fullerite/metric"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
@@ -11,6 +17,7 @@ import (
 
 	l "github.com/Sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func getTestInfluxDBHandler(interval, buffsize, timeoutsec int) *InfluxDB {
@@ -105,3 +112,211 @@ func TestConvertToInfluxDB(t *testing.T) {
 	assert.True(t, (start < tsInt) && (tsInt < end), msg)
 
 }
+
+func TestInfluxDBConfigureWriteOptions(t *testing.T) {
+	i := getTestInfluxDBHandler(12, 13, 14)
+	i.Configure(map[string]interface{}{"server": "localhost", "port": "8086"})
+	assert.Equal(t, "s", i.Precision())
+	assert.Equal(t, "", i.retentionPolicy)
+	assert.Equal(t, "", i.consistency)
+
+	i = getTestInfluxDBHandler(12, 13, 14)
+	i.Configure(map[string]interface{}{
+		"server":          "localhost",
+		"port":            "8086",
+		"precision":       "ms",
+		"retentionPolicy": "two_weeks",
+		"consistency":     "quorum",
+		"fieldMappings": []interface{}{
+			map[string]interface{}{"match": "^cpu\\.(\\w+)$", "measurement": "cpu", "field": "$1"},
+			map[string]interface{}{"match": "(", "measurement": "cpu", "field": "$1"},
+			map[string]interface{}{"match": "^mem"},
+		},
+	})
+	assert.Equal(t, "ms", i.Precision())
+	assert.Equal(t, "two_weeks", i.retentionPolicy)
+	assert.Equal(t, "quorum", i.consistency)
+	assert.Equal(t, 1, len(i.fieldMappings), "invalid mappings should be skipped")
+
+	i = getTestInfluxDBHandler(12, 13, 14)
+	i.Configure(map[string]interface{}{"precision": "days", "consistency": "most"})
+	assert.Equal(t, "s", i.Precision())
+	assert.Equal(t, "", i.consistency)
+}
+
+func TestInfluxDBPrecision(t *testing.T) {
+	for _, precision := range []string{"n", "ns", "u", "us", "ms", "s", "m", "h"} {
+		i := getTestInfluxDBHandler(12, 13, 14)
+		i.Configure(map[string]interface{}{"precision": precision})
+		_, duration, ok := influxDBPrecision(i.Precision())
+		assert.True(t, ok, precision)
+		assert.True(t, duration > 0, precision)
+	}
+
+	i := getTestInfluxDBHandler(12, 13, 14)
+	i.Configure(map[string]interface{}{"precision": "us"})
+	assert.Equal(t, "u", i.Precision(), "the client knows microseconds as u")
+
+	now := time.Unix(1450000000, 1234567)
+	m := metric.WithValue("load", 1)
+	m.Time = now
+	points := i.groupPoints([]metric.Metric{m})
+	require.Equal(t, 1, len(points))
+	assert.Equal(t, "load value=1 1450000000001234", points[0].PrecisionString(i.Precision()))
+}
+
+func TestInfluxDBGroupPoints(t *testing.T) {
+	i := getTestInfluxDBHandler(12, 13, 14)
+	i.Configure(map[string]interface{}{
+		"server": "localhost",
+		"port":   "8086",
+		"fieldMappings": []interface{}{
+			map[string]interface{}{"match": "^cpu\\.(\\w+)$", "measurement": "cpu", "field": "$1"},
+		},
+	})
+
+	now := time.Unix(1450000000, 0)
+	user := metric.WithValue("cpu.user", 10)
+	user.Time = now
+	user.AddDimension("core", "0")
+	system := metric.WithValue("cpu.system", 5)
+	system.Time = now.Add(200 * time.Millisecond)
+	system.AddDimension("core", "0")
+	otherCore := metric.WithValue("cpu.user", 20)
+	otherCore.Time = now
+	otherCore.AddDimension("core", "1")
+	load := metric.WithValue("load", 1.5)
+	load.Time = now
+
+	points := i.groupPoints([]metric.Metric{user, load, system, otherCore})
+	lines := []string{}
+	for _, pt := range points {
+		lines = append(lines, pt.PrecisionString(i.Precision()))
+	}
+	assert.Equal(t, []string{
+		"load value=1.5 1450000000",
+		"cpu,core=0 system=5,user=10 1450000000",
+		"cpu,core=1 user=20 1450000000",
+	}, lines)
+}
+
+func TestInfluxDBEmitWriteOptions(t *testing.T) {
+	var query url.Values
+	var body string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		raw, _ := ioutil.ReadAll(r.Body)
+		body = string(raw)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	addr, _ := url.Parse(ts.URL)
+	host, port, _ := net.SplitHostPort(addr.Host)
+
+	i := getTestInfluxDBHandler(12, 13, 14)
+	i.Configure(map[string]interface{}{
+		"server":          host,
+		"port":            port,
+		"database":        "metrics",
+		"precision":       "ms",
+		"retentionPolicy": "two_weeks",
+		"consistency":     "one",
+	})
+	m := metric.WithValue("load", 2)
+	m.Time = time.Unix(1450000000, 123000000)
+	assert.True(t, i.emitMetrics([]metric.Metric{m}))
+
+	assert.Equal(t, "metrics", query.Get("db"))
+	assert.Equal(t, "ms", query.Get("precision"))
+	assert.Equal(t, "two_weeks", query.Get("rp"))
+	assert.Equal(t, "one", query.Get("consistency"))
+	assert.Equal(t, "load value=2 1450000000123\n", body)
+}
+
+// influxDBTestServer answers writes with status and body and records the queries
+func influxDBTestServer(status int, body string, queries *[]string) (*httptest.Server, map[string]interface{}) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the client rejects responses which are not declared as json
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/query" {
+			*queries = append(*queries, r.URL.Query().Get("q"))
+			fmt.Fprint(w, `{"results": [{}]}`)
+			return
+		}
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	addr, _ := url.Parse(ts.URL)
+	host, port, _ := net.SplitHostPort(addr.Host)
+	return ts, map[string]interface{}{
+		"server":   host,
+		"port":     port,
+		"database": "metrics",
+	}
+}
+
+func TestInfluxDBEmitWriteError(t *testing.T) {
+	var queries []string
+	ts, config := influxDBTestServer(http.StatusInternalServerError, `{"error": "timeout"}`, &queries)
+	defer ts.Close()
+
+	i := getTestInfluxDBHandler(12, 13, 14)
+	i.Configure(config)
+	assert.False(t, i.emitMetrics([]metric.Metric{metric.WithValue("load", 1)}))
+	assert.Equal(t, 0, len(queries))
+}
+
+func TestInfluxDBEmitPartialWrite(t *testing.T) {
+	var queries []string
+	ts, config := influxDBTestServer(http.StatusBadRequest,
+		`{"error":"partial write: field type conflict: input field \"value\" on measurement \"load\" is type float, already exists as type string dropped=2"}`,
+		&queries)
+	defer ts.Close()
+
+	i := getTestInfluxDBHandler(12, 13, 14)
+	i.Configure(config)
+	metrics := []metric.Metric{metric.WithValue("load", 1), metric.WithValue("load", 2), metric.WithValue("cpu", 3)}
+	assert.True(t, i.emitMetrics(metrics))
+	assert.Equal(t, 2.0, i.InternalMetrics().Counters["pointsRejected"])
+}
+
+func TestInfluxDBSkipsBadPoints(t *testing.T) {
+	i := getTestInfluxDBHandler(12, 13, 14)
+	i.Configure(map[string]interface{}{
+		"server": "localhost",
+		"port":   "8086",
+		"fieldMappings": []interface{}{
+			map[string]interface{}{"match": "^cpu\\.(\\w+)$", "measurement": "cpu", "field": "$1"},
+		},
+	})
+
+	now := time.Now()
+	nan := metric.WithValue("load", math.NaN())
+	inf := metric.WithValue("cpu.user", math.Inf(1))
+	inf.Time = now
+	good := metric.WithValue("cpu.system", 1)
+	good.Time = now
+	assert.Nil(t, i.convertToInfluxDB(nan))
+
+	points := i.groupPoints([]metric.Metric{nan, inf, good})
+	assert.Equal(t, 1, len(points))
+	assert.Contains(t, points[0].String(), "system=1")
+	assert.Equal(t, 2.0, i.InternalMetrics().Counters["pointsSkipped"])
+
+	assert.False(t, i.emitMetrics([]metric.Metric{nan}), "a batch without valid points is not sent")
+}
+
+func TestInfluxDBCreateDatabase(t *testing.T) {
+	var queries []string
+	ts, config := influxDBTestServer(http.StatusNotFound, `{"error":"database not found: \"metrics\""}`, &queries)
+	defer ts.Close()
+
+	config["createDatabase"] = true
+	i := getTestInfluxDBHandler(12, 13, 14)
+	i.Configure(config)
+	assert.True(t, i.ensureDatabase())
+	assert.Equal(t, []string{`CREATE DATABASE "metrics"`}, queries)
+
+	assert.False(t, i.emitMetrics([]metric.Metric{metric.WithValue("load", 1)}))
+	assert.Equal(t, 2, len(queries), "a missing database is created again")
+}
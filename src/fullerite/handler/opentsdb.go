@@ -39,6 +39,7 @@ func newOpenTSDBHandler(
 	inst.timeout = initialTimeout
 	inst.log = log
 	inst.channel = channel
+	inst.sanitizer = openTSDBSanitizePolicy()
 
 	return inst
 }
@@ -66,6 +67,7 @@ func (h *OpenTSDBHandler) Configure(configMap map[string]interface{}) {
 	} else {
 		h.log.Error("There was no port specified for the OpenTSDB Handler, there won't be any emissions")
 	}
+	h.sanitizer = openTSDBSanitizePolicy()
 	h.configureCommonParams(configMap)
 }
 
@@ -77,13 +79,13 @@ func (h *OpenTSDBHandler) Run() {
 func (h OpenTSDBHandler) convertToOpenTSDBHandler(incomingMetric metric.Metric) (datapoint string) {
 	//orders dimensions so datapoint keeps consistent name
 	var keys []string
-	dimensions := incomingMetric.GetDimensions(h.DefaultDimensions())
+	name, dimensions := h.sanitizer.apply(incomingMetric.Name, incomingMetric.GetDimensions(h.DefaultDimensions()))
 	for k := range dimensions {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
 
-	datapoint = fmt.Sprintf("put %s", incomingMetric.Name)
+	datapoint = fmt.Sprintf("put %s", name)
 	var dims []string
 	for _, key := range keys {
 		dims = append(dims, fmt.Sprintf("%s=%s", key, dimensions[key]))
@@ -112,7 +114,9 @@ func (h *OpenTSDBHandler) emitMetrics(metrics []metric.Metric) bool {
 	}
 
 	for _, m := range metrics {
-		fmt.Fprintf(conn, h.convertToOpenTSDBHandler(m))
+		for _, expanded := range expandDistribution(m, h.Percentiles()) {
+			fmt.Fprint(conn, h.convertToOpenTSDBHandler(expanded))
+		}
 	}
 	return true
 }
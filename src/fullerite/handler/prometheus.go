@@ -0,0 +1,353 @@
+package handler
+
+import (
+	"fullerite/config"
+	"fullerite/metric"
+
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	l "github.com/Sirupsen/logrus"
+)
+
+func init() {
+	RegisterHandler("Prometheus", newPrometheus)
+}
+
+// Defaults for the Prometheus handler
+const (
+	DefaultPrometheusPort = "9108"
+	DefaultPrometheusPath = "/metrics"
+	// DefaultPrometheusSeriesTTL is the number of seconds a series which
+	// is not emitted anymore is still exposed
+	DefaultPrometheusSeriesTTL = 300
+)
+
+// prometheusInvalidLabel matches the characters Prometheus does not accept
+// in label names
+var prometheusInvalidLabel = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// Prometheus type
+// It exposes the last value of every series for Prometheus to scrape.
+// Histograms with buckets are exposed as native Prometheus histograms,
+// their buckets, count and sum accumulate across emissions. Histograms and
+// timers with samples are exposed as summaries with the configured
+// percentiles as quantiles.
+type Prometheus struct {
+	BaseHandler
+	port      string
+	path      string
+	seriesTTL time.Duration
+
+	mu     sync.Mutex
+	series map[string]*prometheusSeries
+}
+
+// prometheusSeries is the state of one exposed series
+type prometheusSeries struct {
+	name       string
+	labels     map[string]string
+	metricType string
+	value      float64
+	updated    time.Time
+
+	// accumulated histogram or summary
+	bounds    []float64
+	buckets   []uint64
+	count     uint64
+	sum       float64
+	quantiles map[float64]float64
+}
+
+// newPrometheus returns a new Prometheus handler.
+func newPrometheus(
+	channel chan metric.Metric,
+	initialInterval int,
+	initialBufferSize int,
+	initialTimeout time.Duration,
+	log *l.Entry) Handler {
+
+	inst := new(Prometheus)
+	inst.name = "Prometheus"
+
+	inst.interval = initialInterval
+	inst.maxBufferSize = initialBufferSize
+	inst.timeout = initialTimeout
+	inst.log = log
+	inst.channel = channel
+	inst.sanitizer = prometheusSanitizePolicy()
+	inst.port = DefaultPrometheusPort
+	inst.path = DefaultPrometheusPath
+	inst.seriesTTL = DefaultPrometheusSeriesTTL * time.Second
+	inst.series = make(map[string]*prometheusSeries)
+
+	return inst
+}
+
+// Port returns the port metrics are exposed on
+func (p *Prometheus) Port() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.port
+}
+
+// Configure accepts the different configuration options for the Prometheus handler
+func (p *Prometheus) Configure(configMap map[string]interface{}) {
+	if port, exists := configMap["port"]; exists {
+		p.port = fmt.Sprint(port)
+	}
+	if path, exists := configMap["path"]; exists {
+		p.path = fmt.Sprint(path)
+	}
+	if ttl, exists := configMap["seriesTTL"]; exists {
+		p.seriesTTL = time.Duration(config.GetAsInt(ttl, DefaultPrometheusSeriesTTL)) * time.Second
+	}
+	p.sanitizer = prometheusSanitizePolicy()
+	p.configureCommonParams(configMap)
+}
+
+// Run exposes the metrics and runs the handler main loop
+func (p *Prometheus) Run() {
+	go p.serve()
+	p.run(p.emitMetrics)
+}
+
+func (p *Prometheus) serve() {
+	ln, err := net.Listen("tcp", ":"+p.port)
+	if err != nil {
+		p.log.Error("Cannot listen on the Prometheus port ", p.port, ": ", err)
+		return
+	}
+	// figure out the port bind for Port()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	p.mu.Lock()
+	p.port = port
+	p.mu.Unlock()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(p.path, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		p.render(w)
+	})
+	if err := http.Serve(ln, mux); err != nil {
+		p.log.Error("Prometheus HTTP server stopped: ", err)
+	}
+}
+
+// emitMetrics updates the exposed series
+func (p *Prometheus) emitMetrics(metrics []metric.Metric) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, m := range metrics {
+		name, dimensions := p.sanitizer.apply(p.Prefix()+m.Name, m.GetDimensions(p.DefaultDimensions()))
+		if startsWithDigit(name) {
+			// names may not start with a digit
+			name = "_" + name
+		}
+		labels := make(map[string]string, len(dimensions))
+		for key, value := range dimensions {
+			labels[prometheusLabelName(key)] = value
+		}
+		key := prometheusSeriesKey(name, labels)
+
+		s, exists := p.series[key]
+		if !exists || s.metricType != prometheusType(m) {
+			s = &prometheusSeries{name: name, labels: labels, metricType: prometheusType(m)}
+			p.series[key] = s
+		}
+		s.update(m, p.Percentiles())
+		s.updated = time.Now()
+	}
+	return true
+}
+
+// prometheusLabelName makes key a valid label name, it may not start with
+// a digit and names starting with __ are reserved for Prometheus
+func prometheusLabelName(key string) string {
+	name := prometheusInvalidLabel.ReplaceAllLiteralString(key, "_")
+	switch {
+	case strings.HasPrefix(name, "__"):
+		name = "_" + strings.TrimLeft(name, "_")
+	case name == "" || startsWithDigit(name):
+		name = "_" + name
+	}
+	return name
+}
+
+func startsWithDigit(s string) bool {
+	return s != "" && s[0] >= '0' && s[0] <= '9'
+}
+
+// prometheusType maps a metric type to its Prometheus type. Counters are
+// increases per interval, which Prometheus calls gauges.
+func prometheusType(m metric.Metric) string {
+	switch {
+	case m.IsDistribution() && len(m.Distribution.Buckets) > 0:
+		return "histogram"
+	case m.IsDistribution():
+		return "summary"
+	case m.MetricType == metric.CumulativeCounter:
+		return "counter"
+	}
+	return "gauge"
+}
+
+func (s *prometheusSeries) update(m metric.Metric, percentiles []float64) {
+	if !m.IsDistribution() {
+		s.value = m.Value
+		return
+	}
+	d := m.Distribution
+	s.count += d.Count
+	s.sum += d.Sum
+	if s.metricType == "summary" {
+		s.quantiles = make(map[float64]float64, len(percentiles))
+		if d.Count > 0 {
+			for _, percentile := range percentiles {
+				s.quantiles[percentile/100] = d.Quantile(percentile / 100)
+			}
+		}
+		return
+	}
+
+	if !sameBounds(s.bounds, d.Buckets) {
+		// the buckets changed, start over
+		s.bounds = make([]float64, len(d.Buckets))
+		for i, bucket := range d.Buckets {
+			s.bounds[i] = bucket.UpperBound
+		}
+		s.buckets = make([]uint64, len(d.Buckets))
+		s.count = d.Count
+		s.sum = d.Sum
+	}
+	for i, bucket := range d.Buckets {
+		s.buckets[i] += bucket.Count
+	}
+}
+
+func sameBounds(bounds []float64, buckets []metric.Bucket) bool {
+	if len(bounds) != len(buckets) {
+		return false
+	}
+	for i, bucket := range buckets {
+		if bounds[i] != bucket.UpperBound {
+			return false
+		}
+	}
+	return true
+}
+
+// render writes the series in the Prometheus text format, series not
+// updated for seriesTTL are dropped
+func (p *Prometheus) render(w io.Writer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	byName := make(map[string][]*prometheusSeries)
+	for key, s := range p.series {
+		if p.seriesTTL > 0 && time.Since(s.updated) > p.seriesTTL {
+			delete(p.series, key)
+			continue
+		}
+		byName[s.name] = append(byName[s.name], s)
+	}
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var out bytes.Buffer
+	for _, name := range names {
+		series := byName[name]
+		sort.Sort(prometheusSeriesByLabels(series))
+		fmt.Fprintf(&out, "# TYPE %s %s\n", name, series[0].metricType)
+		for _, s := range series {
+			if s.metricType != series[0].metricType {
+				// a name has a single type
+				continue
+			}
+			s.write(&out)
+		}
+	}
+	w.Write(out.Bytes())
+}
+
+func (s *prometheusSeries) write(out *bytes.Buffer) {
+	switch s.metricType {
+	case "histogram":
+		for i, bound := range s.bounds {
+			fmt.Fprintf(out, "%s_bucket%s %d\n", s.name,
+				prometheusLabels(s.labels, "le", prometheusFloat(bound)), s.buckets[i])
+		}
+		fmt.Fprintf(out, "%s_bucket%s %d\n", s.name, prometheusLabels(s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(out, "%s_sum%s %s\n", s.name, prometheusLabels(s.labels, "", ""), prometheusFloat(s.sum))
+		fmt.Fprintf(out, "%s_count%s %d\n", s.name, prometheusLabels(s.labels, "", ""), s.count)
+	case "summary":
+		quantiles := make([]float64, 0, len(s.quantiles))
+		for q := range s.quantiles {
+			quantiles = append(quantiles, q)
+		}
+		sort.Float64s(quantiles)
+		for _, q := range quantiles {
+			fmt.Fprintf(out, "%s%s %s\n", s.name,
+				prometheusLabels(s.labels, "quantile", prometheusFloat(q)), prometheusFloat(s.quantiles[q]))
+		}
+		fmt.Fprintf(out, "%s_sum%s %s\n", s.name, prometheusLabels(s.labels, "", ""), prometheusFloat(s.sum))
+		fmt.Fprintf(out, "%s_count%s %d\n", s.name, prometheusLabels(s.labels, "", ""), s.count)
+	default:
+		fmt.Fprintf(out, "%s%s %s\n", s.name, prometheusLabels(s.labels, "", ""), prometheusFloat(s.value))
+	}
+}
+
+// prometheusLabels formats the labels sorted by name, extra is added
+// unless it is empty
+func prometheusLabels(labels map[string]string, extra, extraValue string) string {
+	pairs := make([]string, 0, len(labels)+1)
+	for name, value := range labels {
+		pairs = append(pairs, name+"="+strconv.Quote(value))
+	}
+	sort.Strings(pairs)
+	if extra != "" {
+		pairs = append(pairs, extra+"="+strconv.Quote(extraValue))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func prometheusFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func prometheusSeriesKey(name string, labels map[string]string) string {
+	return name + prometheusLabels(labels, "", "")
+}
+
+type prometheusSeriesByLabels []*prometheusSeries
+
+func (s prometheusSeriesByLabels) Len() int      { return len(s) }
+func (s prometheusSeriesByLabels) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s prometheusSeriesByLabels) Less(i, j int) bool {
+	return prometheusLabels(s[i].labels, "", "") < prometheusLabels(s[j].labels, "", "")
+}
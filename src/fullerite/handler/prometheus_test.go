@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"fullerite/metric"
+
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	l "github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getTestPrometheusHandler(interval, buffsize, timeoutsec int) *Prometheus {
+	testChannel := make(chan metric.Metric)
+	testLog := l.WithField("testing", "prometheus_handler")
+	timeout := time.Duration(timeoutsec) * time.Second
+
+	return newPrometheus(testChannel, interval, buffsize, timeout, testLog).(*Prometheus)
+}
+
+func renderPrometheus(p *Prometheus) string {
+	var out bytes.Buffer
+	p.render(&out)
+	return out.String()
+}
+
+func TestPrometheusConfigure(t *testing.T) {
+	config := map[string]interface{}{
+		"interval":  "10",
+		"port":      10101,
+		"path":      "/prom",
+		"seriesTTL": "60",
+	}
+
+	p := getTestPrometheusHandler(12, 13, 14)
+	p.Configure(config)
+
+	assert.Equal(t, 10, p.Interval())
+	assert.Equal(t, "10101", p.Port())
+	assert.Equal(t, "/prom", p.path)
+	assert.Equal(t, time.Minute, p.seriesTTL)
+}
+
+func TestPrometheusRenderGaugesAndCounters(t *testing.T) {
+	p := getTestPrometheusHandler(12, 13, 14)
+	p.Configure(map[string]interface{}{})
+
+	gauge := metric.WithValue("cpu.usage", 0.5)
+	gauge.AddDimension("host", "a\"b")
+	gauge.AddDimension("dc-name", "x")
+	counter := metric.WithValue("requests", 3)
+	counter.MetricType = metric.CumulativeCounter
+	p.emitMetrics([]metric.Metric{gauge, counter})
+
+	expected := "# TYPE cpu_usage gauge\n" +
+		"cpu_usage{dc_name=\"x\",host=\"a\\\"b\"} 0.5\n" +
+		"# TYPE requests counter\n" +
+		"requests 3\n"
+	assert.Equal(t, expected, renderPrometheus(p))
+}
+
+func TestPrometheusNames(t *testing.T) {
+	p := getTestPrometheusHandler(12, 13, 14)
+	p.Configure(map[string]interface{}{})
+	p.SetPrefix("app.v1.")
+
+	m := metric.WithValue("requests", 1)
+	m.AddDimension("1xx", "a")
+	m.AddDimension("__name__", "b")
+	p.emitMetrics([]metric.Metric{m})
+
+	expected := "# TYPE app_v1_requests gauge\n" +
+		"app_v1_requests{_1xx=\"a\",_name__=\"b\"} 1\n"
+	assert.Equal(t, expected, renderPrometheus(p))
+
+	assert.Equal(t, "_", prometheusLabelName(""))
+	assert.Equal(t, "_x", prometheusLabelName("__x"))
+	assert.Equal(t, "_9", prometheusLabelName("9"))
+}
+
+func TestPrometheusRenderNativeHistogram(t *testing.T) {
+	p := getTestPrometheusHandler(12, 13, 14)
+	p.Configure(map[string]interface{}{})
+
+	recorder := metric.NewHistogramRecorder(10, 100)
+	recorder.Observe(5)
+	recorder.Observe(50)
+	recorder.Observe(500)
+	p.emitMetrics([]metric.Metric{recorder.Flush("latency")})
+	recorder.Observe(7)
+	p.emitMetrics([]metric.Metric{recorder.Flush("latency")})
+
+	expected := "# TYPE latency histogram\n" +
+		"latency_bucket{le=\"10\"} 2\n" +
+		"latency_bucket{le=\"100\"} 3\n" +
+		"latency_bucket{le=\"+Inf\"} 4\n" +
+		"latency_sum 562\n" +
+		"latency_count 4\n"
+	assert.Equal(t, expected, renderPrometheus(p))
+}
+
+func TestPrometheusRenderSummary(t *testing.T) {
+	p := getTestPrometheusHandler(12, 13, 14)
+	p.Configure(map[string]interface{}{"percentiles": []interface{}{50.0, 100.0}})
+
+	recorder := metric.NewTimerRecorder()
+	recorder.Observe(1)
+	recorder.Observe(3)
+	p.emitMetrics([]metric.Metric{recorder.Flush("query.time")})
+
+	expected := "# TYPE query_time summary\n" +
+		"query_time{quantile=\"0.5\"} 2\n" +
+		"query_time{quantile=\"1\"} 3\n" +
+		"query_time_sum 4\n" +
+		"query_time_count 2\n"
+	assert.Equal(t, expected, renderPrometheus(p))
+}
+
+func TestPrometheusDropsExpiredSeries(t *testing.T) {
+	p := getTestPrometheusHandler(12, 13, 14)
+	p.Configure(map[string]interface{}{"seriesTTL": 1})
+
+	p.emitMetrics([]metric.Metric{metric.WithValue("stale", 1)})
+	p.series["stale"].updated = time.Now().Add(-2 * time.Second)
+
+	assert.Equal(t, "", renderPrometheus(p))
+	assert.Empty(t, p.series)
+}
+
+func TestPrometheusServesMetrics(t *testing.T) {
+	p := getTestPrometheusHandler(12, 13, 14)
+	p.Configure(map[string]interface{}{"port": "0"})
+	p.emitMetrics([]metric.Metric{metric.WithValue("up", 1)})
+
+	go p.serve()
+	var resp *http.Response
+	var err error
+	for i := 0; i < 50; i++ {
+		if port := p.Port(); port != "0" {
+			resp, err = http.Get("http://localhost:" + port + "/metrics")
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.NotNil(t, resp)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "text/plain; version=0.0.4", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "# TYPE up gauge\nup 1\n", string(body))
+}
@@ -0,0 +1,257 @@
+package handler
+
+import (
+	"fullerite/config"
+	"fullerite/metric"
+
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"sync/atomic"
+)
+
+// The actions a relabel rule can take
+const (
+	relabelKeep             = "keep"
+	relabelDrop             = "drop"
+	relabelRename           = "rename"
+	relabelAddDimension     = "add_dimension"
+	relabelRemoveDimension  = "remove_dimension"
+	relabelReplaceDimension = "replace_dimension"
+	relabelHashDimension    = "hash_dimension"
+	relabelDimensionToName  = "dimension_to_name"
+	relabelNameToDimension  = "name_to_dimension"
+)
+
+// relabelRule is one step of the relabel pipeline. A rule selects the metrics
+// whose name matches match and whose dimensions match all of dimensions, both
+// are optional, and then applies its action to them:
+//
+//	keep               drop every metric which is not selected
+//	drop               drop the selected metrics
+//	rename             set the name to replacement, $1 etc. refer to match
+//	add_dimension      set dimension to value, $1 etc. refer to match
+//	remove_dimension   remove dimension
+//	replace_dimension  set dimension to replacement if its value matches regex
+//	hash_dimension     replace the value of dimension by its hash, modulo modulus if set
+//	dimension_to_name  append separator and the value of dimension to the name
+//	name_to_dimension  set dimension to value (default $1) and, if set, the name to replacement
+//
+// hits counts the metrics the action was applied to.
+type relabelRule struct {
+	name        string
+	action      string
+	match       *regexp.Regexp
+	dimensions  map[string]*regexp.Regexp
+	dimension   string
+	value       string
+	regex       *regexp.Regexp
+	replacement string
+	separator   string
+	modulus     uint64
+	hits        uint64
+}
+
+// relabelRules are applied in order, a dropped metric skips the remaining rules
+type relabelRules []*relabelRule
+
+// parseRelabelRules reads a list of rule maps, invalid rules are logged and
+// skipped. Rules without a name are named after prefix, position and action.
+func parseRelabelRules(value interface{}, prefix string) relabelRules {
+	rules := relabelRules{}
+	list, ok := value.([]interface{})
+	if !ok {
+		defaultLog.Warn("Expected a list of relabel rules but got ", value)
+		return rules
+	}
+	for i, item := range list {
+		spec, ok := item.(map[string]interface{})
+		if !ok {
+			defaultLog.Warn("Expected a relabel rule but got ", item)
+			continue
+		}
+		rule, err := parseRelabelRule(spec)
+		if err != nil {
+			defaultLog.Warn("Skipping relabel rule ", i, " of ", prefix, ": ", err)
+			continue
+		}
+		if rule.name == "" {
+			rule.name = fmt.Sprintf("%s.%d.%s", prefix, i, rule.action)
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func parseRelabelRule(spec map[string]interface{}) (*relabelRule, error) {
+	rule := &relabelRule{separator: ".", regex: regexp.MustCompile("^(.*)$")}
+	rule.name, _ = spec["name"].(string)
+	rule.action, _ = spec["action"].(string)
+	rule.dimension, _ = spec["dimension"].(string)
+	rule.value, _ = spec["value"].(string)
+	rule.replacement, _ = spec["replacement"].(string)
+	if separator, ok := spec["separator"].(string); ok {
+		rule.separator = separator
+	}
+	if modulus, exists := spec["modulus"]; exists {
+		rule.modulus = uint64(config.GetAsInt(modulus, 0))
+	}
+
+	var err error
+	if match, ok := spec["match"].(string); ok {
+		if rule.match, err = regexp.Compile(match); err != nil {
+			return nil, err
+		}
+	}
+	if regex, ok := spec["regex"].(string); ok {
+		if rule.regex, err = regexp.Compile(regex); err != nil {
+			return nil, err
+		}
+	}
+	if dims, exists := spec["dimensions"]; exists {
+		rule.dimensions = make(map[string]*regexp.Regexp)
+		for dim, pattern := range config.GetAsMap(dims) {
+			if rule.dimensions[dim], err = regexp.Compile(pattern); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	switch rule.action {
+	case relabelKeep, relabelDrop:
+	case relabelRename:
+		if rule.match == nil || rule.replacement == "" {
+			return nil, fmt.Errorf("%s needs match and replacement", rule.action)
+		}
+	case relabelNameToDimension:
+		if rule.match == nil || rule.dimension == "" {
+			return nil, fmt.Errorf("%s needs match and dimension", rule.action)
+		}
+		if rule.value == "" {
+			rule.value = "$1"
+		}
+	case relabelAddDimension, relabelRemoveDimension, relabelReplaceDimension,
+		relabelHashDimension, relabelDimensionToName:
+		if rule.dimension == "" {
+			return nil, fmt.Errorf("%s needs a dimension", rule.action)
+		}
+	default:
+		return nil, fmt.Errorf("unknown action %q", rule.action)
+	}
+	return rule, nil
+}
+
+// apply runs the rules on a copy of m's dimensions, so that metrics shared
+// with other handlers are not modified. It returns false if m is dropped.
+func (rules relabelRules) apply(m metric.Metric) (metric.Metric, bool) {
+	if len(rules) == 0 {
+		return m, true
+	}
+	dims := make(map[string]string, len(m.Dimensions))
+	for k, v := range m.Dimensions {
+		dims[k] = v
+	}
+	m.Dimensions = dims
+
+	for _, rule := range rules {
+		selected := rule.selects(m)
+		if rule.action == relabelKeep {
+			if !selected {
+				return m, false
+			}
+			atomic.AddUint64(&rule.hits, 1)
+			continue
+		}
+		if !selected || !rule.applyAction(&m) {
+			continue
+		}
+		atomic.AddUint64(&rule.hits, 1)
+		if rule.action == relabelDrop {
+			return m, false
+		}
+	}
+	return m, true
+}
+
+func (rule *relabelRule) selects(m metric.Metric) bool {
+	if rule.match != nil && !rule.match.MatchString(m.Name) {
+		return false
+	}
+	for dim, pattern := range rule.dimensions {
+		value, exists := m.Dimensions[dim]
+		if !exists || !pattern.MatchString(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// applyAction changes m and returns whether the action applied
+func (rule *relabelRule) applyAction(m *metric.Metric) bool {
+	value, exists := m.Dimensions[rule.dimension]
+	switch rule.action {
+	case relabelDrop:
+		return true
+	case relabelRename:
+		m.Name = expandRelabel(rule.match, rule.replacement, m.Name)
+	case relabelAddDimension:
+		m.AddDimension(rule.dimension, expandRelabel(rule.match, rule.value, m.Name))
+	case relabelRemoveDimension:
+		if !exists {
+			return false
+		}
+		m.RemoveDimension(rule.dimension)
+	case relabelReplaceDimension:
+		if !exists || !rule.regex.MatchString(value) {
+			return false
+		}
+		m.AddDimension(rule.dimension, expandRelabel(rule.regex, rule.replacement, value))
+	case relabelHashDimension:
+		if !exists {
+			return false
+		}
+		h := fnv.New64a()
+		h.Write([]byte(value))
+		hash := h.Sum64()
+		if rule.modulus > 0 {
+			m.Dimensions[rule.dimension] = strconv.FormatUint(hash%rule.modulus, 10)
+		} else {
+			m.Dimensions[rule.dimension] = strconv.FormatUint(hash, 16)
+		}
+	case relabelDimensionToName:
+		if !exists {
+			return false
+		}
+		m.Name = m.Name + rule.separator + value
+		m.RemoveDimension(rule.dimension)
+	case relabelNameToDimension:
+		m.AddDimension(rule.dimension, expandRelabel(rule.match, rule.value, m.Name))
+		if rule.replacement != "" {
+			m.Name = expandRelabel(rule.match, rule.replacement, m.Name)
+		}
+	}
+	return true
+}
+
+// expandRelabel replaces $1, ${name} etc. in template by the groups of re
+// matching src, template is returned as is without re.
+func expandRelabel(re *regexp.Regexp, template string, src string) string {
+	if re == nil {
+		return template
+	}
+	match := re.FindStringSubmatchIndex(src)
+	if match == nil {
+		return template
+	}
+	return string(re.ExpandString(nil, template, src, match))
+}
+
+// hitCounters returns the number of hits per rule name
+func (rules relabelRules) hitCounters() map[string]float64 {
+	counters := make(map[string]float64, len(rules))
+	for _, rule := range rules {
+		counters["relabel."+rule.name] = float64(atomic.LoadUint64(&rule.hits))
+	}
+	return counters
+}
@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"fullerite/metric"
+
+	"encoding/json"
+	"testing"
+	"time"
+
+	l "github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parseTestRelabelRules(t *testing.T, raw string) relabelRules {
+	var value interface{}
+	require.Nil(t, json.Unmarshal([]byte(raw), &value))
+	return parseRelabelRules(value, "handler")
+}
+
+func testRelabelMetric(name string, dims map[string]string) metric.Metric {
+	m := metric.New(name)
+	m.AddDimensions(dims)
+	return m
+}
+
+func TestParseRelabelRules(t *testing.T) {
+	rules := parseTestRelabelRules(t, `[
+		{"action": "drop", "match": "^debug\\."},
+		{"name": "tidy", "action": "rename", "match": "^(.*)$", "replacement": "app.$1"},
+		{"action": "rename", "match": "^x$"},
+		{"action": "explode"},
+		{"action": "keep", "match": "("},
+		{"action": "name_to_dimension", "match": "^cpu\\.(\\d+)$", "dimension": "cpu"}
+	]`)
+
+	require.Equal(t, 3, len(rules), "invalid rules should be skipped")
+	assert.Equal(t, "handler.0.drop", rules[0].name)
+	assert.Equal(t, "tidy", rules[1].name)
+	assert.Equal(t, "$1", rules[2].value, "name_to_dimension should default to the first group")
+}
+
+func TestRelabelKeepAndDrop(t *testing.T) {
+	rules := parseTestRelabelRules(t, `[
+		{"action": "keep", "dimensions": {"env": "^prod"}},
+		{"action": "drop", "match": "^debug\\."}
+	]`)
+
+	_, keep := rules.apply(testRelabelMetric("cpu", map[string]string{"env": "prod-east"}))
+	assert.True(t, keep)
+	_, keep = rules.apply(testRelabelMetric("cpu", map[string]string{"env": "dev"}))
+	assert.False(t, keep)
+	_, keep = rules.apply(testRelabelMetric("cpu", nil))
+	assert.False(t, keep, "a missing dimension does not match")
+	_, keep = rules.apply(testRelabelMetric("debug.cpu", map[string]string{"env": "prod"}))
+	assert.False(t, keep)
+
+	assert.Equal(t, map[string]float64{"relabel.handler.0.keep": 2, "relabel.handler.1.drop": 1}, rules.hitCounters())
+}
+
+func TestRelabelNameRules(t *testing.T) {
+	rules := parseTestRelabelRules(t, `[
+		{"action": "rename", "match": "^servers\\.(\\w+)\\.(.*)$", "replacement": "$2"},
+		{"action": "add_dimension", "dimension": "source", "value": "fullerite"},
+		{"action": "name_to_dimension", "match": "^cpu\\.(\\d+)\\.(\\w+)$", "dimension": "cpu", "replacement": "cpu.$2"},
+		{"action": "dimension_to_name", "dimension": "state", "separator": "_"}
+	]`)
+
+	m, keep := rules.apply(testRelabelMetric("servers.web01.cpu.3.idle", map[string]string{"state": "busy"}))
+	require.True(t, keep)
+	assert.Equal(t, "cpu.idle_busy", m.Name)
+	assert.Equal(t, map[string]string{"source": "fullerite", "cpu": "3"}, m.Dimensions)
+
+	m, _ = rules.apply(testRelabelMetric("memory.free", nil))
+	assert.Equal(t, "memory.free", m.Name)
+	assert.Equal(t, map[string]string{"source": "fullerite"}, m.Dimensions)
+
+	assert.Equal(t, map[string]float64{
+		"relabel.handler.0.rename":            1,
+		"relabel.handler.1.add_dimension":     2,
+		"relabel.handler.2.name_to_dimension": 1,
+		"relabel.handler.3.dimension_to_name": 1,
+	}, rules.hitCounters())
+}
+
+func TestRelabelDimensionRules(t *testing.T) {
+	rules := parseTestRelabelRules(t, `[
+		{"action": "remove_dimension", "dimension": "pid"},
+		{"action": "replace_dimension", "dimension": "host", "regex": "^([^.]+)\\..*$", "replacement": "$1"},
+		{"action": "hash_dimension", "dimension": "user", "modulus": 16},
+		{"action": "hash_dimension", "dimension": "session"}
+	]`)
+
+	dims := map[string]string{"pid": "42", "host": "web01.example.com", "user": "alice", "session": "abc"}
+	original := testRelabelMetric("requests", dims)
+	m, keep := rules.apply(original)
+	require.True(t, keep)
+
+	assert := assert.New(t)
+	_, hasPid := m.Dimensions["pid"]
+	assert.False(hasPid)
+	assert.Equal("web01", m.Dimensions["host"])
+	assert.Len(m.Dimensions["session"], 16)
+	assert.NotEqual("abc", m.Dimensions["session"])
+
+	again, _ := rules.apply(testRelabelMetric("requests", dims))
+	assert.Equal(m.Dimensions["user"], again.Dimensions["user"], "hashes should be stable")
+	assert.Equal("42", original.Dimensions["pid"], "the original metric should not be modified")
+}
+
+func TestRelabelGlobalAndHandlerRules(t *testing.T) {
+	base := BaseHandler{}
+	base.SetGlobalRelabelRules([]interface{}{
+		map[string]interface{}{"action": "add_dimension", "dimension": "env", "value": "prod"},
+	})
+	base.configureCommonParams(map[string]interface{}{
+		"relabelRules": []interface{}{
+			map[string]interface{}{"action": "drop", "dimensions": map[string]interface{}{"env": "prod"}},
+		},
+	})
+
+	require.Equal(t, 2, len(base.relabelRules))
+	_, keep := base.relabelRules.apply(metric.New("cpu"))
+	assert.False(t, keep, "handler rules should run after the global rules")
+
+	counters := base.InternalMetrics().Counters
+	assert.Equal(t, 1.0, counters["relabel.global.0.add_dimension"])
+	assert.Equal(t, 1.0, counters["relabel.handler.0.drop"])
+}
+
+func TestRelabelBeforeBuffering(t *testing.T) {
+	base := BaseHandler{}
+	base.log = l.WithField("testing", "relabel")
+	base.interval = 1
+	base.maxBufferSize = 1
+	base.channel = make(chan metric.Metric)
+	base.configureCommonParams(map[string]interface{}{
+		"relabelRules": []interface{}{
+			map[string]interface{}{"action": "drop", "match": "^noisy"},
+		},
+	})
+
+	emitted := make(chan []metric.Metric, 2)
+	emitFunc := func(metrics []metric.Metric) bool {
+		emitted <- metrics
+		return true
+	}
+	// run only starts the listeners, calling it directly keeps its reads
+	// of the handler ahead of the first emission
+	base.run(emitFunc)
+
+	base.channel <- metric.New("noisy.metric")
+	base.channel <- metric.New("useful.metric")
+
+	select {
+	case metrics := <-emitted:
+		require.Equal(t, 1, len(metrics))
+		assert.Equal(t, "useful.metric", metrics[0].Name)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no metrics were emitted")
+	}
+	base.channel <- metric.Metric{}
+}
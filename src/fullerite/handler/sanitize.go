@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"fullerite/config"
+
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// sanitizePolicy rewrites metric names, dimension keys and dimension values
+// into what a backend accepts. Characters outside the charsets, which are
+// the contents of a regex character class, are replaced by replacement.
+// Names and dimensions are then truncated to their max length, unless it
+// is 0, and converted to lower or upper case if set.
+//
+// rewritten holds the series, by original name and dimensions, which had to
+// be rewritten. It is bounded by maxSanitizedSeries, further series are not
+// counted.
+type sanitizePolicy struct {
+	nameCharset        string
+	dimensionCharset   string
+	replacement        string
+	maxNameLength      int
+	maxDimensionLength int
+	letterCase         string
+
+	invalidName      *regexp.Regexp
+	invalidDimension *regexp.Regexp
+
+	mu        sync.Mutex
+	rewritten map[string]bool
+}
+
+// maxSanitizedSeries bounds the rewritten series a policy remembers
+const maxSanitizedSeries = 10000
+
+// graphiteSanitizePolicy keeps dots in names only, dimensions become path
+// segments of their own
+func graphiteSanitizePolicy() *sanitizePolicy {
+	return newSanitizePolicy(`a-zA-Z0-9_.\-`, `a-zA-Z0-9_\-`, "_")
+}
+
+// graphiteTaggedSanitizePolicy leaves out the characters which separate
+// the tags of a tagged series
+func graphiteTaggedSanitizePolicy() *sanitizePolicy {
+	return newSanitizePolicy(`a-zA-Z0-9_.\-`, `a-zA-Z0-9_.:/\-`, "_")
+}
+
+// openTSDBSanitizePolicy allows the characters OpenTSDB accepts in metric
+// names, tag keys and tag values
+func openTSDBSanitizePolicy() *sanitizePolicy {
+	return newSanitizePolicy(`\p{L}0-9_./\-`, `\p{L}0-9_./\-`, "_")
+}
+
+// influxDBSanitizePolicy leaves out whitespace, commas, equal signs and
+// quotes, which the line protocol has to escape
+func influxDBSanitizePolicy() *sanitizePolicy {
+	return newSanitizePolicy(`\p{L}\p{N}_.:/\-`, `\p{L}\p{N}_.:/\-`, "_")
+}
+
+// prometheusSanitizePolicy allows the characters of Prometheus metric
+// names, label names are made valid separately as label values may contain
+// any character
+func prometheusSanitizePolicy() *sanitizePolicy {
+	return newSanitizePolicy(`a-zA-Z0-9_:`, `\s\S`, "_")
+}
+
+func newSanitizePolicy(nameCharset, dimensionCharset, replacement string) *sanitizePolicy {
+	p := &sanitizePolicy{
+		nameCharset:      nameCharset,
+		dimensionCharset: dimensionCharset,
+		replacement:      replacement,
+	}
+	p.compile()
+	return p
+}
+
+func (p *sanitizePolicy) compile() error {
+	invalidName, err := regexp.Compile("[^" + p.nameCharset + "]")
+	if err != nil {
+		return err
+	}
+	invalidDimension, err := regexp.Compile("[^" + p.dimensionCharset + "]")
+	if err != nil {
+		return err
+	}
+	p.invalidName = invalidName
+	p.invalidDimension = invalidDimension
+	return nil
+}
+
+// parseSanitizePolicy reads the sanitize option on top of the handler's
+// default policy. false turns sanitization off.
+func parseSanitizePolicy(value interface{}, defaults *sanitizePolicy) *sanitizePolicy {
+	if enabled, ok := value.(bool); ok && !enabled {
+		return nil
+	}
+	spec, ok := value.(map[string]interface{})
+	if !ok {
+		defaultLog.Warn("Expected a sanitize policy but got ", value)
+		return defaults
+	}
+
+	p := &sanitizePolicy{nameCharset: `\s\S`, dimensionCharset: `\s\S`, replacement: "_"}
+	if defaults != nil {
+		p.nameCharset = defaults.nameCharset
+		p.dimensionCharset = defaults.dimensionCharset
+		p.replacement = defaults.replacement
+		p.maxNameLength = defaults.maxNameLength
+		p.maxDimensionLength = defaults.maxDimensionLength
+		p.letterCase = defaults.letterCase
+	}
+	if charset, ok := spec["nameCharset"].(string); ok {
+		p.nameCharset = charset
+	}
+	if charset, ok := spec["dimensionCharset"].(string); ok {
+		p.dimensionCharset = charset
+	}
+	if replacement, ok := spec["replacement"].(string); ok {
+		p.replacement = replacement
+	}
+	if length, exists := spec["maxNameLength"]; exists {
+		p.maxNameLength = config.GetAsInt(length, 0)
+	}
+	if length, exists := spec["maxDimensionLength"]; exists {
+		p.maxDimensionLength = config.GetAsInt(length, 0)
+	}
+	if letterCase, ok := spec["case"].(string); ok {
+		switch letterCase {
+		case "lower", "upper", "":
+			p.letterCase = letterCase
+		default:
+			defaultLog.Warn("Unknown sanitize case ", letterCase, ", expected lower or upper")
+		}
+	}
+
+	if err := p.compile(); err != nil {
+		defaultLog.Warn("Invalid sanitize charset, keeping the default policy: ", err)
+		return defaults
+	}
+	return p
+}
+
+// apply returns the sanitized name and dimensions, dimensions whose keys
+// collide after sanitizing keep one of the values
+func (p *sanitizePolicy) apply(name string, dimensions map[string]string) (string, map[string]string) {
+	if p == nil {
+		return name, dimensions
+	}
+	changed := false
+	sanitizedName := p.sanitize(name, p.invalidName, p.maxNameLength)
+	if sanitizedName != name {
+		changed = true
+	}
+
+	sanitizedDimensions := make(map[string]string, len(dimensions))
+	for key, value := range dimensions {
+		sanitizedKey := p.sanitize(key, p.invalidDimension, p.maxDimensionLength)
+		sanitizedValue := p.sanitize(value, p.invalidDimension, p.maxDimensionLength)
+		if sanitizedKey != key || sanitizedValue != value {
+			changed = true
+		}
+		sanitizedDimensions[sanitizedKey] = sanitizedValue
+	}
+
+	if changed {
+		p.countRewritten(name, dimensions)
+	}
+	return sanitizedName, sanitizedDimensions
+}
+
+func (p *sanitizePolicy) sanitize(s string, invalid *regexp.Regexp, maxLength int) string {
+	s = invalid.ReplaceAllLiteralString(s, p.replacement)
+	if maxLength > 0 {
+		if runes := []rune(s); len(runes) > maxLength {
+			s = string(runes[:maxLength])
+		}
+	}
+	switch p.letterCase {
+	case "lower":
+		s = strings.ToLower(s)
+	case "upper":
+		s = strings.ToUpper(s)
+	}
+	return s
+}
+
+func (p *sanitizePolicy) countRewritten(name string, dimensions map[string]string) {
+	keys := make([]string, 0, len(dimensions))
+	for key, value := range dimensions {
+		keys = append(keys, key+"="+value)
+	}
+	sort.Strings(keys)
+	series := name + "|" + strings.Join(keys, ",")
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.rewritten == nil {
+		p.rewritten = make(map[string]bool)
+	}
+	if len(p.rewritten) < maxSanitizedSeries {
+		p.rewritten[series] = true
+	}
+}
+
+// internalMetrics adds the number of rewritten series
+func (p *sanitizePolicy) internalMetrics(counters map[string]float64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	counters["sanitizedSeries"] = float64(len(p.rewritten))
+}
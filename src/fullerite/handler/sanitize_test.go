@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"fullerite/metric"
+
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGraphiteSanitizePolicy(t *testing.T) {
+	p := graphiteSanitizePolicy()
+	name, dims := p.apply("cpu usage.total", map[string]string{
+		"host":     "web-01.example.com",
+		"dim name": "a/b",
+	})
+	assert.Equal(t, "cpu_usage.total", name)
+	assert.Equal(t, map[string]string{"host": "web-01_example_com", "dim_name": "a_b"}, dims)
+	assert.Equal(t, 1, len(p.rewritten))
+
+	p.apply("clean.name", map[string]string{"host": "web-01"})
+	assert.Equal(t, 1, len(p.rewritten), "clean series are not counted")
+
+	p.apply("cpu usage.total", map[string]string{"host": "web-01.example.com", "dim name": "a/b"})
+	assert.Equal(t, 1, len(p.rewritten), "a series is counted once")
+	p.apply("cpu usage.total", nil)
+	assert.Equal(t, 2, len(p.rewritten), "other dimensions are another series")
+
+	for i := 0; i < maxSanitizedSeries; i++ {
+		p.apply(fmt.Sprintf("series %d", i), nil)
+	}
+	assert.Equal(t, maxSanitizedSeries, len(p.rewritten), "the series are bounded")
+}
+
+func TestOpenTSDBSanitizePolicy(t *testing.T) {
+	p := openTSDBSanitizePolicy()
+	name, dims := p.apply("disk:used", map[string]string{"path": "/var/log", "zone": "zürich 1"})
+	assert.Equal(t, "disk_used", name)
+	assert.Equal(t, map[string]string{"path": "/var/log", "zone": "zürich_1"}, dims)
+}
+
+func TestParseSanitizePolicy(t *testing.T) {
+	assert.Nil(t, parseSanitizePolicy(false, graphiteSanitizePolicy()))
+
+	defaults := graphiteSanitizePolicy()
+	assert.True(t, defaults == parseSanitizePolicy("yes", defaults))
+	assert.True(t, defaults == parseSanitizePolicy(map[string]interface{}{"nameCharset": "z-a"}, defaults),
+		"invalid charsets keep the defaults")
+
+	p := parseSanitizePolicy(map[string]interface{}{
+		"replacement":        "-",
+		"maxNameLength":      8.0,
+		"maxDimensionLength": "3",
+		"case":               "lower",
+	}, defaults)
+	require.NotNil(t, p)
+	name, dims := p.apply("Requests Per Second", map[string]string{"Region": "us west"})
+	assert.Equal(t, "requests", name)
+	assert.Equal(t, map[string]string{"reg": "us-"}, dims)
+
+	// without defaults everything is allowed unless a charset is configured
+	p = parseSanitizePolicy(map[string]interface{}{"case": "upper", "dimensionCharset": "a-z"}, nil)
+	require.NotNil(t, p)
+	name, dims = p.apply("a b:c", map[string]string{"k": "v1"})
+	assert.Equal(t, "A B:C", name)
+	assert.Equal(t, map[string]string{"K": "V_"}, dims)
+}
+
+func TestGraphiteSanitizesDatapoints(t *testing.T) {
+	g := getTestGraphiteHandler(12, 13, 14)
+	g.Configure(map[string]interface{}{"server": "localhost", "port": "2003"})
+
+	m := metric.New("Test Metric")
+	m.AddDimension("host", "web-01.example.com")
+	now := m.GetTime().Unix()
+	assert.Equal(t, fmt.Sprintf("Test_Metric.host.web-01_example_com 0.000000 %d\n", now), g.convertToGraphite(m))
+	assert.Equal(t, 1.0, g.InternalMetrics().Counters["sanitizedSeries"])
+
+	g.Configure(map[string]interface{}{"server": "localhost", "port": "2003", "sanitize": false})
+	assert.Equal(t, fmt.Sprintf("Test Metric.host.web-01.example.com 0.000000 %d\n", now), g.convertToGraphite(m))
+	_, exists := g.InternalMetrics().Counters["sanitizedSeries"]
+	assert.False(t, exists)
+}
+
+func TestOpenTSDBSanitizesDatapoints(t *testing.T) {
+	h := getTestOpenTSDBHandler(12, 13, 14)
+	h.Configure(map[string]interface{}{
+		"server":   "localhost",
+		"port":     "4242",
+		"sanitize": map[string]interface{}{"case": "lower"},
+	})
+
+	m := metric.New("Disk Used")
+	m.AddDimension("Mount", "/var lib")
+	now := m.GetTime().Unix()
+	assert.Equal(t, fmt.Sprintf("put disk_used mount=/var_lib %d 0.000000\n", now), h.convertToOpenTSDBHandler(m))
+}
+
+func TestInfluxDBSanitizesPoints(t *testing.T) {
+	i := getTestInfluxDBHandler(12, 13, 14)
+	i.Configure(map[string]interface{}{
+		"server": "localhost",
+		"port":   "8086",
+		"fieldMappings": []interface{}{
+			map[string]interface{}{"match": "^disk (\\w+)$", "measurement": "disk usage", "field": "$1"},
+		},
+	})
+
+	now := time.Unix(1450000000, 0)
+	mapped := metric.WithValue("disk used", 1)
+	mapped.Time = now
+	mapped.AddDimension("mount point", "/var,lib")
+	unmapped := metric.WithValue("load avg", 2)
+	unmapped.Time = now
+	unmapped.AddDimension("host", "web 01")
+
+	lines := []string{}
+	for _, pt := range i.groupPoints([]metric.Metric{mapped, unmapped}) {
+		lines = append(lines, pt.PrecisionString(i.Precision()))
+	}
+	assert.Equal(t, []string{
+		"load_avg,host=web_01 value=2 1450000000",
+		"disk_usage,mount_point=/var_lib used=1 1450000000",
+	}, lines)
+	assert.Equal(t, 2.0, i.InternalMetrics().Counters["sanitizedSeries"])
+}
+
+func TestSanitizeUnsupportedHandler(t *testing.T) {
+	log, hook := getTestLogHook()
+	h := newLog(make(chan metric.Metric), 12, 13, time.Second, log).(*Log)
+	h.Configure(map[string]interface{}{"sanitize": map[string]interface{}{"case": "lower"}})
+	assert.Nil(t, h.sanitizer)
+	assert.Equal(t, []string{"sanitize is not supported by the Log handler, names are sent unchanged"}, hook.messages)
+	_, exists := h.InternalMetrics().Counters["sanitizedSeries"]
+	assert.False(t, exists)
+
+	hook.messages = nil
+	h.Configure(map[string]interface{}{"sanitize": false})
+	assert.Empty(t, hook.messages, "turning sanitize off is not worth a warning")
+}
@@ -107,6 +107,11 @@ func (h *ZmqBUF) serveReq() {
 		var filter metric.Filter
 		json.Unmarshal([]byte(msg), &filter)
 		h.log.Info("Received request: ", filter)
+		if err := filter.Compile(); err != nil {
+			h.log.Error("Invalid filter name: ", err)
+			h.socket.Send("EOM", 0)
+			continue
+		}
 		reply, _ := h.Match(filter)
 		for _, rep := range reply {
 			h.socket.Send(rep.ToJSON(), zmq.SNDMORE)
@@ -25,6 +25,7 @@ func startHandler(name string, globalConfig config.Config, instanceConfig map[st
 	handlerInst.SetInterval(config.GetAsInt(globalConfig.Interval, handler.DefaultInterval))
 	handlerInst.SetPrefix(globalConfig.Prefix)
 	handlerInst.SetDefaultDimensions(globalConfig.DefaultDimensions)
+	handlerInst.SetGlobalRelabelRules(globalConfig.RelabelRules)
 
 	// now apply the handler level configs
 	handlerInst.Configure(instanceConfig)
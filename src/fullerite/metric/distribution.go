@@ -0,0 +1,192 @@
+package metric
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The metric types carrying a Distribution instead of a single value
+const (
+	Histogram = "histogram"
+	Timer     = "timer"
+)
+
+// Distribution holds the observations of a histogram or timer, either as
+// cumulative bucket counts or as the raw samples
+type Distribution struct {
+	Count   uint64    `json:"count"`
+	Sum     float64   `json:"sum"`
+	Buckets []Bucket  `json:"buckets,omitempty"`
+	Samples []float64 `json:"samples,omitempty"`
+}
+
+// Bucket counts the observations less than or equal to UpperBound, the
+// implicit +Inf bucket is Distribution.Count
+type Bucket struct {
+	UpperBound float64 `json:"le"`
+	Count      uint64  `json:"count"`
+}
+
+// IsDistribution returns true for the metric types carrying a Distribution
+func (m *Metric) IsDistribution() bool {
+	return (m.MetricType == Histogram || m.MetricType == Timer) && m.Distribution != nil
+}
+
+// Mean returns the average observation, 0 without observations
+func (d *Distribution) Mean() float64 {
+	if d.Count == 0 {
+		return 0
+	}
+	return d.Sum / float64(d.Count)
+}
+
+// Min returns the smallest sample, false without samples
+func (d *Distribution) Min() (float64, bool) {
+	if len(d.Samples) == 0 {
+		return 0, false
+	}
+	min := d.Samples[0]
+	for _, sample := range d.Samples[1:] {
+		min = math.Min(min, sample)
+	}
+	return min, true
+}
+
+// Max returns the largest sample, false without samples
+func (d *Distribution) Max() (float64, bool) {
+	if len(d.Samples) == 0 {
+		return 0, false
+	}
+	max := d.Samples[0]
+	for _, sample := range d.Samples[1:] {
+		max = math.Max(max, sample)
+	}
+	return max, true
+}
+
+// Quantile returns the q-quantile (0 <= q <= 1). Samples are interpolated
+// between the closest ranks, buckets linearly within the bucket the rank
+// falls into, which is how Prometheus estimates quantiles. Observations
+// above the largest bound are estimated as that bound.
+func (d *Distribution) Quantile(q float64) float64 {
+	q = math.Max(0, math.Min(1, q))
+	if len(d.Samples) > 0 {
+		return sampleQuantile(d.Samples, q)
+	}
+	if len(d.Buckets) == 0 || d.Count == 0 {
+		return 0
+	}
+
+	rank := q * float64(d.Count)
+	lowerBound := 0.0
+	lowerCount := uint64(0)
+	for _, bucket := range d.Buckets {
+		if float64(bucket.Count) >= rank && bucket.Count > lowerCount {
+			inBucket := float64(bucket.Count - lowerCount)
+			return lowerBound + (bucket.UpperBound-lowerBound)*(rank-float64(lowerCount))/inBucket
+		}
+		lowerBound = bucket.UpperBound
+		lowerCount = bucket.Count
+	}
+	return d.Buckets[len(d.Buckets)-1].UpperBound
+}
+
+func sampleQuantile(samples []float64, q float64) float64 {
+	sorted := make([]float64, len(samples))
+	copy(sorted, samples)
+	sort.Float64s(sorted)
+
+	position := q * float64(len(sorted)-1)
+	lower := int(math.Floor(position))
+	upper := int(math.Ceil(position))
+	weight := position - float64(lower)
+	return sorted[lower]*(1-weight) + sorted[upper]*weight
+}
+
+// Recorder collects observations for a histogram or timer metric and is
+// safe for concurrent use. A recorder with bounds counts the observations
+// per bucket, one without keeps the raw samples.
+type Recorder struct {
+	metricType string
+	bounds     []float64
+
+	mu      sync.Mutex
+	count   uint64
+	sum     float64
+	counts  []uint64
+	samples []float64
+}
+
+// NewHistogramRecorder returns a recorder counting observations in buckets
+// with the given upper bounds, without bounds it keeps the samples
+func NewHistogramRecorder(bounds ...float64) *Recorder {
+	sorted := make([]float64, len(bounds))
+	copy(sorted, bounds)
+	sort.Float64s(sorted)
+	return &Recorder{
+		metricType: Histogram,
+		bounds:     sorted,
+		counts:     make([]uint64, len(sorted)),
+	}
+}
+
+// NewTimerRecorder returns a recorder keeping the samples of a timer
+func NewTimerRecorder() *Recorder {
+	return &Recorder{metricType: Timer}
+}
+
+// Observe records one observation
+func (r *Recorder) Observe(value float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.count++
+	r.sum += value
+	if len(r.bounds) == 0 {
+		r.samples = append(r.samples, value)
+		return
+	}
+	for i, bound := range r.bounds {
+		if value <= bound {
+			r.counts[i]++
+		}
+	}
+}
+
+// ObserveDuration records a duration in milliseconds
+func (r *Recorder) ObserveDuration(d time.Duration) {
+	r.Observe(d.Seconds() * 1000)
+}
+
+// ObserveSince records the milliseconds elapsed since start
+func (r *Recorder) ObserveSince(start time.Time) {
+	r.ObserveDuration(time.Since(start))
+}
+
+// Flush returns the observations recorded since the last flush as a metric
+// called name and starts over. The metric's value is the number of observations.
+func (r *Recorder) Flush(name string) Metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d := &Distribution{Count: r.count, Sum: r.sum, Samples: r.samples}
+	if len(r.bounds) > 0 {
+		d.Buckets = make([]Bucket, len(r.bounds))
+		for i, bound := range r.bounds {
+			d.Buckets[i] = Bucket{UpperBound: bound, Count: r.counts[i]}
+		}
+	}
+
+	m := New(name)
+	m.MetricType = r.metricType
+	m.Value = float64(r.count)
+	m.Distribution = d
+
+	r.count = 0
+	r.sum = 0
+	r.counts = make([]uint64, len(r.bounds))
+	r.samples = nil
+	return m
+}
@@ -0,0 +1,81 @@
+package metric_test
+
+import (
+	"encoding/json"
+	"fullerite/metric"
+
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogramRecorderBuckets(t *testing.T) {
+	r := metric.NewHistogramRecorder(100, 10, 50)
+	for _, v := range []float64{1, 5, 20, 30, 40, 60, 70, 80, 90, 200} {
+		r.Observe(v)
+	}
+
+	m := r.Flush("latency")
+	assert.Equal(t, metric.Histogram, m.MetricType)
+	assert.Equal(t, 10.0, m.Value)
+	require.True(t, m.IsDistribution())
+
+	d := m.Distribution
+	assert.Equal(t, uint64(10), d.Count)
+	assert.Equal(t, 596.0, d.Sum)
+	assert.Equal(t, []metric.Bucket{{10, 2}, {50, 5}, {100, 9}}, d.Buckets)
+	assert.Nil(t, d.Samples)
+	assert.InDelta(t, 59.6, d.Mean(), 1e-9)
+
+	assert.Equal(t, 10.0, d.Quantile(0.2))
+	assert.InDelta(t, 50+50.0/4, d.Quantile(0.6), 1e-9)
+	assert.Equal(t, 100.0, d.Quantile(0.99), "observations above the largest bound")
+	_, ok := d.Min()
+	assert.False(t, ok)
+
+	empty := r.Flush("latency")
+	assert.Equal(t, uint64(0), empty.Distribution.Count)
+	assert.Equal(t, []metric.Bucket{{10, 0}, {50, 0}, {100, 0}}, empty.Distribution.Buckets)
+	assert.Equal(t, 0.0, empty.Distribution.Quantile(0.5))
+}
+
+func TestTimerRecorderSamples(t *testing.T) {
+	r := metric.NewTimerRecorder()
+	r.ObserveDuration(40 * time.Millisecond)
+	r.ObserveDuration(10 * time.Millisecond)
+	r.Observe(30)
+	r.Observe(20)
+
+	m := r.Flush("request_time")
+	assert.Equal(t, metric.Timer, m.MetricType)
+	d := m.Distribution
+	assert.Equal(t, []float64{40, 10, 30, 20}, d.Samples)
+	assert.Equal(t, 10.0, d.Quantile(0))
+	assert.Equal(t, 25.0, d.Quantile(0.5))
+	assert.Equal(t, 40.0, d.Quantile(1))
+	assert.Equal(t, []float64{40, 10, 30, 20}, d.Samples, "Quantile must not sort the samples")
+
+	min, ok := d.Min()
+	assert.True(t, ok)
+	assert.Equal(t, 10.0, min)
+	max, _ := d.Max()
+	assert.Equal(t, 40.0, max)
+
+	assert.Nil(t, r.Flush("request_time").Distribution.Samples)
+}
+
+func TestDistributionJSON(t *testing.T) {
+	r := metric.NewHistogramRecorder(1)
+	r.Observe(0.5)
+	m := r.Flush("h")
+
+	var decoded metric.Metric
+	require.Nil(t, json.Unmarshal([]byte(m.ToJSON()), &decoded))
+	assert.Equal(t, m.Distribution, decoded.Distribution)
+
+	plain := metric.New("g")
+	assert.NotContains(t, plain.ToJSON(), "distribution")
+	assert.False(t, plain.IsDistribution())
+}
@@ -0,0 +1,240 @@
+package metric
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	l "github.com/Sirupsen/logrus"
+)
+
+var defaultLog = l.WithFields(l.Fields{"app": "fullerite", "pkg": "metric"})
+
+// Filter provides a struct that can filter a metric by Name (regex), type, dimension (subset of Dimensions)
+//
+// All fields which are set have to match:
+//
+//	name, nameGlob   the metric name matches the regex or glob (* and ?)
+//	type             the metric type, any type if empty
+//	dimensions       the dimensions have these values
+//	dimensionRegex   the dimensions exist and match the regexes
+//	dimensionGlob    the dimensions exist and match the globs
+//	dimensionAbsent  the dimensions do not exist
+//	value            the value is within the inclusive range
+//	and, or, not     all, any or none of the nested filters match
+//
+// The empty filter matches every metric.
+type Filter struct {
+	Name       string            `json:"name"`
+	MetricType string            `json:"type"`
+	Dimensions map[string]string `json:"dimensions"`
+
+	NameGlob        string            `json:"nameGlob,omitempty"`
+	DimensionRegex  map[string]string `json:"dimensionRegex,omitempty"`
+	DimensionGlob   map[string]string `json:"dimensionGlob,omitempty"`
+	DimensionAbsent []string          `json:"dimensionAbsent,omitempty"`
+	Value           *ValueRange       `json:"value,omitempty"`
+
+	And []Filter `json:"and,omitempty"`
+	Or  []Filter `json:"or,omitempty"`
+	Not *Filter  `json:"not,omitempty"`
+
+	compiled          bool
+	nameRegex         *regexp.Regexp
+	dimensionPatterns map[string]*regexp.Regexp
+}
+
+// ValueRange is an inclusive range, a missing bound is unbounded
+type ValueRange struct {
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+}
+
+// ToJSON Transforms Filter to JSON
+func (f *Filter) ToJSON() string {
+	b, err := json.Marshal(f)
+	if err != nil {
+		fmt.Println(err)
+		return "{}"
+	}
+	return string(b)
+}
+
+// NewFilter returns a Filter with compiled regex, a filter with an invalid
+// regex is logged and matches nothing
+func NewFilter(name string, t string, d map[string]string) Filter {
+	f := Filter{
+		Name:       name,
+		MetricType: t,
+		Dimensions: d,
+	}
+	if err := f.Compile(); err != nil {
+		defaultLog.Error("Invalid filter ", f.ToJSON(), ", it matches no metric: ", err)
+	}
+	return f
+}
+
+// ParseFilter reads and compiles a filter from its JSON representation
+func ParseFilter(raw []byte) (Filter, error) {
+	var f Filter
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return f, err
+	}
+	return f, f.Compile()
+}
+
+// Compile precompiles the regexes and globs of the filter and its nested
+// filters, filters read from JSON should be compiled before they are used
+func (f *Filter) Compile() error {
+	patterns := make(map[string]*regexp.Regexp)
+	for dim, pattern := range f.DimensionRegex {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("dimension %s: %s", dim, err)
+		}
+		patterns[dim] = re
+	}
+	for dim, glob := range f.DimensionGlob {
+		if _, exists := patterns[dim]; exists {
+			return fmt.Errorf("dimension %s has a regex and a glob", dim)
+		}
+		patterns[dim] = globToRegexp(glob)
+	}
+
+	var nameRegex *regexp.Regexp
+	switch {
+	case f.Name != "" && f.NameGlob != "":
+		return fmt.Errorf("filter has a name regex and a name glob")
+	case f.NameGlob != "":
+		nameRegex = globToRegexp(f.NameGlob)
+	case f.Name != "":
+		re, err := regexp.Compile(f.Name)
+		if err != nil {
+			return err
+		}
+		nameRegex = re
+	}
+
+	for i := range f.And {
+		if err := f.And[i].Compile(); err != nil {
+			return err
+		}
+	}
+	for i := range f.Or {
+		if err := f.Or[i].Compile(); err != nil {
+			return err
+		}
+	}
+	if f.Not != nil {
+		if err := f.Not.Compile(); err != nil {
+			return err
+		}
+	}
+
+	f.nameRegex = nameRegex
+	f.dimensionPatterns = patterns
+	f.compiled = true
+	return nil
+}
+
+// clone copies the filter and its nested filters, Compile on the copy does
+// not write to f
+func (f *Filter) clone() Filter {
+	c := *f
+	if f.And != nil {
+		c.And = make([]Filter, len(f.And))
+		for i := range f.And {
+			c.And[i] = f.And[i].clone()
+		}
+	}
+	if f.Or != nil {
+		c.Or = make([]Filter, len(f.Or))
+		for i := range f.Or {
+			c.Or[i] = f.Or[i].clone()
+		}
+	}
+	if f.Not != nil {
+		not := f.Not.clone()
+		c.Not = &not
+	}
+	return c
+}
+
+// globToRegexp converts a glob where * matches any string and ? any
+// character into an anchored regex
+func globToRegexp(glob string) *regexp.Regexp {
+	quoted := regexp.QuoteMeta(glob)
+	quoted = strings.Replace(quoted, `\*`, ".*", -1)
+	quoted = strings.Replace(quoted, `\?`, ".", -1)
+	return regexp.MustCompile("^" + quoted + "$")
+}
+
+// Matches returns true if the metric satisfies the filter. Filters which
+// are not compiled are compiled on every call, without modifying f, so that
+// it can be shared between goroutines. Invalid filters match nothing.
+func (f *Filter) Matches(m *Metric) bool {
+	if !f.compiled {
+		compiled := f.clone()
+		if compiled.Compile() != nil {
+			return false
+		}
+		f = &compiled
+	}
+
+	if f.MetricType != "" && m.MetricType != f.MetricType {
+		return false
+	}
+	if f.nameRegex != nil && !f.nameRegex.MatchString(m.Name) {
+		return false
+	}
+	if !m.IsSubDim(f.Dimensions) {
+		return false
+	}
+	for dim, pattern := range f.dimensionPatterns {
+		value, exists := m.Dimensions[dim]
+		if !exists || !pattern.MatchString(value) {
+			return false
+		}
+	}
+	for _, dim := range f.DimensionAbsent {
+		if _, exists := m.Dimensions[dim]; exists {
+			return false
+		}
+	}
+	if f.Value != nil {
+		if f.Value.Min != nil && m.Value < *f.Value.Min {
+			return false
+		}
+		if f.Value.Max != nil && m.Value > *f.Value.Max {
+			return false
+		}
+	}
+
+	for i := range f.And {
+		if !f.And[i].Matches(m) {
+			return false
+		}
+	}
+	if len(f.Or) > 0 {
+		any := false
+		for i := range f.Or {
+			if f.Or[i].Matches(m) {
+				any = true
+				break
+			}
+		}
+		if !any {
+			return false
+		}
+	}
+	if f.Not != nil && f.Not.Matches(m) {
+		return false
+	}
+	return true
+}
+
+// IsFiltered checks if metrics is filtered with a given filter
+func (m *Metric) IsFiltered(f Filter) bool {
+	return f.Matches(m)
+}
@@ -0,0 +1,135 @@
+package metric_test
+
+import (
+	"encoding/json"
+	"fullerite/metric"
+
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func filterMetric() metric.Metric {
+	m := metric.WithValue("app.requests.latency", 42)
+	m.AddDimension("host", "web-01.example.com")
+	m.AddDimension("service", "api")
+	return m
+}
+
+func parseFilter(t *testing.T, raw string) metric.Filter {
+	f, err := metric.ParseFilter([]byte(raw))
+	require.Nil(t, err, raw)
+	return f
+}
+
+func TestFilterEmptyMatchesAll(t *testing.T) {
+	m := filterMetric()
+	assert.True(t, m.IsFiltered(metric.Filter{}))
+	assert.True(t, m.IsFiltered(parseFilter(t, `{}`)))
+}
+
+func TestFilterOptionalType(t *testing.T) {
+	m := filterMetric()
+	assert.True(t, m.IsFiltered(metric.NewFilter("^app", "", nil)))
+	assert.True(t, m.IsFiltered(metric.NewFilter("^app", "gauge", nil)))
+	assert.False(t, m.IsFiltered(metric.NewFilter("^app", "counter", nil)))
+}
+
+func TestFilterBackwardCompatibleJSON(t *testing.T) {
+	m := filterMetric()
+	f := parseFilter(t, `{"name": "latency$", "type": "gauge", "dimensions": {"service": "api"}}`)
+	assert.True(t, m.IsFiltered(f))
+
+	f = parseFilter(t, `{"name": "latency$", "type": "gauge", "dimensions": {"service": "web"}}`)
+	assert.False(t, m.IsFiltered(f))
+
+	old := metric.NewFilter("a", "gauge", map[string]string{"b": "c"})
+	assert.Equal(t, `{"name":"a","type":"gauge","dimensions":{"b":"c"}}`, old.ToJSON())
+}
+
+func TestFilterGlobs(t *testing.T) {
+	m := filterMetric()
+	assert.True(t, m.IsFiltered(parseFilter(t, `{"nameGlob": "app.*.latency"}`)))
+	assert.True(t, m.IsFiltered(parseFilter(t, `{"nameGlob": "app.*"}`)))
+	assert.True(t, m.IsFiltered(parseFilter(t, `{"nameGlob": "app?requests*"}`)))
+	assert.False(t, m.IsFiltered(parseFilter(t, `{"nameGlob": "requests.*"}`)))
+	assert.True(t, m.IsFiltered(parseFilter(t, `{"dimensionGlob": {"host": "web-??.*"}}`)))
+	assert.False(t, m.IsFiltered(parseFilter(t, `{"dimensionGlob": {"host": "db-*"}}`)))
+	assert.False(t, m.IsFiltered(parseFilter(t, `{"dimensionGlob": {"missing": "*"}}`)))
+}
+
+func TestFilterDimensionRegexAndAbsent(t *testing.T) {
+	m := filterMetric()
+	assert.True(t, m.IsFiltered(parseFilter(t, `{"dimensionRegex": {"host": "^web-\\d+\\."}}`)))
+	assert.False(t, m.IsFiltered(parseFilter(t, `{"dimensionRegex": {"host": "^db"}}`)))
+	assert.True(t, m.IsFiltered(parseFilter(t, `{"dimensionAbsent": ["region"]}`)))
+	assert.False(t, m.IsFiltered(parseFilter(t, `{"dimensionAbsent": ["service"]}`)))
+}
+
+func TestFilterValueRange(t *testing.T) {
+	m := filterMetric()
+	assert.True(t, m.IsFiltered(parseFilter(t, `{"value": {"min": 42, "max": 42}}`)))
+	assert.True(t, m.IsFiltered(parseFilter(t, `{"value": {"min": 10}}`)))
+	assert.False(t, m.IsFiltered(parseFilter(t, `{"value": {"max": 10}}`)))
+	assert.False(t, m.IsFiltered(parseFilter(t, `{"value": {"min": 43}}`)))
+}
+
+func TestFilterComposition(t *testing.T) {
+	m := filterMetric()
+	f := parseFilter(t, `{
+		"and": [{"nameGlob": "app.*"}, {"dimensions": {"service": "api"}}],
+		"or": [{"dimensions": {"service": "web"}}, {"value": {"min": 40}}],
+		"not": {"dimensionGlob": {"host": "db-*"}}
+	}`)
+	assert.True(t, m.IsFiltered(f))
+
+	f = parseFilter(t, `{"or": [{"name": "^db"}, {"name": "^cache"}]}`)
+	assert.False(t, m.IsFiltered(f))
+
+	f = parseFilter(t, `{"not": {"name": "latency"}}`)
+	assert.False(t, m.IsFiltered(f))
+
+	f = parseFilter(t, `{"and": [{"name": "^app"}, {"not": {"type": "counter"}}]}`)
+	assert.True(t, m.IsFiltered(f))
+}
+
+func TestFilterInvalid(t *testing.T) {
+	_, err := metric.ParseFilter([]byte(`{"or": [{"dimensionRegex": {"host": "("}}]}`))
+	assert.NotNil(t, err)
+	_, err = metric.ParseFilter([]byte(`{"name": "a", "nameGlob": "a"}`))
+	assert.NotNil(t, err)
+
+	// an invalid filter which was not compiled matches nothing instead of panicking
+	var f metric.Filter
+	require.Nil(t, json.Unmarshal([]byte(`{"name": "("}`), &f))
+	m := filterMetric()
+	assert.False(t, m.IsFiltered(f))
+}
+
+func TestFilterUncompiled(t *testing.T) {
+	var f metric.Filter
+	require.Nil(t, json.Unmarshal([]byte(`{"nameGlob": "app.*", "not": {"dimensionAbsent": ["host"]}}`), &f))
+	m := filterMetric()
+	assert.True(t, m.IsFiltered(f))
+}
+
+func TestFilterMatchesDoesNotCompileTheCaller(t *testing.T) {
+	var f metric.Filter
+	require.Nil(t, json.Unmarshal([]byte(`{"and": [{"nameGlob": "app.*"}], "not": {"name": "^db"}}`), &f))
+	before := f.ToJSON()
+	beforeAnd := f.And[0]
+	beforeNot := *f.Not
+
+	m := filterMetric()
+	assert.True(t, m.IsFiltered(f))
+	assert.Equal(t, before, f.ToJSON())
+	assert.Equal(t, beforeAnd, f.And[0], "nested filters are not compiled in place")
+	assert.Equal(t, beforeNot, *f.Not)
+}
+
+func TestNewFilterInvalid(t *testing.T) {
+	f := metric.NewFilter("(", "", nil)
+	m := filterMetric()
+	assert.False(t, m.IsFiltered(f))
+}
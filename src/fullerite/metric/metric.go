@@ -3,7 +3,6 @@ package metric
 import (
 	"encoding/json"
 	"fmt"
-	"regexp"
 	"strings"
 	"time"
 )
@@ -24,6 +23,9 @@ type Metric struct {
 	Dimensions map[string]string `json:"dimensions"`
 	Buffered   bool              `json:"buffered"`
 	Time       time.Time         `json:"time"`
+
+	// Distribution is only set for histograms and timers
+	Distribution *Distribution `json:"distribution,omitempty"`
 }
 
 // New returns a new metric with name. Default metric type is "gauge"
@@ -51,32 +53,6 @@ func NewExt(name string, typ string, val float64, d map[string]string, t time.Ti
 	}
 }
 
-// Filter provides a struct that can filter a metric by Name (regex), type, dimension (subset of Dimensions)
-type Filter struct {
-	Name       string            `json:"name"`
-	MetricType string            `json:"type"`
-	Dimensions map[string]string `json:"dimensions"`
-}
-
-// ToJSON Transforms Filter to JSON
-func (f *Filter) ToJSON() string {
-	b, err := json.Marshal(f)
-	if err != nil {
-		fmt.Println(err)
-		return "{}"
-	}
-	return string(b)
-}
-
-// NewFilter returns a Filter with compiled regex
-func NewFilter(name string, t string, d map[string]string) Filter {
-	return Filter{
-		Name:       name,
-		MetricType: t,
-		Dimensions: d,
-	}
-}
-
 // WithValue returns metric with value of type Gauge
 func WithValue(name string, value float64) Metric {
 	metric := New(name)
@@ -183,19 +159,3 @@ func (m *Metric) IsSubDim(other map[string]string) bool {
 	}
 	return true
 }
-
-// IsFiltered checks if metrics is filtered with a given filter
-func (m *Metric) IsFiltered(f Filter) bool {
-	if !m.IsSubDim(f.Dimensions) {
-		return false
-	}
-	if m.MetricType != f.MetricType {
-		return false
-	}
-	// TODO: Precompile regex to speed up matching
-	if !regexp.MustCompile(f.Name).MatchString(m.Name) {
-		return false
-	}
-
-	return true
-}
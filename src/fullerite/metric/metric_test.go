@@ -410,3 +410,13 @@ func TestIsFiltered(t *testing.T) {
 	f = metric.NewFilter("Fail.*", "gauge", good)
 	assert.False(t, m.IsFiltered(f), "Should not map due to Name")
 }
+
+func TestFilterCompile(t *testing.T) {
+	f := metric.Filter{Name: "^Test", MetricType: "gauge"}
+	assert.Nil(t, f.Compile())
+	m := metric.New("TestMetric")
+	assert.True(t, m.IsFiltered(f))
+
+	f = metric.Filter{Name: "(", MetricType: "gauge"}
+	assert.NotNil(t, f.Compile())
+}
@@ -22,7 +22,8 @@ type HTTPAliveResponse struct {
 	Header     http.Header
 }
 
-// Configure the http connection
+// Configure the http connection, timeout bounds the dial as well as the
+// whole request including reading the body
 func (connection *HTTPAlive) Configure(timeout time.Duration,
 	aliveDuration time.Duration,
 	maxIdleConnections int) {
@@ -39,6 +40,7 @@ func (connection *HTTPAlive) Configure(timeout time.Duration,
 	if connection.client == nil {
 		connection.client = &http.Client{
 			Transport: connection.transport,
+			Timeout:   timeout,
 		}
 	}
 }
@@ -33,3 +33,21 @@ func TestMakeRequest(t *testing.T) {
 	assert.Equal(t, string(resp.Body), "done\n")
 	assert.Empty(t, httpClient.customHeader)
 }
+
+func TestMakeRequestTimeout(t *testing.T) {
+	release := make(chan bool)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// accepts the connection but stalls
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	httpClient := new(HTTPAlive)
+	httpClient.Configure(100*time.Millisecond, time.Minute, 1)
+
+	start := time.Now()
+	_, err := httpClient.MakeRequest("GET", ts.URL, nil)
+	assert.NotNil(t, err)
+	assert.True(t, time.Since(start) < 5*time.Second, "the request should time out")
+}